@@ -0,0 +1,427 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/linkedin/goavro"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Error codes of the Confluent Schema Registry REST API.
+const (
+	confluentSubjectNotFound  = 40401
+	confluentVersionNotFound  = 40402
+	confluentSchemaNotFound   = 40403
+	confluentInvalidSchema    = 42201
+	confluentInvalidVersion   = 42202
+	confluentInvalidLevel     = 42203
+	confluentIncompatible     = 409
+	confluentMethodNotAllowed = 405
+	confluentStoreError       = 50001
+)
+
+// confluentSchemaDTO is the request body of all Confluent endpoints which take a schema.
+type confluentSchemaDTO struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+// confluentVersionDTO is the response body of the Confluent endpoints which return a version of a subject.
+type confluentVersionDTO struct {
+	Subject string `json:"subject"`
+	ID      int32  `json:"id"`
+	Version uint   `json:"version"`
+	Schema  string `json:"schema"`
+}
+
+// confluentConfigDTO is the request and response body of the Confluent config endpoints.
+type confluentConfigDTO struct {
+	Compatibility      schema.Compatibility `json:"compatibility,omitempty"`
+	CompatibilityLevel schema.Compatibility `json:"compatibilityLevel,omitempty"`
+}
+
+// confluentAPI serves the parts of the Confluent Schema Registry REST API that are used by serializers and tools.
+// Subjects are the names of versioned aliases. Since Confluent versions start at 1,
+// version n of a subject is the alias {subject}-v{n-1}. Schema IDs are the integer IDs of the repo's schemata.
+type confluentAPI struct {
+	repo schema.LocalRepo
+	// writes is nil, if the explorer is read-only.
+	writes *writeAPI
+}
+
+// handler constructs the handler serving the Confluent API.
+func (c *confluentAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/subjects", c.handleSubjects)
+	mux.HandleFunc("/subjects/", c.handleSubject)
+	mux.HandleFunc("/schemas/ids/", c.handleSchemaByID)
+	mux.HandleFunc("/schemas/types", c.handleSchemaTypes)
+	mux.HandleFunc("/compatibility/subjects/", c.handleCompatibility)
+	mux.HandleFunc("/config", c.handleGlobalConfig)
+	mux.HandleFunc("/config/", c.handleConfig)
+	return mux
+}
+
+func writeConfluent(writer http.ResponseWriter, status int, data interface{}) {
+	ret, err := json.Marshal(data)
+	if err != nil {
+		status = http.StatusInternalServerError
+		ret, _ = json.Marshal(map[string]interface{}{"error_code": confluentStoreError, "message": err.Error()})
+		log.Println(err)
+	}
+	writer.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	writer.WriteHeader(status)
+	_, _ = writer.Write(ret)
+}
+
+func confluentError(writer http.ResponseWriter, status int, code int, format string, args ...interface{}) {
+	writeConfluent(writer, status, map[string]interface{}{"error_code": code, "message": fmt.Sprintf(format, args...)})
+}
+
+// pathSegments splits the path following the prefix into unescaped segments.
+func pathSegments(request *http.Request, prefix string) ([]string, error) {
+	segments := strings.Split(strings.TrimPrefix(request.URL.EscapedPath(), prefix), "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+		segments[i] = unescaped
+	}
+	return segments, nil
+}
+
+// readSchema reads and validates the schema in the request body.
+func readSchema(writer http.ResponseWriter, request *http.Request) (string, bool) {
+	var body confluentSchemaDTO
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		confluentError(writer, http.StatusBadRequest, http.StatusBadRequest, "%v", err)
+		return "", false
+	}
+	if body.SchemaType != "" && body.SchemaType != "AVRO" {
+		confluentError(writer, http.StatusUnprocessableEntity, confluentInvalidSchema, "Unsupported schema type %v", body.SchemaType)
+		return "", false
+	}
+	_, err = goavro.NewCodec(body.Schema)
+	if err != nil {
+		confluentError(writer, http.StatusUnprocessableEntity, confluentInvalidSchema, "Invalid schema: %v", err)
+		return "", false
+	}
+	return body.Schema, true
+}
+
+// version looks up a version of a subject given as a Confluent version number, "latest" or -1.
+// It responds with an error and returns false, if the version does not exist.
+func (c *confluentAPI) version(writer http.ResponseWriter, subject string, version string) (confluentVersionDTO, bool) {
+	latest, ok := c.repo.LatestVersion(subject)
+	if !ok {
+		confluentError(writer, http.StatusNotFound, confluentSubjectNotFound, "Subject '%v' not found.", subject)
+		return confluentVersionDTO{}, false
+	}
+	nameVersion := latest
+	if version != "latest" && version != "-1" {
+		number, err := strconv.ParseUint(version, 10, 0)
+		if err != nil || number < 1 {
+			confluentError(writer, http.StatusUnprocessableEntity, confluentInvalidVersion, "The specified version '%v' is not a valid version id.", version)
+			return confluentVersionDTO{}, false
+		}
+		nameVersion.Version = uint(number - 1)
+	}
+
+	found, ok := c.describe(nameVersion)
+	if !ok {
+		confluentError(writer, http.StatusNotFound, confluentVersionNotFound, "Version %v not found.", version)
+	}
+	return found, ok
+}
+
+// describe looks up the schema of a version.
+func (c *confluentAPI) describe(version schema.NameVersion) (confluentVersionDTO, bool) {
+	schemaUUID, ok := c.repo.WhoIs(version.Alias())
+	if !ok {
+		return confluentVersionDTO{}, false
+	}
+	specification, ok := c.repo.GetSpecification(schemaUUID)
+	if !ok {
+		return confluentVersionDTO{}, false
+	}
+	id, _ := c.repo.SchemaID(schemaUUID)
+	return confluentVersionDTO{Subject: version.Name, ID: id, Version: version.Version + 1, Schema: specification}, true
+}
+
+// lookup finds the version of a subject whose schema equals the given specification.
+func (c *confluentAPI) lookup(subject string, specification string) (confluentVersionDTO, bool) {
+	fingerprint, err := schema.Fingerprint(specification)
+	if err != nil {
+		return confluentVersionDTO{}, false
+	}
+	for _, version := range c.repo.ListVersions(subject) {
+		schemaUUID, ok := c.repo.WhoIs(version.Alias())
+		if !ok {
+			continue
+		}
+		if other, ok := c.repo.Schemata.Fingerprint(schemaUUID); ok && other == fingerprint {
+			return c.describe(version)
+		}
+	}
+	return confluentVersionDTO{}, false
+}
+
+func (c *confluentAPI) handleSubjects(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		confluentError(writer, http.StatusMethodNotAllowed, confluentMethodNotAllowed, "Method not allowed")
+		return
+	}
+	writeConfluent(writer, http.StatusOK, c.repo.Versions.Names())
+}
+
+func (c *confluentAPI) handleSubject(writer http.ResponseWriter, request *http.Request) {
+	segments, err := pathSegments(request, "/subjects/")
+	if err != nil {
+		confluentError(writer, http.StatusBadRequest, http.StatusBadRequest, "%v", err)
+		return
+	}
+	subject := segments[0]
+
+	switch {
+	case len(segments) == 1 && request.Method == http.MethodPost:
+		c.handleLookup(writer, request, subject)
+	case len(segments) == 1 && request.Method == http.MethodDelete:
+		if deleted, ok := c.delete(writer, request, subject, c.repo.ListVersions(subject)); ok {
+			writeConfluent(writer, http.StatusOK, deleted)
+		}
+	case len(segments) == 2 && segments[1] == "versions" && request.Method == http.MethodGet:
+		versions := c.repo.ListVersions(subject)
+		if len(versions) == 0 {
+			confluentError(writer, http.StatusNotFound, confluentSubjectNotFound, "Subject '%v' not found.", subject)
+			return
+		}
+		numbers := make([]uint, 0, len(versions))
+		for _, version := range versions {
+			numbers = append(numbers, version.Version+1)
+		}
+		writeConfluent(writer, http.StatusOK, numbers)
+	case len(segments) == 2 && segments[1] == "versions" && request.Method == http.MethodPost:
+		c.handleRegister(writer, request, subject)
+	case len(segments) == 3 && segments[1] == "versions" && request.Method == http.MethodGet:
+		if found, ok := c.version(writer, subject, segments[2]); ok {
+			writeConfluent(writer, http.StatusOK, found)
+		}
+	case len(segments) == 3 && segments[1] == "versions" && request.Method == http.MethodDelete:
+		if found, ok := c.version(writer, subject, segments[2]); ok {
+			version := schema.NameVersion{Name: subject, Version: found.Version - 1}
+			if deleted, ok := c.delete(writer, request, subject, []schema.NameVersion{version}); ok {
+				writeConfluent(writer, http.StatusOK, deleted[0])
+			}
+		}
+	case len(segments) == 4 && segments[1] == "versions" && segments[3] == "schema" && request.Method == http.MethodGet:
+		if found, ok := c.version(writer, subject, segments[2]); ok {
+			writer.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+			_, _ = writer.Write([]byte(found.Schema))
+		}
+	default:
+		confluentError(writer, http.StatusNotFound, http.StatusNotFound, "HTTP %v %v not found", request.Method, request.URL.Path)
+	}
+}
+
+// handleLookup checks whether a schema has already been registered under a subject.
+func (c *confluentAPI) handleLookup(writer http.ResponseWriter, request *http.Request, subject string) {
+	specification, ok := readSchema(writer, request)
+	if !ok {
+		return
+	}
+	if _, ok := c.repo.LatestVersion(subject); !ok {
+		confluentError(writer, http.StatusNotFound, confluentSubjectNotFound, "Subject '%v' not found.", subject)
+		return
+	}
+	found, ok := c.lookup(subject, specification)
+	if !ok {
+		confluentError(writer, http.StatusNotFound, confluentSchemaNotFound, "Schema not found")
+		return
+	}
+	writeConfluent(writer, http.StatusOK, found)
+}
+
+// handleRegister publishes a schema as the next version of a subject, unless it is already registered.
+func (c *confluentAPI) handleRegister(writer http.ResponseWriter, request *http.Request, subject string) {
+	specification, ok := readSchema(writer, request)
+	if !ok {
+		return
+	}
+	if found, ok := c.lookup(subject, specification); ok {
+		writeConfluent(writer, http.StatusOK, map[string]int32{"id": found.ID})
+		return
+	}
+	if c.writes == nil {
+		confluentError(writer, http.StatusMethodNotAllowed, confluentMethodNotAllowed, "The schema registry is read-only")
+		return
+	}
+
+	created, err := c.writes.publishVersion(subject, specification)
+	switch {
+	case errors.Is(err, schema.ErrIncompatible):
+		confluentError(writer, http.StatusConflict, confluentIncompatible, "%v", err)
+		return
+	case errors.Is(err, errNotReady):
+		confluentError(writer, http.StatusServiceUnavailable, confluentStoreError, "%v", err)
+		return
+	case err != nil:
+		confluentError(writer, http.StatusInternalServerError, confluentStoreError, "%v", err)
+		return
+	}
+	id, _ := c.repo.SchemaID(created.UUID)
+	writeConfluent(writer, http.StatusOK, map[string]int32{"id": id})
+}
+
+// delete deletes versions of a subject and returns their version numbers.
+// Only the versioned aliases are deleted, the schemata can still be looked up by their IDs.
+// Deletions are soft unless ?permanent=true is given.
+// It responds with an error and returns false, if the versions could not be deleted.
+func (c *confluentAPI) delete(writer http.ResponseWriter, request *http.Request, subject string, versions []schema.NameVersion) ([]uint, bool) {
+	if c.writes == nil {
+		confluentError(writer, http.StatusMethodNotAllowed, confluentMethodNotAllowed, "The schema registry is read-only")
+		return nil, false
+	}
+	if len(versions) == 0 {
+		confluentError(writer, http.StatusNotFound, confluentSubjectNotFound, "Subject '%v' not found.", subject)
+		return nil, false
+	}
+	mode := schema.SoftDelete
+	if permanent, _ := strconv.ParseBool(request.URL.Query().Get("permanent")); permanent {
+		mode = schema.HardDelete
+	}
+
+	err := c.writes.deleteVersions(versions, mode)
+	if err != nil {
+		confluentError(writer, http.StatusInternalServerError, confluentStoreError, "%v", err)
+		return nil, false
+	}
+	numbers := make([]uint, 0, len(versions))
+	for _, version := range versions {
+		numbers = append(numbers, version.Version+1)
+	}
+	return numbers, true
+}
+
+func (c *confluentAPI) handleSchemaByID(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		confluentError(writer, http.StatusMethodNotAllowed, confluentMethodNotAllowed, "Method not allowed")
+		return
+	}
+	segments, err := pathSegments(request, "/schemas/ids/")
+	if err != nil || len(segments) != 1 {
+		confluentError(writer, http.StatusNotFound, http.StatusNotFound, "HTTP %v %v not found", request.Method, request.URL.Path)
+		return
+	}
+	id, err := strconv.ParseInt(segments[0], 10, 32)
+	schemaUUID, ok := uuid.Nil, false
+	if err == nil {
+		schemaUUID, ok = c.repo.WhoHasID(int32(id))
+	}
+	specification := ""
+	if ok {
+		specification, ok = c.repo.GetSpecification(schemaUUID)
+	}
+	if !ok {
+		confluentError(writer, http.StatusNotFound, confluentSchemaNotFound, "Schema %v not found", segments[0])
+		return
+	}
+	writeConfluent(writer, http.StatusOK, confluentSchemaDTO{Schema: specification})
+}
+
+func (c *confluentAPI) handleSchemaTypes(writer http.ResponseWriter, request *http.Request) {
+	writeConfluent(writer, http.StatusOK, []string{"AVRO"})
+}
+
+// handleCompatibility checks whether a schema is compatible with a version of a subject,
+// according to the compatibility level of the subject.
+func (c *confluentAPI) handleCompatibility(writer http.ResponseWriter, request *http.Request) {
+	segments, err := pathSegments(request, "/compatibility/subjects/")
+	if err != nil || len(segments) != 3 || segments[1] != "versions" || request.Method != http.MethodPost {
+		confluentError(writer, http.StatusNotFound, http.StatusNotFound, "HTTP %v %v not found", request.Method, request.URL.Path)
+		return
+	}
+	subject := segments[0]
+	specification, ok := readSchema(writer, request)
+	if !ok {
+		return
+	}
+	previous, ok := c.version(writer, subject, segments[2])
+	if !ok {
+		return
+	}
+
+	err = c.repo.GetCompatibility(subject).Check(specification, previous.Schema)
+	if err != nil && !errors.Is(err, schema.ErrIncompatible) {
+		confluentError(writer, http.StatusInternalServerError, confluentStoreError, "%v", err)
+		return
+	}
+	response := map[string]interface{}{"is_compatible": err == nil}
+	if err != nil && request.URL.Query().Get("verbose") == "true" {
+		response["messages"] = []string{err.Error()}
+	}
+	writeConfluent(writer, http.StatusOK, response)
+}
+
+func (c *confluentAPI) handleGlobalConfig(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		confluentError(writer, http.StatusMethodNotAllowed, confluentMethodNotAllowed, "The global compatibility level cannot be changed")
+		return
+	}
+	writeConfluent(writer, http.StatusOK, confluentConfigDTO{CompatibilityLevel: schema.DefaultCompatibility})
+}
+
+func (c *confluentAPI) handleConfig(writer http.ResponseWriter, request *http.Request) {
+	segments, err := pathSegments(request, "/config/")
+	if err != nil || len(segments) != 1 {
+		confluentError(writer, http.StatusNotFound, http.StatusNotFound, "HTTP %v %v not found", request.Method, request.URL.Path)
+		return
+	}
+	subject := segments[0]
+
+	switch request.Method {
+	case http.MethodGet:
+		writeConfluent(writer, http.StatusOK, confluentConfigDTO{CompatibilityLevel: c.repo.GetCompatibility(subject)})
+	case http.MethodPut:
+		if c.writes == nil {
+			confluentError(writer, http.StatusMethodNotAllowed, confluentMethodNotAllowed, "The schema registry is read-only")
+			return
+		}
+		var body confluentConfigDTO
+		err := json.NewDecoder(request.Body).Decode(&body)
+		if err != nil {
+			confluentError(writer, http.StatusBadRequest, http.StatusBadRequest, "%v", err)
+			return
+		}
+		level, err := schema.ParseCompatibility(body.Compatibility.String())
+		if err != nil {
+			confluentError(writer, http.StatusUnprocessableEntity, confluentInvalidLevel, "%v", err)
+			return
+		}
+		err = c.writes.updater.UpdateCompatibility(subject, level)
+		if err != nil {
+			confluentError(writer, http.StatusInternalServerError, confluentStoreError, "%v", err)
+			return
+		}
+		writeConfluent(writer, http.StatusOK, confluentConfigDTO{Compatibility: level})
+	default:
+		confluentError(writer, http.StatusMethodNotAllowed, confluentMethodNotAllowed, "Method not allowed")
+	}
+}
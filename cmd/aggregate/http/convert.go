@@ -0,0 +1,89 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// conversion converts a datum between the Avro binary and JSON encodings, e.g. func schema.Repo.DecodeJSON.
+type conversion func(schema uuid.UUID, datum []byte) ([]byte, error)
+
+// registerConvert registers the handlers converting a posted datum of the schema given by the uuid or alias parameter.
+// POST /datum/json converts a binary datum into JSON, POST /datum/binary converts a JSON datum into the binary encoding.
+func registerConvert(repo schema.LocalRepo) {
+	http.HandleFunc("/datum/json", func(writer http.ResponseWriter, request *http.Request) {
+		handleConvert(repo, writer, request, repo.DecodeJSON, "application/json")
+	})
+	http.HandleFunc("/datum/binary", func(writer http.ResponseWriter, request *http.Request) {
+		handleConvert(repo, writer, request, repo.EncodeJSON, "application/octet-stream")
+	})
+}
+
+// datumSchema looks up the schema given by the uuid or alias parameter.
+// It responds with an error and returns false, if the schema is not known.
+func datumSchema(repo schema.LocalRepo, writer http.ResponseWriter, request *http.Request) (uuid.UUID, bool) {
+	params := request.URL.Query()
+	if alias := params.Get("alias"); alias != "" {
+		schemaUUID, ok := repo.WhoIs(schema.Alias(alias))
+		if !ok {
+			http.Error(writer, fmt.Sprintf("Unknown alias %v", alias), http.StatusNotFound)
+		}
+		return schemaUUID, ok
+	}
+	if params.Get("uuid") == "" {
+		http.Error(writer, "Required params <uuid> or <alias>", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	schemaUUID, err := uuid.Parse(params.Get("uuid"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	if _, ok := repo.GetSpecification(schemaUUID); !ok {
+		http.Error(writer, fmt.Sprintf("Unknown schema %v", schemaUUID), http.StatusNotFound)
+		return uuid.Nil, false
+	}
+	return schemaUUID, true
+}
+
+func handleConvert(repo schema.LocalRepo, writer http.ResponseWriter, request *http.Request, convert conversion, contentType string) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	schemaUUID, ok := datumSchema(repo, writer, request)
+	if !ok {
+		return
+	}
+	datum, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	converted, err := convert(schemaUUID, datum)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Unable to convert the datum with schema %v: %v", schemaUUID, err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Access-Control-Allow-Origin", "*")
+	writer.Header().Set("Access-Control-Allow-Headers", "*")
+	_, err = writer.Write(converted)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,88 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/google/uuid"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// registerDecode registers the handler decoding posted messages for debugging, see func schema.LocalRepo.Inspect.
+// POST /decode takes a message in the request body, encoded as given by the encoding parameter: raw, base64 or hex.
+// Raw messages need the uuid or alias parameter. The framing is given by the framing parameter, or else it is raw
+// if the uuid or alias parameter is given and detected otherwise.
+func registerDecode(repo schema.LocalRepo) {
+	http.HandleFunc("/decode", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		params := request.URL.Query()
+		var framing schema.Framing
+		if params.Get("framing") != "" {
+			var err error
+			framing, err = schema.ParseFraming(params.Get("framing"))
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		raw := uuid.Nil
+		if params.Get("uuid") != "" || params.Get("alias") != "" {
+			var ok bool
+			raw, ok = datumSchema(repo, writer, request)
+			if !ok {
+				return
+			}
+		}
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		message, err := decodePayload(params.Get("encoding"), body)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		inspected, err := repo.Inspect(message, framing, raw)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		writeJSON(writer, inspected)
+	})
+}
+
+// decodePayload decodes a message posted in the given encoding. Whitespace is ignored in base64 and hex,
+// so that messages copied from logs may be posted as they are.
+func decodePayload(encoding string, payload []byte) ([]byte, error) {
+	text := strings.Join(strings.Fields(string(payload)), "")
+	switch encoding {
+	case "", "raw":
+		return payload, nil
+	case "base64":
+		text = strings.TrimRight(text, "=")
+		if strings.ContainsAny(text, "-_") {
+			return base64.RawURLEncoding.DecodeString(text)
+		}
+		return base64.RawStdEncoding.DecodeString(text)
+	case "hex":
+		return hex.DecodeString(strings.TrimPrefix(text, "0x"))
+	}
+	return nil, fmt.Errorf("unknown encoding %q, expected raw, base64 or hex", encoding)
+}
@@ -11,24 +11,41 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"github.com/google/uuid"
 	"github.com/strangedev/catchall"
 	schema "github.com/strangedev/kafka-schema/pkg"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 )
 
-var broker string
+var broker, namespace, topicPrefix, snapshotPath, confluentAddress string
+var snapshotInterval, writeTimeout time.Duration
+var readOnly bool
 
 func init() {
 	flag.StringVar(&broker, "broker", "broker0:9092", "URL of a Kafka broker")
+	flag.StringVar(&namespace, "namespace", "", "Namespace of the schema repository, which is prepended to its topic names.")
+	flag.StringVar(&topicPrefix, "topic-prefix", "", "Prefix of the schema repository's topic names.")
+	flag.StringVar(&snapshotPath, "snapshot", "", "Periodically write the repository to this file and restore it from there on start.")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", time.Minute, "Interval in which snapshots are written.")
+	flag.StringVar(&confluentAddress, "confluent", "", "Also serve the Confluent Schema Registry REST API on this address, e.g. :8081.")
+	flag.BoolVar(&readOnly, "read-only", false, "Do not serve the write API.")
+	flag.DurationVar(&writeTimeout, "write-timeout", 10*time.Second, "How long the write API waits for a published change to be observed.")
 }
 
 func writeJSON(writer http.ResponseWriter, data interface{}) {
+	writeJSONWithStatus(writer, http.StatusOK, data)
+}
+
+func writeJSONWithStatus(writer http.ResponseWriter, status int, data interface{}) {
 	ret, err := json.Marshal(data)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
@@ -39,6 +56,7 @@ func writeJSON(writer http.ResponseWriter, data interface{}) {
 	writer.Header().Set("Content-Type", "application/json")
 	writer.Header().Set("Access-Control-Allow-Origin", "*")
 	writer.Header().Set("Access-Control-Allow-Headers", "*")
+	writer.WriteHeader(status)
 	_, err = writer.Write(ret)
 }
 
@@ -46,25 +64,44 @@ func main() {
 	flag.Parse()
 	log.Printf("Broker: %v", broker)
 	
-	schemaRepo, err := schema.NewLocalRepo(broker)
+	options := schema.Options{Namespace: namespace, Prefix: topicPrefix}
+	schemaRepo, err := schema.NewLocalRepoWithOptions(broker, options)
 	catchall.CheckFatal("Unable to initialize schema repository", err)
 
+	if snapshotPath != "" {
+		err = schemaRepo.LoadSnapshot(snapshotPath)
+		if os.IsNotExist(err) {
+			log.Printf("No snapshot at %v yet, consuming all schemata", snapshotPath)
+		} else {
+			catchall.CheckFatal("Unable to restore snapshot", err)
+		}
+	}
+
 	stop, err := schemaRepo.Run()
 	catchall.CheckFatal("Unable to start schema repository", err)
 
+	if snapshotPath != "" {
+		schemaRepo.SnapshotPeriodically(snapshotPath, snapshotInterval)
+	}
+
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
 	go (func() {
 		for sig := range signals {
 			stop <- true
+			if snapshotPath != "" {
+				if err := schemaRepo.WriteSnapshot(snapshotPath); err != nil {
+					log.Println(err)
+				}
+			}
 			log.Panicf("Caught %v", sig)
 		}
 	})()
 
 	http.HandleFunc("/schema/list", func(writer http.ResponseWriter, request *http.Request) {
 		schemata := schemaRepo.ListSchemata()
-		schemaList := schema.SchemaListDTO{Schemata: schemata, Count: len(schemata)}
+		schemaList := schema.SchemaListDTO{Schemata: schemata, Count: len(schemata), LastID: schemaRepo.LastID()}
 
 		writeJSON(writer, schemaList)
 	})
@@ -78,10 +115,20 @@ func main() {
 		}
 
 		schemaUUIDs := params["uuid"]
-		if len(schemaUUIDs) < 1 {
-			http.Error(writer, "Required params <uuid>", http.StatusBadRequest)
+		if len(schemaUUIDs) < 1 && len(params["id"]) < 1 {
+			http.Error(writer, "Required params <uuid> or <id>", http.StatusBadRequest)
 			return
 		}
+		for _, idString := range params["id"] {
+			id, err := strconv.ParseInt(idString, 10, 32)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if schemaUUID, ok := schemaRepo.WhoHasID(int32(id)); ok {
+				schemaUUIDs = append(schemaUUIDs, schemaUUID.String())
+			}
+		}
 
 		schemata := schema.SchemataDTO{Schemata: make([]schema.SchemaDTO, 0, len(schemaUUIDs))}
 		for _, uuidString := range schemaUUIDs {
@@ -98,7 +145,13 @@ func main() {
 				continue
 			}
 
-			schemata.Schemata = append(schemata.Schemata, schema.SchemaDTO{UUID: schemaUUID, Specification: spec})
+			id, _ := schemaRepo.SchemaID(schemaUUID)
+			schemata.Schemata = append(schemata.Schemata, schema.SchemaDTO{
+				UUID:          schemaUUID,
+				ID:            id,
+				Specification: spec,
+				Deleted:       schemaRepo.Schemata.IsDeleted(schemaUUID),
+			})
 		}
 
 		writeJSON(writer, schemata)
@@ -139,6 +192,141 @@ func main() {
 		writeJSON(writer, aliases)
 	})
 
+	http.HandleFunc("/version/list", func(writer http.ResponseWriter, request *http.Request) {
+		name := request.URL.Query().Get("name")
+		if name == "" {
+			http.Error(writer, "Required params <name>", http.StatusBadRequest)
+			return
+		}
+
+		versions := schemaRepo.ListVersions(name)
+		versionList := schema.VersionListDTO{Name: name, Versions: make([]uint, 0, len(versions)), Count: len(versions)}
+		for _, version := range versions {
+			versionList.Versions = append(versionList.Versions, version.Version)
+		}
+
+		writeJSON(writer, versionList)
+	})
+
+	http.HandleFunc("/compatibility/describe", func(writer http.ResponseWriter, request *http.Request) {
+		names := request.URL.Query()["name"]
+		if len(names) < 1 {
+			http.Error(writer, "Required params <name>", http.StatusBadRequest)
+			return
+		}
+
+		compatibilities := make([]schema.CompatibilityDTO, 0, len(names))
+		for _, name := range names {
+			compatibilities = append(compatibilities, schema.CompatibilityDTO{Name: name, Compatibility: schemaRepo.GetCompatibility(name)})
+		}
+
+		writeJSON(writer, compatibilities)
+	})
+
+	http.HandleFunc("/compatibility/check", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// The next version and the versions to check against are only known once the repo has caught up
+		if !schemaRepo.Ready() {
+			http.Error(writer, errNotReady.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		params := request.URL.Query()
+		name := params.Get("name")
+		if name == "" {
+			http.Error(writer, "Required params <name>", http.StatusBadRequest)
+			return
+		}
+		version := schema.NewVersionOrigin(name)
+		if params.Get("version") != "" {
+			parsed, err := strconv.ParseUint(params.Get("version"), 10, 0)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			version.Version = uint(parsed)
+		} else if latest, ok := schemaRepo.LatestVersion(name); ok {
+			version.Version = latest.Version + 1
+		}
+		level := schemaRepo.GetCompatibility(name)
+		if params.Get("compatibility") != "" {
+			parsed, err := schema.ParseCompatibility(params.Get("compatibility"))
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			level = parsed
+		}
+		specification, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !validSpecification(writer, string(specification)) {
+			return
+		}
+
+		checked := schema.CompatibilityCheckDTO{Name: name, Version: version.Version, Alias: version.Alias(), Compatibility: level, Compatible: true}
+		err = schemaRepo.CheckCompatibility(version, string(specification), level)
+		if errors.Is(err, schema.ErrIncompatible) {
+			checked.Compatible = false
+			checked.Reason = err.Error()
+		} else if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(writer, checked)
+	})
+
+	http.HandleFunc("/violation/list", func(writer http.ResponseWriter, request *http.Request) {
+		violations := schemaRepo.ListViolations()
+		violationList := schema.ViolationListDTO{Violations: make([]schema.ViolationDTO, 0, len(violations)), Count: len(violations)}
+		for _, violation := range violations {
+			violationList.Violations = append(violationList.Violations, schema.ViolationDTO{
+				Alias:         violation.Alias,
+				UUID:          violation.UUID,
+				Compatibility: violation.Level,
+				Reason:        violation.Err.Error(),
+			})
+		}
+
+		writeJSON(writer, violationList)
+	})
+
+	http.HandleFunc("/ready", func(writer http.ResponseWriter, request *http.Request) {
+		if !schemaRepo.Ready() {
+			writeJSONWithStatus(writer, http.StatusServiceUnavailable, schema.ReadyDTO{Ready: false})
+			return
+		}
+		writeJSON(writer, schema.ReadyDTO{Ready: true})
+	})
+
+	http.HandleFunc("/topic/list", func(writer http.ResponseWriter, request *http.Request) {
+		writeJSON(writer, schemaRepo.Topics)
+	})
+
+	registerConvert(schemaRepo)
+	registerDecode(schemaRepo)
+
+	var writes *writeAPI
+	if !readOnly {
+		logWriter, err := schema.NewKafkaLogWriter(broker)
+		catchall.CheckFatal("Unable to initialize updater", err)
+		updater := schema.NewUpdaterWithLogAndSource(logWriter, options, schemaRepo)
+		writes = newWriteAPI(schemaRepo, updater, writeTimeout)
+		writes.register()
+	}
+
+	if confluentAddress != "" {
+		confluent := &confluentAPI{repo: schemaRepo, writes: writes}
+		go (func() {
+			err := http.ListenAndServe(confluentAddress, confluent.handler())
+			catchall.CheckFatal("Unable to serve Confluent API", err)
+		})()
+	}
+
 	err = http.ListenAndServe(":8080", nil)
 	if err != nil {
 		log.Println(err)
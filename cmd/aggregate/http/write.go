@@ -0,0 +1,362 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/linkedin/goavro"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNotObserved is returned when a change has been published, but has not been consumed by the explorer in time.
+var errNotObserved = errors.New("the change has been published, but has not been observed yet")
+
+// errNotReady is returned when a change depends on the repo's state, but the repo has not caught up yet.
+var errNotReady = errors.New("the explorer has not caught up with the schema log yet, retry later")
+
+// writeAPI publishes changes through an Updater and waits until they are observed by the explorer's repo.
+// Changes are published one after another, so that concurrent requests never publish the same version twice.
+type writeAPI struct {
+	lock    sync.Mutex
+	repo    schema.LocalRepo
+	updater schema.Updater
+	timeout time.Duration
+}
+
+// newWriteAPI constructs a writeAPI which refuses changes that violate the compatibility levels known to the repo.
+func newWriteAPI(repo schema.LocalRepo, updater schema.Updater, timeout time.Duration) *writeAPI {
+	return &writeAPI{
+		repo:    repo,
+		updater: schema.NewGuardedUpdater(updater, repo),
+		timeout: timeout,
+	}
+}
+
+// register registers all handlers of the write API.
+func (w *writeAPI) register() {
+	http.HandleFunc("/schema", w.handleCreateSchema)
+	http.HandleFunc("/schema/", w.handleUpdateSchema)
+	http.HandleFunc("/alias/", w.handleUpdateAlias)
+	http.HandleFunc("/subjects/", w.handleCreateVersion)
+}
+
+func readJSON(writer http.ResponseWriter, request *http.Request, data interface{}) bool {
+	err := json.NewDecoder(request.Body).Decode(data)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeError responds with the status code matching the error of a write.
+func writeError(writer http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, schema.ErrIncompatible):
+		status = http.StatusConflict
+	case errors.Is(err, errNotObserved):
+		status = http.StatusGatewayTimeout
+	case errors.Is(err, errNotReady):
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(writer, err.Error(), status)
+	log.Println(err)
+}
+
+// validSpecification checks that the specification is a valid Avro schema.
+func validSpecification(writer http.ResponseWriter, specification string) bool {
+	_, err := goavro.NewCodec(specification)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("This does not seem like a valid Avro schema: %v", err), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// publish publishes a change and waits until it has been observed by the explorer's repo.
+// If publishing fails or the change is not observed in time, the observer is removed with unobserve.
+func (w *writeAPI) publish(observed chan bool, unobserve func(), publish func() error) error {
+	err := publish()
+	if err != nil {
+		unobserve()
+		return err
+	}
+	select {
+	case <-observed:
+		return nil
+	case <-time.After(w.timeout):
+		unobserve()
+		return errNotObserved
+	}
+}
+
+func (w *writeAPI) updateSchema(schemaUUID uuid.UUID, specification string) error {
+	observed := w.repo.Schemata.Observe(schemaUUID)
+	unobserve := func() { w.repo.Schemata.Unobserve(schemaUUID, observed) }
+	return w.publish(observed, unobserve, func() error {
+		return w.updater.UpdateSchema(schemaUUID, specification)
+	})
+}
+
+func (w *writeAPI) updateAlias(alias schema.Alias, schemaUUID uuid.UUID) error {
+	observed := w.repo.Aliases.Observe(alias)
+	unobserve := func() { w.repo.Aliases.Unobserve(alias, observed) }
+	return w.publish(observed, unobserve, func() error {
+		return w.updater.UpdateAlias(alias.String(), schemaUUID)
+	})
+}
+
+func (w *writeAPI) deleteSchema(schemaUUID uuid.UUID, mode schema.DeleteMode) error {
+	observed := w.repo.Schemata.ObserveDeletion(schemaUUID)
+	unobserve := func() { w.repo.Schemata.UnobserveDeletion(schemaUUID, observed) }
+	return w.publish(observed, unobserve, func() error {
+		return w.updater.DeleteSchema(schemaUUID, mode)
+	})
+}
+
+func (w *writeAPI) deleteAlias(alias schema.Alias, mode schema.DeleteMode) error {
+	observed := w.repo.Aliases.ObserveDeletion(alias)
+	unobserve := func() { w.repo.Aliases.UnobserveDeletion(alias, observed) }
+	return w.publish(observed, unobserve, func() error {
+		return w.updater.DeleteAlias(alias.String(), mode)
+	})
+}
+
+// deleteMode reads the delete mode from the query, deletions are soft unless ?hard=true is given.
+func deleteMode(writer http.ResponseWriter, request *http.Request) (schema.DeleteMode, bool) {
+	hard := request.URL.Query().Get("hard")
+	if hard == "" {
+		return schema.SoftDelete, true
+	}
+	isHard, err := strconv.ParseBool(hard)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return schema.SoftDelete, false
+	}
+	if isHard {
+		return schema.HardDelete, true
+	}
+	return schema.SoftDelete, true
+}
+
+// handleCreateSchema publishes a schema under a new UUID.
+func (w *writeAPI) handleCreateSchema(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body schema.SchemaDTO
+	if !readJSON(writer, request, &body) || !validSpecification(writer, body.Specification) {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	created := schema.SchemaDTO{UUID: uuid.New(), Specification: body.Specification}
+	err := w.updateSchema(created.UUID, created.Specification)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+
+	writeJSONWithStatus(writer, http.StatusCreated, created)
+}
+
+// handleUpdateSchema publishes or deletes a schema under the UUID given in the path.
+func (w *writeAPI) handleUpdateSchema(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPut && request.Method != http.MethodDelete {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	schemaUUID, err := uuid.Parse(strings.TrimPrefix(request.URL.Path, "/schema/"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Method == http.MethodDelete {
+		w.handleDeleteSchema(writer, request, schemaUUID)
+		return
+	}
+	var body schema.SchemaDTO
+	if !readJSON(writer, request, &body) || !validSpecification(writer, body.Specification) {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	err = w.updateSchema(schemaUUID, body.Specification)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+
+	writeJSON(writer, schema.SchemaDTO{UUID: schemaUUID, Specification: body.Specification})
+}
+
+// handleDeleteSchema deletes the given schema, its aliases are kept.
+func (w *writeAPI) handleDeleteSchema(writer http.ResponseWriter, request *http.Request, schemaUUID uuid.UUID) {
+	mode, ok := deleteMode(writer, request)
+	if !ok {
+		return
+	}
+	specification, ok := w.repo.GetSpecification(schemaUUID)
+	if !ok {
+		http.Error(writer, fmt.Sprintf("Schema %v is unknown", schemaUUID), http.StatusNotFound)
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	err := w.deleteSchema(schemaUUID, mode)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+
+	writeJSON(writer, schema.SchemaDTO{UUID: schemaUUID, Specification: specification, Deleted: true})
+}
+
+// handleUpdateAlias points the alias given in the path at the UUID given in the body, or deletes it.
+func (w *writeAPI) handleUpdateAlias(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPut && request.Method != http.MethodDelete {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	alias := schema.Alias(strings.TrimPrefix(request.URL.Path, "/alias/"))
+	if alias == "" {
+		http.Error(writer, "Required path /alias/<alias>", http.StatusBadRequest)
+		return
+	}
+	if request.Method == http.MethodDelete {
+		w.handleDeleteAlias(writer, request, alias)
+		return
+	}
+	var body schema.AliasDTO
+	if !readJSON(writer, request, &body) {
+		return
+	}
+	if _, ok := w.repo.GetSpecification(body.UUID); !ok {
+		http.Error(writer, fmt.Sprintf("Schema %v is unknown", body.UUID), http.StatusUnprocessableEntity)
+		return
+	}
+	if w.repo.Schemata.IsDeleted(body.UUID) {
+		http.Error(writer, fmt.Sprintf("Schema %v has been deleted", body.UUID), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	err := w.updateAlias(alias, body.UUID)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+
+	writeJSON(writer, schema.AliasDTO{Alias: alias, UUID: body.UUID})
+}
+
+// handleDeleteAlias deletes the given alias, the schema it refers to is kept.
+func (w *writeAPI) handleDeleteAlias(writer http.ResponseWriter, request *http.Request, alias schema.Alias) {
+	mode, ok := deleteMode(writer, request)
+	if !ok {
+		return
+	}
+	schemaUUID, ok := w.repo.WhoIs(alias)
+	if !ok {
+		http.Error(writer, fmt.Sprintf("Alias %v is unknown", alias), http.StatusNotFound)
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	err := w.deleteAlias(alias, mode)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+
+	writeJSON(writer, schema.AliasDTO{Alias: alias, UUID: schemaUUID})
+}
+
+// handleCreateVersion publishes a schema as the next version of the name given in the path.
+func (w *writeAPI) handleCreateVersion(writer http.ResponseWriter, request *http.Request) {
+	path := strings.TrimPrefix(request.URL.Path, "/subjects/")
+	if !strings.HasSuffix(path, "/versions") || request.Method != http.MethodPost {
+		http.NotFound(writer, request)
+		return
+	}
+	name := strings.TrimSuffix(path, "/versions")
+	if name == "" {
+		http.Error(writer, "Required path /subjects/<name>/versions", http.StatusBadRequest)
+		return
+	}
+	var body schema.SchemaDTO
+	if !readJSON(writer, request, &body) || !validSpecification(writer, body.Specification) {
+		return
+	}
+
+	created, err := w.publishVersion(name, body.Specification)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+
+	writeJSONWithStatus(writer, http.StatusCreated, created)
+}
+
+// publishVersion publishes a schema as the next version of the given name.
+// The next version is only known once the repo has caught up, it is refused with errNotReady before.
+func (w *writeAPI) publishVersion(name string, specification string) (schema.SubjectVersionDTO, error) {
+	if !w.repo.Ready() {
+		return schema.SubjectVersionDTO{}, errNotReady
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	version := schema.NewVersionOrigin(name)
+	if latest, ok := w.repo.LatestVersion(name); ok {
+		version.Version = latest.Version + 1
+	}
+	// The schema is checked before it is published, so that no orphaned schema is left behind if it is refused.
+	level := w.repo.GetCompatibility(name)
+	err := w.repo.CheckCompatibility(version, specification, level)
+	if err != nil {
+		return schema.SubjectVersionDTO{}, fmt.Errorf("refusing to publish %v with compatibility level %v: %w", version, level, err)
+	}
+
+	created := schema.SubjectVersionDTO{Name: name, Version: version.Version, Alias: version.Alias(), UUID: uuid.New()}
+	err = w.updateSchema(created.UUID, specification)
+	if err == nil {
+		err = w.updateAlias(created.Alias, created.UUID)
+	}
+	return created, err
+}
+
+// deleteVersions deletes the versioned aliases of the given versions.
+func (w *writeAPI) deleteVersions(versions []schema.NameVersion, mode schema.DeleteMode) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, version := range versions {
+		err := w.deleteAlias(version.Alias(), mode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
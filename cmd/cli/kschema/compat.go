@@ -0,0 +1,87 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	schema "github.com/strangedev/kafka-schema/pkg"
+)
+
+// incompatibility is a violation of a compatibility level reported by the explorer.
+type incompatibility struct {
+	reason string
+}
+
+func (i incompatibility) Error() string {
+	return i.reason
+}
+
+func (i incompatibility) Unwrap() error {
+	return schema.ErrIncompatible
+}
+
+// checkVersion has the explorer check whether the specification may be published as the given version of the name,
+// or as its next version if version is negative. If level is empty, the compatibility level of the name is used.
+// It returns the checked version, violations are reported by an error wrapping schema.ErrIncompatible.
+func checkVersion(explorer explorerClient, name string, version int, specification string, level string) (schema.CompatibilityCheckDTO, error) {
+	if level != "" {
+		if _, err := schema.ParseCompatibility(level); err != nil {
+			return schema.CompatibilityCheckDTO{}, usageError{err}
+		}
+	}
+	checked, err := explorer.checkCompatibility(name, version, specification, level)
+	if err != nil {
+		return checked, err
+	}
+	if !checked.Compatible {
+		return checked, fmt.Errorf("refusing to publish %v with compatibility level %v: %w", checked.Alias, checked.Compatibility, incompatibility{checked.Reason})
+	}
+	return checked, nil
+}
+
+// CompatibilityResultDTO is the result of the check-compat command.
+type CompatibilityResultDTO struct {
+	schema.CompatibilityCheckDTO
+}
+
+func (c CompatibilityResultDTO) String() string {
+	if c.Compatible {
+		return fmt.Sprintf("%v is compatible (%v)", c.Alias, c.Compatibility)
+	}
+	return fmt.Sprintf("%v is incompatible (%v): %v", c.Alias, c.Compatibility, c.Reason)
+}
+
+func runCheckCompat(args []string) error {
+	flags, opts := newFlagSet("check-compat", "-name NAME [-version VERSION] [-file FILE | -from-url URL]")
+	name := flags.String("name", "", "The name to check the specification against")
+	version := flags.Int("version", -1, "Check the specification as this version rather than as the next version.")
+	level := flags.String("compatibility", "", "Check against this compatibility level rather than the level of the name.")
+	source := registerSource(flags)
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return usagef("the name of the schema is required")
+	}
+
+	specification, err := source.read()
+	if err != nil {
+		return err
+	}
+	checked, err := checkVersion(opts.explorerClient(), *name, *version, specification, *level)
+	if err != nil && !errors.Is(err, schema.ErrIncompatible) {
+		return err
+	}
+	if printErr := opts.print(CompatibilityResultDTO{checked}); printErr != nil {
+		return printErr
+	}
+	return err
+}
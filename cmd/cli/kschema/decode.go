@@ -0,0 +1,80 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DecodedDTO is the result of the decode command.
+type DecodedDTO struct {
+	schema.InspectedDTO
+}
+
+func (d DecodedDTO) String() string {
+	versions := make([]string, 0, len(d.Versions))
+	for _, version := range d.Versions {
+		versions = append(versions, version.Alias.String())
+	}
+	writer := d.UUID.String()
+	if len(versions) > 0 {
+		writer = fmt.Sprintf("%v (%v)", writer, strings.Join(versions, ", "))
+	}
+	return fmt.Sprintf("%v message written with %v\n%v", d.Framing, writer, indentSpecification(string(d.Datum)))
+}
+
+func runDecode(args []string) error {
+	flags, opts := newFlagSet("decode", "[-encoding raw|base64|hex] [-framing FRAMING] [-schema UUID|ALIAS|NAME] [MESSAGE]")
+	encoding := flags.String("encoding", "raw", "Encoding of the message: raw, base64 or hex")
+	framing := flags.String("framing", "", "Framing of the message: raw, uuid, single-object or confluent. If empty, it is raw if -schema is given and detected otherwise")
+	raw := flags.String("schema", "", "Schema of raw messages")
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return usagef("expected at most one message, the message is read from stdin if it is not given")
+	}
+	if *framing != "" {
+		if _, err := schema.ParseFraming(*framing); err != nil {
+			return usageError{err}
+		}
+	}
+
+	var message []byte
+	if flags.NArg() == 1 {
+		message = []byte(flags.Arg(0))
+	} else {
+		read, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		message = read
+	}
+
+	explorer := opts.explorerClient()
+	query := url.Values{"encoding": {*encoding}, "framing": {*framing}}
+	if *raw != "" {
+		found, _, err := explorer.resolveLatest(*raw)
+		if err != nil {
+			return err
+		}
+		query.Set("uuid", found.UUID.String())
+	}
+	inspected, err := explorer.decode(message, query)
+	if err != nil {
+		return err
+	}
+	return opts.print(DecodedDTO{inspected})
+}
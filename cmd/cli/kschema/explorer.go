@@ -0,0 +1,237 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// explorerClient reads the schema repository through the schema explorer.
+type explorerClient struct {
+	address string
+}
+
+func (e explorerClient) get(route string, query url.Values, response interface{}) error {
+	resp, err := http.Get(fmt.Sprintf("http://%v%v?%v", e.address, route, query.Encode()))
+	return e.read(resp, err, response)
+}
+
+func (e explorerClient) post(route string, query url.Values, body []byte, response interface{}) error {
+	resp, err := http.Post(fmt.Sprintf("http://%v%v?%v", e.address, route, query.Encode()), "application/octet-stream", bytes.NewReader(body))
+	return e.read(resp, err, response)
+}
+
+// read unmarshals the response of the explorer, if the request has succeeded.
+func (e explorerClient) read(resp *http.Response, err error, response interface{}) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("explorer responded with %v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal response of explorer: %w", err)
+	}
+	return nil
+}
+
+func (e explorerClient) schemata() ([]uuid.UUID, error) {
+	var schemata schema.SchemaListDTO
+	err := e.get("/schema/list", nil, &schemata)
+	return schemata.Schemata, err
+}
+
+func (e explorerClient) aliases() ([]schema.Alias, error) {
+	var aliases schema.AliasListDTO
+	err := e.get("/alias/list", nil, &aliases)
+	return aliases.Aliases, err
+}
+
+func (e explorerClient) whoIs(alias schema.Alias) (uuid.UUID, bool, error) {
+	var aliases schema.AliasesDTO
+	err := e.get("/alias/describe", url.Values{"alias": {alias.String()}}, &aliases)
+	if err != nil || len(aliases.Aliases) == 0 {
+		return uuid.Nil, false, err
+	}
+	return aliases.Aliases[0].UUID, true, nil
+}
+
+// describe looks up the UUIDs of the given aliases, skipping unknown aliases.
+func (e explorerClient) describe(aliases []schema.Alias) ([]schema.AliasDTO, error) {
+	if len(aliases) == 0 {
+		return []schema.AliasDTO{}, nil
+	}
+	query := url.Values{}
+	for _, alias := range aliases {
+		query.Add("alias", alias.String())
+	}
+	var described schema.AliasesDTO
+	err := e.get("/alias/describe", query, &described)
+	return described.Aliases, err
+}
+
+func (e explorerClient) specification(schemaUUID uuid.UUID) (string, bool, error) {
+	described, known, err := e.describeSchema(schemaUUID)
+	return described.Specification, known, err
+}
+
+func (e explorerClient) describeSchema(schemaUUID uuid.UUID) (schema.SchemaDTO, bool, error) {
+	var schemata schema.SchemataDTO
+	err := e.get("/schema/describe", url.Values{"uuid": {schemaUUID.String()}}, &schemata)
+	if err != nil || len(schemata.Schemata) == 0 {
+		return schema.SchemaDTO{}, false, err
+	}
+	return schemata.Schemata[0], true, nil
+}
+
+// explorerSource is the schema.SchemaSource of the updaters of the CLI, it looks up schemata through the explorer.
+// Schemata which cannot be looked up because the explorer is unavailable are treated as unknown.
+type explorerSource struct {
+	explorer explorerClient
+}
+
+func (s explorerSource) GetSpecification(schemaUUID uuid.UUID) (string, bool) {
+	found, ok, err := s.explorer.describeSchema(schemaUUID)
+	return found.Specification, ok && err == nil
+}
+
+func (s explorerSource) SchemaID(schemaUUID uuid.UUID) (int32, bool) {
+	found, ok, err := s.explorer.describeSchema(schemaUUID)
+	return found.ID, ok && err == nil && found.ID != 0
+}
+
+func (s explorerSource) LastID() int32 {
+	var schemata schema.SchemaListDTO
+	if err := s.explorer.get("/schema/list", nil, &schemata); err != nil {
+		return 0
+	}
+	return schemata.LastID
+}
+
+// versions returns all versions of the given name in ascending order.
+func (e explorerClient) versions(name string) ([]schema.NameVersion, error) {
+	var versionList schema.VersionListDTO
+	err := e.get("/version/list", url.Values{"name": {name}}, &versionList)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]schema.NameVersion, 0, len(versionList.Versions))
+	for _, version := range versionList.Versions {
+		versions = append(versions, schema.NameVersion{Name: name, Version: version})
+	}
+	return versions, nil
+}
+
+func (e explorerClient) latestVersion(name string) (schema.NameVersion, bool, error) {
+	versions, err := e.versions(name)
+	if err != nil || len(versions) == 0 {
+		return schema.NameVersion{}, false, err
+	}
+	return versions[len(versions)-1], true, nil
+}
+
+func (e explorerClient) compatibility(name string) (schema.Compatibility, error) {
+	var compatibilities []schema.CompatibilityDTO
+	err := e.get("/compatibility/describe", url.Values{"name": {name}}, &compatibilities)
+	if err != nil {
+		return "", err
+	}
+	if len(compatibilities) == 0 {
+		return schema.DefaultCompatibility, nil
+	}
+	return compatibilities[0].Compatibility, nil
+}
+
+// resolve looks up a schema by its UUID or by one of its aliases.
+func (e explorerClient) resolve(ref string) (schema.SchemaDTO, error) {
+	schemaUUID, err := uuid.Parse(ref)
+	if err != nil {
+		var known bool
+		schemaUUID, known, err = e.whoIs(schema.Alias(ref))
+		if err != nil {
+			return schema.SchemaDTO{}, err
+		}
+		if !known {
+			return schema.SchemaDTO{}, fmt.Errorf("alias %v: %w", ref, errNotFound)
+		}
+	}
+	described, known, err := e.describeSchema(schemaUUID)
+	if err != nil {
+		return schema.SchemaDTO{}, err
+	}
+	if !known {
+		return schema.SchemaDTO{}, fmt.Errorf("schema %v: %w", schemaUUID, errNotFound)
+	}
+	return described, nil
+}
+
+// resolveLatest looks up a schema like resolve, but also accepts a name, which refers to its latest version.
+// It returns the schema together with the UUID, alias or version it was found by.
+func (e explorerClient) resolveLatest(ref string) (schema.SchemaDTO, string, error) {
+	found, err := e.resolve(ref)
+	if !errors.Is(err, errNotFound) {
+		return found, ref, err
+	}
+	latest, exists, latestErr := e.latestVersion(ref)
+	if latestErr != nil {
+		return schema.SchemaDTO{}, ref, latestErr
+	}
+	if !exists {
+		return schema.SchemaDTO{}, ref, err
+	}
+	found, err = e.resolve(latest.String())
+	return found, latest.String(), err
+}
+
+// decode decodes a message, see func schema.LocalRepo.Inspect.
+// The query may give the encoding and framing of the message, and the uuid of raw messages.
+func (e explorerClient) decode(message []byte, query url.Values) (schema.InspectedDTO, error) {
+	var inspected schema.InspectedDTO
+	err := e.post("/decode", query, message, &inspected)
+	return inspected, err
+}
+
+// checkCompatibility has the explorer check whether the specification may be published as the given version,
+// or as the next version if version is negative. See checkVersion.
+func (e explorerClient) checkCompatibility(name string, version int, specification string, level string) (schema.CompatibilityCheckDTO, error) {
+	query := url.Values{"name": {name}}
+	if version >= 0 {
+		query.Set("version", strconv.Itoa(version))
+	}
+	if level != "" {
+		query.Set("compatibility", level)
+	}
+	var checked schema.CompatibilityCheckDTO
+	err := e.post("/compatibility/check", query, []byte(specification), &checked)
+	return checked, err
+}
+
+// ready checks that the explorer has caught up with the schema log, so that it knows all versions of a name.
+func (e explorerClient) ready() error {
+	var ready schema.ReadyDTO
+	err := e.get("/ready", nil, &ready)
+	if err != nil {
+		return fmt.Errorf("the explorer has not caught up with the schema log: %w", err)
+	}
+	return nil
+}
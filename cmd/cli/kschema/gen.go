@@ -0,0 +1,65 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"io/ioutil"
+	"os"
+)
+
+// GenResultDTO is the result of the gen command, if the generated code is written into a file.
+type GenResultDTO struct {
+	UUID uuid.UUID `json:"uuid"`
+	File string    `json:"file"`
+}
+
+func (g GenResultDTO) String() string {
+	return fmt.Sprintf("Generated %v from schema %v", g.File, g.UUID)
+}
+
+func runGen(args []string) error {
+	if len(args) == 0 || args[0] != "go" {
+		return usagef("expected a language, the only supported language is go")
+	}
+	flags, opts := newFlagSet("gen go", "[-package NAME] [-out FILE] UUID|ALIAS|NAME")
+	packageName := flags.String("package", "avro", "Name of the generated package")
+	out := flags.String("out", "", "File to write the generated code into, the code is written to stdout if empty")
+	if err := opts.parse(flags, args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usagef("expected a UUID, an alias or a name")
+	}
+
+	found, ref, err := opts.explorerClient().resolveLatest(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	source := found.UUID.String()
+	if ref != source {
+		source = fmt.Sprintf("%v (%v)", ref, found.UUID)
+	}
+	code, err := schema.GenerateGo(found.Specification, schema.GoOptions{Package: *packageName, Source: source})
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	if err := ioutil.WriteFile(*out, code, 0644); err != nil {
+		return err
+	}
+	return opts.print(GenResultDTO{UUID: found.UUID, File: *out})
+}
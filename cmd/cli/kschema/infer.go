@@ -0,0 +1,176 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// inferProgram is run within the module of the inferred type, so that the schema is inferred by
+// schema.InferSchema, exactly like it would be inferred by the application itself.
+const inferProgram = `package main
+
+import (
+	"fmt"
+	"os"
+
+	schema "github.com/strangedev/kafka-schema/pkg"
+	inferred %q
+)
+
+func main() {
+	specification, err := schema.InferSchema((*inferred.%s)(nil), %q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Print(specification)
+}
+`
+
+// InferredDTO is the result of the infer command.
+type InferredDTO struct {
+	Alias         schema.Alias `json:"alias,omitempty"`
+	UUID          uuid.UUID    `json:"uuid"`
+	Specification string       `json:"specification"`
+	Published     bool         `json:"published"`
+}
+
+func (i InferredDTO) String() string {
+	if i.Alias == "" {
+		return indentSpecification(i.Specification)
+	}
+	if !i.Published {
+		return fmt.Sprintf("%v\t%v (unchanged)", i.Alias, i.UUID)
+	}
+	return fmt.Sprintf("%v\t%v", i.Alias, i.UUID)
+}
+
+func runInfer(args []string) error {
+	flags, opts := newFlagSet("infer", "-name NAME [-avro-namespace NAMESPACE] [-dir DIR] [-dry-run] [PACKAGE.]TYPE")
+	name := flags.String("name", "", "The name to publish the inferred schema as")
+	avroNamespace := flags.String("avro-namespace", "", "Namespace of the inferred record")
+	dir := flags.String("dir", ".", "Directory within the Go module containing the type, the module needs to require kafka-schema")
+	dryRun := flags.Bool("dry-run", false, "Print the inferred specification rather than publishing it.")
+	force := flags.Bool("force", false, "Publish the next version even if it violates the compatibility level.")
+	level := flags.String("compatibility", "", "Check against this compatibility level rather than the level of the name.")
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usagef("expected a Go type, e.g. ./orders.Order")
+	}
+	if *name == "" && !*dryRun {
+		return usagef("the name of the schema is required")
+	}
+
+	specification, err := inferSpecification(*dir, flags.Arg(0), *avroNamespace)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return opts.print(InferredDTO{Specification: specification})
+	}
+
+	explorer := opts.explorerClient()
+	if err := explorer.ready(); err != nil {
+		return err
+	}
+	latest, exists, err := explorer.latestVersion(*name)
+	if err != nil {
+		return fmt.Errorf("unable to list current versions: %w", err)
+	}
+	next := schema.NewVersionOrigin(*name)
+	if exists {
+		current, err := explorer.resolve(string(latest.Alias()))
+		if err != nil {
+			return err
+		}
+		unchanged, err := sameCanonicalForm(current.Specification, specification)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			return opts.print(InferredDTO{Alias: latest.Alias(), UUID: current.UUID, Specification: specification})
+		}
+		next = schema.NameVersion{Name: *name, Version: latest.Version + 1}
+		if !*force {
+			_, err = checkVersion(explorer, *name, int(next.Version), specification, *level)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	published, err := publish(opts, next.Alias(), specification)
+	if err != nil {
+		return err
+	}
+	return opts.print(InferredDTO{Alias: published.Alias, UUID: published.UUID, Specification: specification, Published: true})
+}
+
+// inferSpecification infers the schema of a Go type, given as a package pattern relative to dir and a type name,
+// by running a program importing the type within its module.
+func inferSpecification(dir string, ref string, namespace string) (string, error) {
+	pkg, typeName := ".", ref
+	if i := strings.LastIndex(ref, "."); i >= 0 {
+		pkg, typeName = ref[:i], ref[i+1:]
+	}
+	if pkg == "" || typeName == "" {
+		return "", usagef("expected a Go type, e.g. ./orders.Order, got %v", ref)
+	}
+
+	list := exec.Command("go", "list", "-f", "{{.ImportPath}}", pkg)
+	list.Dir = dir
+	list.Stderr = os.Stderr
+	importPath, err := list.Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to find package %v: %w", pkg, err)
+	}
+
+	tmp, err := ioutil.TempDir("", "kschema-infer")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	program := filepath.Join(tmp, "main.go")
+	source := fmt.Sprintf(inferProgram, strings.TrimSpace(string(importPath)), typeName, namespace)
+	if err := ioutil.WriteFile(program, []byte(source), 0644); err != nil {
+		return "", err
+	}
+
+	run := exec.Command("go", "run", program)
+	run.Dir = dir
+	run.Stderr = os.Stderr
+	specification, err := run.Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to infer the schema of %v: %w", ref, err)
+	}
+	return string(specification), nil
+}
+
+// sameCanonicalForm returns whether two specifications describe the same schema.
+func sameCanonicalForm(a string, b string) (bool, error) {
+	canonicalA, err := schema.CanonicalForm(a)
+	if err != nil {
+		return false, err
+	}
+	canonicalB, err := schema.CanonicalForm(b)
+	if err != nil {
+		return false, err
+	}
+	return canonicalA == canonicalB, nil
+}
@@ -0,0 +1,275 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"sort"
+	"strings"
+)
+
+// indentSpecification formats a specification for humans, one JSON value per line.
+func indentSpecification(specification string) string {
+	indented := bytes.Buffer{}
+	if err := json.Indent(&indented, []byte(specification), "", "  "); err != nil {
+		return specification
+	}
+	return indented.String()
+}
+
+// SchemaResultDTO is the result of the get command.
+type SchemaResultDTO struct {
+	schema.SchemaDTO
+}
+
+func (s SchemaResultDTO) String() string {
+	return fmt.Sprintf("%v (ID %v)\n%v", s.UUID, s.ID, indentSpecification(s.Specification))
+}
+
+func runGet(args []string) error {
+	flags, opts := newFlagSet("get", "UUID|ALIAS")
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usagef("expected a UUID or an alias")
+	}
+
+	found, err := opts.explorerClient().resolve(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return opts.print(SchemaResultDTO{found})
+}
+
+// AliasListResultDTO is the result of the list command.
+type AliasListResultDTO struct {
+	Aliases []schema.AliasDTO `json:"aliases"`
+	Count   int               `json:"count"`
+}
+
+func (l AliasListResultDTO) String() string {
+	lines := make([]string, 0, len(l.Aliases))
+	for _, alias := range l.Aliases {
+		lines = append(lines, fmt.Sprintf("%v\t%v", alias.Alias, alias.UUID))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SchemaListResultDTO is the result of the list command with -schemata.
+type SchemaListResultDTO struct {
+	schema.SchemaListDTO
+}
+
+func (l SchemaListResultDTO) String() string {
+	lines := make([]string, 0, len(l.Schemata))
+	for _, schemaUUID := range l.Schemata {
+		lines = append(lines, schemaUUID.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func runList(args []string) error {
+	flags, opts := newFlagSet("list", "[-schemata]")
+	listSchemata := flags.Bool("schemata", false, "List the UUIDs of all schemata rather than all aliases.")
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	explorer := opts.explorerClient()
+
+	if *listSchemata {
+		schemata, err := explorer.schemata()
+		if err != nil {
+			return err
+		}
+		sort.Slice(schemata, func(i, j int) bool {
+			return schemata[i].String() < schemata[j].String()
+		})
+		return opts.print(SchemaListResultDTO{schema.SchemaListDTO{Schemata: schemata, Count: len(schemata)}})
+	}
+
+	aliases, err := explorer.aliases()
+	if err != nil {
+		return err
+	}
+	described, err := explorer.describe(aliases)
+	if err != nil {
+		return err
+	}
+	sort.Slice(described, func(i, j int) bool {
+		return described[i].Alias < described[j].Alias
+	})
+	return opts.print(AliasListResultDTO{Aliases: described, Count: len(described)})
+}
+
+// VersionDTO is a single version of a name.
+type VersionDTO struct {
+	Version uint         `json:"version"`
+	Alias   schema.Alias `json:"alias"`
+	UUID    uuid.UUID    `json:"uuid"`
+}
+
+// VersionsResultDTO is the result of the versions command.
+type VersionsResultDTO struct {
+	Name          string               `json:"name"`
+	Compatibility schema.Compatibility `json:"compatibility"`
+	Versions      []VersionDTO         `json:"versions"`
+	Count         int                  `json:"count"`
+}
+
+func (v VersionsResultDTO) String() string {
+	lines := []string{fmt.Sprintf("%v (%v)", v.Name, v.Compatibility)}
+	for _, version := range v.Versions {
+		lines = append(lines, fmt.Sprintf("%v\t%v\t%v", version.Version, version.Alias, version.UUID))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func runVersions(args []string) error {
+	flags, opts := newFlagSet("versions", "NAME")
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usagef("expected a name")
+	}
+	name := flags.Arg(0)
+	explorer := opts.explorerClient()
+
+	versions, err := explorer.versions(name)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("name %v: %w", name, errNotFound)
+	}
+	level, err := explorer.compatibility(name)
+	if err != nil {
+		return err
+	}
+	aliases := make([]schema.Alias, 0, len(versions))
+	for _, version := range versions {
+		aliases = append(aliases, version.Alias())
+	}
+	described, err := explorer.describe(aliases)
+	if err != nil {
+		return err
+	}
+	uuids := make(map[schema.Alias]uuid.UUID, len(described))
+	for _, alias := range described {
+		uuids[alias.Alias] = alias.UUID
+	}
+
+	result := VersionsResultDTO{Name: name, Compatibility: level, Versions: make([]VersionDTO, 0, len(versions)), Count: len(versions)}
+	for _, version := range versions {
+		result.Versions = append(result.Versions, VersionDTO{Version: version.Version, Alias: version.Alias(), UUID: uuids[version.Alias()]})
+	}
+	return opts.print(result)
+}
+
+// DiffResultDTO is the result of the diff command.
+// Backward indicates whether data written with From can be read with To,
+// Forward whether data written with To can be read with From.
+type DiffResultDTO struct {
+	From     uuid.UUID `json:"from"`
+	To       uuid.UUID `json:"to"`
+	Backward bool      `json:"backward"`
+	Forward  bool      `json:"forward"`
+	Lines    []string  `json:"lines"`
+}
+
+func (d DiffResultDTO) String() string {
+	lines := []string{
+		fmt.Sprintf("--- %v", d.From),
+		fmt.Sprintf("+++ %v", d.To),
+	}
+	lines = append(lines, d.Lines...)
+	lines = append(lines, fmt.Sprintf("backward compatible: %v, forward compatible: %v", d.Backward, d.Forward))
+	return strings.Join(lines, "\n")
+}
+
+// diffLines computes a line based diff using the longest common subsequence of both texts.
+// Every line is prefixed with "  " if it is unchanged, "- " if it was removed and "+ " if it was added.
+func diffLines(from []string, to []string) []string {
+	common := make([][]int, len(from)+1)
+	for i := range common {
+		common[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(from)+len(to))
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			lines = append(lines, "  "+from[i])
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, "- "+from[i])
+			i++
+		default:
+			lines = append(lines, "+ "+to[j])
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		lines = append(lines, "- "+from[i])
+	}
+	for ; j < len(to); j++ {
+		lines = append(lines, "+ "+to[j])
+	}
+	return lines
+}
+
+func runDiff(args []string) error {
+	flags, opts := newFlagSet("diff", "FROM TO")
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usagef("expected two UUIDs or aliases")
+	}
+	explorer := opts.explorerClient()
+
+	from, err := explorer.resolve(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	to, err := explorer.resolve(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	return opts.print(DiffResultDTO{
+		From:     from.UUID,
+		To:       to.UUID,
+		Backward: schema.CheckReadable(to.Specification, from.Specification) == nil,
+		Forward:  schema.CheckReadable(from.Specification, to.Specification) == nil,
+		Lines: diffLines(
+			strings.Split(indentSpecification(from.Specification), "\n"),
+			strings.Split(indentSpecification(to.Specification), "\n"),
+		),
+	})
+}
@@ -0,0 +1,183 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+// Command kschema manages the schema repository.
+// Changes are written into Kafka, while the repository is read through the schema explorer.
+//
+// The exit code is 0 on success, 1 on any error, 2 on invalid usage,
+// 3 if a schema is incompatible and 4 if a schema, alias or name does not exist.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"os"
+	"sort"
+)
+
+const (
+	exitError        = 1
+	exitUsage        = 2
+	exitIncompatible = 3
+	exitNotFound     = 4
+)
+
+// errNotFound is returned when a schema, alias or name does not exist.
+var errNotFound = errors.New("not found")
+
+// usageError is returned when a command is invoked incorrectly.
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// exitCode maps the error returned by a command to the exit code of kschema.
+func exitCode(err error) int {
+	var usage usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, schema.ErrIncompatible):
+		return exitIncompatible
+	case errors.Is(err, errNotFound):
+		return exitNotFound
+	default:
+		return exitError
+	}
+}
+
+// options are the flags shared by all commands.
+type options struct {
+	broker    string
+	namespace string
+	prefix    string
+	explorer  string
+	output    string
+}
+
+// newFlagSet constructs the flags of a command, including the shared options.
+func newFlagSet(name string, usage string) (*flag.FlagSet, *options) {
+	opts := &options{}
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&opts.broker, "broker", "broker0:9092", "URL of a Kafka broker")
+	flags.StringVar(&opts.namespace, "namespace", "", "Namespace of the schema repository, it must match the namespace of the schema explorer.")
+	flags.StringVar(&opts.prefix, "topic-prefix", "", "Prefix of the schema repository's topics, it must match the prefix of the schema explorer.")
+	flags.StringVar(&opts.explorer, "explorer", "schema-explorer:8085", "Address of the schema explorer")
+	flags.StringVar(&opts.output, "output", "text", "Output format, text or json")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: kschema %v %v\n", name, usage)
+		flags.PrintDefaults()
+	}
+	return flags, opts
+}
+
+// parse parses the arguments of a command and checks the shared options.
+func (o *options) parse(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err}
+	}
+	if o.output != "text" && o.output != "json" {
+		return usagef("unknown output format %q", o.output)
+	}
+	return nil
+}
+
+func (o *options) repoOptions() schema.Options {
+	return schema.Options{Namespace: o.namespace, Prefix: o.prefix}
+}
+
+// updater constructs an Updater which looks up the schemata that are already published through the explorer.
+func (o *options) updater() (schema.Updater, error) {
+	writer, err := schema.NewKafkaLogWriter(o.broker)
+	if err != nil {
+		return nil, err
+	}
+	return schema.NewUpdaterWithLogAndSource(writer, o.repoOptions(), explorerSource{o.explorerClient()}), nil
+}
+
+func (o *options) explorerClient() explorerClient {
+	return explorerClient{address: o.explorer}
+}
+
+// print writes the result of a command to stdout in the selected output format.
+func (o *options) print(result fmt.Stringer) error {
+	if o.output == "json" {
+		return json.NewEncoder(os.Stdout).Encode(result)
+	}
+	_, err := fmt.Println(result)
+	return err
+}
+
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"create":        {"Publish a new name at version 0", runCreate},
+	"evolve":        {"Publish the next version of an existing name", runEvolve},
+	"alias":         {"Point an alias at a schema", runAlias},
+	"delete":        {"Delete a schema or alias", runDelete},
+	"get":           {"Show a schema by UUID or alias", runGet},
+	"list":          {"List all aliases or schemata", runList},
+	"versions":      {"List all versions of a name", runVersions},
+	"diff":          {"Compare two schemata", runDiff},
+	"check-compat":  {"Check whether a specification may be published as a version of a name", runCheckCompat},
+	"create-topics": {"Create the compacted topics of the schema repository", runCreateTopics},
+	"gen":           {"Generate code for a schema, e.g. kschema gen go", runGen},
+	"infer":         {"Infer a schema from a Go type and publish it as the next version of a name", runInfer},
+	"decode":        {"Decode a message, detecting its framing and writer schema", runDecode},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: kschema <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-15v %v\n", name, commands[name].description)
+	}
+	fmt.Fprintln(os.Stderr, "Run kschema <command> -h for the flags of a command.")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	err := cmd.run(os.Args[2:])
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "kschema %v: %v\n", os.Args[1], err)
+	}
+	os.Exit(exitCode(err))
+}
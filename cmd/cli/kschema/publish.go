@@ -0,0 +1,331 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/linkedin/goavro"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// specificationSource is where a command reads a specification from.
+type specificationSource struct {
+	file string
+	url  string
+}
+
+func registerSource(flags *flag.FlagSet) *specificationSource {
+	source := &specificationSource{}
+	flags.StringVar(&source.file, "file", "", "Read the specification from a file rather than from Stdin.")
+	flags.StringVar(&source.url, "from-url", "", "Fetch the specification via HTTP GET rather than reading from Stdin.")
+	return source
+}
+
+// read reads the specification and checks that it is a valid Avro schema.
+func (s specificationSource) read() (string, error) {
+	var specification []byte
+	var err error
+	switch {
+	case s.file != "" && s.url != "":
+		return "", usagef("only one of -file and -from-url may be given")
+	case s.file != "":
+		specification, err = ioutil.ReadFile(s.file)
+	case s.url != "":
+		var resp *http.Response
+		resp, err = http.Get(s.url)
+		if err != nil {
+			return "", fmt.Errorf("unable to fetch specification: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unable to fetch specification: %v", resp.Status)
+		}
+		specification, err = ioutil.ReadAll(resp.Body)
+	default:
+		specification, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read specification: %w", err)
+	}
+
+	_, err = goavro.NewCodec(string(specification))
+	if err != nil {
+		return "", fmt.Errorf("this does not seem like a valid Avro schema: %w", err)
+	}
+	return string(specification), nil
+}
+
+// PublishedDTO is the result of all commands which publish changes.
+type PublishedDTO struct {
+	Alias schema.Alias `json:"alias"`
+	UUID  uuid.UUID    `json:"uuid"`
+}
+
+func (p PublishedDTO) String() string {
+	return fmt.Sprintf("%v\t%v", p.Alias, p.UUID)
+}
+
+// publish publishes a new schema with the given specification and points the alias at it.
+func publish(opts *options, alias schema.Alias, specification string) (PublishedDTO, error) {
+	cmd, err := opts.updater()
+	if err != nil {
+		return PublishedDTO{}, fmt.Errorf("unable to initialize updater: %w", err)
+	}
+	schemaUUID := uuid.New()
+	err = cmd.UpdateSchema(schemaUUID, specification)
+	if err != nil {
+		return PublishedDTO{}, fmt.Errorf("unable to produce SchemaUpdate event: %w", err)
+	}
+	err = cmd.UpdateAlias(alias.String(), schemaUUID)
+	if err != nil {
+		return PublishedDTO{}, fmt.Errorf("unable to produce AliasUpdate event: %w", err)
+	}
+	return PublishedDTO{Alias: alias, UUID: schemaUUID}, nil
+}
+
+func runCreate(args []string) error {
+	flags, opts := newFlagSet("create", "-name NAME [-file FILE | -from-url URL]")
+	name := flags.String("name", "", "A name for the new schema")
+	skipCheck := flags.Bool("skip-check", false, "Do not use the schema explorer to check if the name already exists.")
+	source := registerSource(flags)
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return usagef("the schema needs to be named")
+	}
+
+	if !*skipCheck {
+		explorer := opts.explorerClient()
+		if err := explorer.ready(); err != nil {
+			return err
+		}
+		latest, exists, err := explorer.latestVersion(*name)
+		if err != nil {
+			return fmt.Errorf("unable to list current versions: %w", err)
+		}
+		if exists {
+			return fmt.Errorf("a schema with that name already exists, its latest version is %v", latest)
+		}
+	}
+
+	specification, err := source.read()
+	if err != nil {
+		return err
+	}
+	published, err := publish(opts, schema.NewVersionOrigin(*name).Alias(), specification)
+	if err != nil {
+		return err
+	}
+	return opts.print(published)
+}
+
+func runEvolve(args []string) error {
+	flags, opts := newFlagSet("evolve", "-name NAME [-file FILE | -from-url URL]")
+	name := flags.String("name", "", "The name to publish the next version of")
+	force := flags.Bool("force", false, "Publish the next version even if it violates the compatibility level.")
+	level := flags.String("compatibility", "", "Check against this compatibility level rather than the level of the name.")
+	source := registerSource(flags)
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return usagef("the name of the schema is required")
+	}
+
+	specification, err := source.read()
+	if err != nil {
+		return err
+	}
+	// The explorer determines the next version, it refuses to do so before it has caught up with the schema log
+	next, err := checkVersion(opts.explorerClient(), *name, -1, specification, *level)
+	if err != nil && !(*force && errors.Is(err, schema.ErrIncompatible)) {
+		return err
+	}
+	if next.Version == schema.NewVersionOrigin(*name).Version {
+		return fmt.Errorf("name %v: %w, use create to publish its first version", *name, errNotFound)
+	}
+	published, err := publish(opts, next.Alias, specification)
+	if err != nil {
+		return err
+	}
+	return opts.print(published)
+}
+
+func runAlias(args []string) error {
+	flags, opts := newFlagSet("alias", "ALIAS UUID")
+	force := flags.Bool("force", false, "Do not check that the schema exists and that versioned aliases are compatible.")
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usagef("expected an alias and a UUID")
+	}
+	alias := schema.Alias(flags.Arg(0))
+	schemaUUID, err := uuid.Parse(flags.Arg(1))
+	if err != nil {
+		return usageError{err}
+	}
+
+	if !*force {
+		explorer := opts.explorerClient()
+		specification, known, err := explorer.specification(schemaUUID)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return fmt.Errorf("schema %v: %w", schemaUUID, errNotFound)
+		}
+		if version, err := schema.VersionFromAlias(alias); err == nil {
+			_, err = checkVersion(explorer, version.Name, int(version.Version), specification, "")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cmd, err := opts.updater()
+	if err != nil {
+		return fmt.Errorf("unable to initialize updater: %w", err)
+	}
+	err = cmd.UpdateAlias(alias.String(), schemaUUID)
+	if err != nil {
+		return fmt.Errorf("unable to produce AliasUpdate event: %w", err)
+	}
+	return opts.print(PublishedDTO{Alias: alias, UUID: schemaUUID})
+}
+
+// DeletedDTO is the result of the delete command.
+// UUID is the nil UUID, if an alias has been deleted without looking it up.
+type DeletedDTO struct {
+	Alias schema.Alias `json:"alias,omitempty"`
+	UUID  uuid.UUID    `json:"uuid"`
+	Mode  string       `json:"mode"`
+}
+
+func (d DeletedDTO) String() string {
+	if d.Alias == "" {
+		return fmt.Sprintf("deleted %v (%v)", d.UUID, d.Mode)
+	}
+	return fmt.Sprintf("deleted %v\t%v (%v)", d.Alias, d.UUID, d.Mode)
+}
+
+func runDelete(args []string) error {
+	flags, opts := newFlagSet("delete", "[-hard] UUID|ALIAS")
+	hard := flags.Bool("hard", false, "Remove the schema or alias entirely. Data written with a hard-deleted schema can no longer be decoded.")
+	force := flags.Bool("force", false, "Do not check that the schema or alias exists and that a schema has no aliases left.")
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usagef("expected a UUID or an alias")
+	}
+	mode := schema.SoftDelete
+	if *hard {
+		mode = schema.HardDelete
+	}
+	explorer := opts.explorerClient()
+
+	deleted := DeletedDTO{Mode: mode.String()}
+	schemaUUID, err := uuid.Parse(flags.Arg(0))
+	isSchema := err == nil
+	if isSchema {
+		deleted.UUID = schemaUUID
+	} else {
+		deleted.Alias = schema.Alias(flags.Arg(0))
+	}
+
+	if !*force {
+		if isSchema {
+			err = checkUnreferenced(explorer, schemaUUID)
+		} else {
+			var known bool
+			deleted.UUID, known, err = explorer.whoIs(deleted.Alias)
+			if err == nil && !known {
+				err = fmt.Errorf("alias %v: %w", deleted.Alias, errNotFound)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	cmd, err := opts.updater()
+	if err != nil {
+		return fmt.Errorf("unable to initialize updater: %w", err)
+	}
+	if isSchema {
+		err = cmd.DeleteSchema(schemaUUID, mode)
+	} else {
+		err = cmd.DeleteAlias(deleted.Alias.String(), mode)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to produce deletion event: %w", err)
+	}
+	return opts.print(deleted)
+}
+
+// checkUnreferenced checks that the schema exists and that no alias refers to it anymore.
+func checkUnreferenced(explorer explorerClient, schemaUUID uuid.UUID) error {
+	_, known, err := explorer.specification(schemaUUID)
+	if err != nil {
+		return err
+	}
+	if !known {
+		return fmt.Errorf("schema %v: %w", schemaUUID, errNotFound)
+	}
+	aliases, err := explorer.aliases()
+	if err != nil {
+		return err
+	}
+	described, err := explorer.describe(aliases)
+	if err != nil {
+		return err
+	}
+	for _, alias := range described {
+		if alias.UUID == schemaUUID {
+			return fmt.Errorf("schema %v is still referred to by %v, delete its aliases first", schemaUUID, alias.Alias)
+		}
+	}
+	return nil
+}
+
+// TopicsDTO is the result of the create-topics command.
+type TopicsDTO struct {
+	Topics []string `json:"topics"`
+}
+
+func (t TopicsDTO) String() string {
+	return strings.Join(t.Topics, "\n")
+}
+
+func runCreateTopics(args []string) error {
+	flags, opts := newFlagSet("create-topics", "[-replication-factor N]")
+	replicationFactor := flags.Int("replication-factor", 1, "The replication factor of the topics")
+	if err := opts.parse(flags, args); err != nil {
+		return err
+	}
+	if *replicationFactor < 1 {
+		return usagef("the replication factor must be at least 1")
+	}
+
+	err := schema.CreateTopics(opts.broker, opts.repoOptions(), *replicationFactor)
+	if err != nil {
+		return fmt.Errorf("unable to create topics: %w", err)
+	}
+	return opts.print(TopicsDTO{Topics: opts.repoOptions().TopicNames().List()})
+}
@@ -0,0 +1,44 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/google/uuid"
+	"github.com/strangedev/catchall"
+	schema "github.com/strangedev/kafka-schema/pkg"
+	"log"
+)
+
+func main() {
+	schemaLog := schema.NewMemoryLog()
+
+	schemaRepo := schema.NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := schemaRepo.Run()
+	catchall.CheckFatal("Unable to start schema repository", err)
+	defer (func() {
+		stop <- true
+	})()
+
+	cmd := schema.NewUpdaterWithLog(schemaLog)
+
+	schemaVersion := schema.NewVersionOrigin("greeting")
+	schemaReady := schemaRepo.WaitVersionReady(schemaVersion)
+
+	schemaUUID := uuid.New()
+	err = cmd.UpdateSchema(schemaUUID, `{"type": "record", "name": "greeting", "fields": [{"name": "text", "type": "string"}]}`)
+	catchall.CheckFatal("Unable to produce SchemaUpdate event", err)
+	err = cmd.UpdateAlias(schemaVersion.String(), schemaUUID)
+	catchall.CheckFatal("Unable to produce AliasUpdate event", err)
+
+	<-schemaReady
+	encoded, err := schemaRepo.EncodeVersion(schemaVersion, map[string]interface{}{"text": "Hello"})
+	catchall.CheckFatal("Unable to encode datum", err)
+	log.Printf("Encoded datum with %v: %v", schemaVersion, encoded)
+}
@@ -0,0 +1,283 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// avroSchema is a parsed Avro schema.
+// goavro only exposes codecs, so this is used wherever the structure of a schema matters.
+// Named types are parsed only once, references to them point to the same avroSchema,
+// so recursive schemata result in cyclic structures.
+type avroSchema struct {
+	// Type is either a primitive type name, "record", "enum", "fixed", "array", "map" or "union".
+	Type string
+	// Name is the full name of named types.
+	Name string
+	// Aliases are the full names of a named type's aliases.
+	Aliases []string
+	Doc     string
+	Fields  []*avroField
+	Symbols []string
+	// EnumDefault is the symbol used for unknown symbols, if HasEnumDefault is set.
+	EnumDefault    string
+	HasEnumDefault bool
+	Size           int
+	Items          *avroSchema
+	Values         *avroSchema
+	Branches       []*avroSchema
+	LogicalType    string
+	Precision      int
+	Scale          int
+}
+
+// avroField is a single field of an Avro record.
+type avroField struct {
+	Name    string
+	Aliases []string
+	Doc     string
+	Type    *avroSchema
+	// Default is the default value as decoded from JSON, if HasDefault is set.
+	// Numbers are decoded as json.Number.
+	Default    interface{}
+	HasDefault bool
+}
+
+var avroPrimitives = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"int":     true,
+	"long":    true,
+	"float":   true,
+	"double":  true,
+	"bytes":   true,
+	"string":  true,
+}
+
+// isNamed indicates whether the schema is a named type.
+func (s *avroSchema) isNamed() bool {
+	return s.Type == "record" || s.Type == "enum" || s.Type == "fixed"
+}
+
+// typeName returns the name goavro uses for the schema when it is a member of a union.
+func (s *avroSchema) typeName() string {
+	if s.isNamed() {
+		return s.Name
+	}
+	return s.Type
+}
+
+// shortName strips the namespace from a full name.
+func shortName(fullName string) string {
+	return fullName[strings.LastIndex(fullName, ".")+1:]
+}
+
+// parseAvroSchema parses a plain-text Avro specification.
+func parseAvroSchema(specification string) (*avroSchema, error) {
+	if avroPrimitives[specification] {
+		return &avroSchema{Type: specification}, nil
+	}
+
+	var raw interface{}
+	decoder := json.NewDecoder(bytes.NewBufferString(specification))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal schema JSON: %v", err)
+	}
+
+	parser := avroParser{named: make(map[string]*avroSchema)}
+	return parser.parse(raw, "")
+}
+
+// avroParser keeps track of the named types defined while parsing a schema.
+type avroParser struct {
+	named map[string]*avroSchema
+}
+
+func (p avroParser) parse(raw interface{}, namespace string) (*avroSchema, error) {
+	switch t := raw.(type) {
+	case string:
+		return p.reference(t, namespace)
+	case []interface{}:
+		union := &avroSchema{Type: "union", Branches: make([]*avroSchema, 0, len(t))}
+		for _, rawBranch := range t {
+			branch, err := p.parse(rawBranch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			union.Branches = append(union.Branches, branch)
+		}
+		return union, nil
+	case map[string]interface{}:
+		return p.parseComplex(t, namespace)
+	default:
+		return nil, fmt.Errorf("unknown schema type: %T", raw)
+	}
+}
+
+func (p avroParser) reference(typeName string, namespace string) (*avroSchema, error) {
+	if avroPrimitives[typeName] {
+		return &avroSchema{Type: typeName}, nil
+	}
+	if !strings.Contains(typeName, ".") && namespace != "" {
+		if named, ok := p.named[namespace+"."+typeName]; ok {
+			return named, nil
+		}
+	}
+	if named, ok := p.named[typeName]; ok {
+		return named, nil
+	}
+	return nil, fmt.Errorf("unknown type name: %q", typeName)
+}
+
+func (p avroParser) parseComplex(raw map[string]interface{}, namespace string) (*avroSchema, error) {
+	rawType, ok := raw["type"]
+	if !ok {
+		return nil, fmt.Errorf("missing type: %v", raw)
+	}
+	typeName, ok := rawType.(string)
+	if !ok {
+		return p.parse(rawType, namespace)
+	}
+
+	switch typeName {
+	case "record", "error", "enum", "fixed":
+		return p.parseNamed(raw, typeName, namespace)
+	case "array":
+		items, err := p.parse(raw["items"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("array items: %v", err)
+		}
+		return &avroSchema{Type: "array", Items: items}, nil
+	case "map":
+		values, err := p.parse(raw["values"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("map values: %v", err)
+		}
+		return &avroSchema{Type: "map", Values: values}, nil
+	}
+
+	schema, err := p.reference(typeName, namespace)
+	if err != nil || schema.isNamed() {
+		return schema, err
+	}
+	schema.LogicalType, _ = raw["logicalType"].(string)
+	schema.Precision = jsonInt(raw["precision"])
+	schema.Scale = jsonInt(raw["scale"])
+	return schema, nil
+}
+
+func (p avroParser) parseNamed(raw map[string]interface{}, typeName string, enclosingNamespace string) (*avroSchema, error) {
+	name, ok := raw["name"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("%s ought to have a name: %v", typeName, raw)
+	}
+	namespace := enclosingNamespace
+	if explicit, ok := raw["namespace"].(string); ok {
+		namespace = explicit
+	}
+	fullName := qualifyName(name, namespace)
+	namespace = ""
+	if i := strings.LastIndex(fullName, "."); i >= 0 {
+		namespace = fullName[:i]
+	}
+
+	if typeName == "error" {
+		typeName = "record"
+	}
+	schema := &avroSchema{Type: typeName, Name: fullName}
+	schema.Doc, _ = raw["doc"].(string)
+	schema.LogicalType, _ = raw["logicalType"].(string)
+	schema.Precision = jsonInt(raw["precision"])
+	schema.Scale = jsonInt(raw["scale"])
+	for _, alias := range jsonStrings(raw["aliases"]) {
+		schema.Aliases = append(schema.Aliases, qualifyName(alias, namespace))
+	}
+	// Register before parsing the fields, so that the type may reference itself.
+	p.named[fullName] = schema
+
+	switch typeName {
+	case "record":
+		rawFields, ok := raw["fields"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record %q ought to have fields", fullName)
+		}
+		for _, rawField := range rawFields {
+			field, err := p.parseField(rawField, namespace)
+			if err != nil {
+				return nil, fmt.Errorf("record %q: %v", fullName, err)
+			}
+			schema.Fields = append(schema.Fields, field)
+		}
+	case "enum":
+		schema.Symbols = jsonStrings(raw["symbols"])
+		if len(schema.Symbols) == 0 {
+			return nil, fmt.Errorf("enum %q ought to have symbols", fullName)
+		}
+		schema.EnumDefault, schema.HasEnumDefault = raw["default"].(string)
+	case "fixed":
+		schema.Size = jsonInt(raw["size"])
+		if schema.Size <= 0 {
+			return nil, fmt.Errorf("fixed %q ought to have a positive size", fullName)
+		}
+	}
+	return schema, nil
+}
+
+func (p avroParser) parseField(raw interface{}, namespace string) (*avroField, error) {
+	rawField, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("field ought to be an object: %v", raw)
+	}
+	name, ok := rawField["name"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("field ought to have a name: %v", raw)
+	}
+	fieldType, err := p.parse(rawField["type"], namespace)
+	if err != nil {
+		return nil, fmt.Errorf("field %q: %v", name, err)
+	}
+	field := &avroField{Name: name, Type: fieldType, Aliases: jsonStrings(rawField["aliases"])}
+	field.Doc, _ = rawField["doc"].(string)
+	field.Default, field.HasDefault = rawField["default"]
+	return field, nil
+}
+
+// qualifyName returns the full name of a name defined in the given namespace.
+func qualifyName(name string, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func jsonInt(raw interface{}) int {
+	number, ok := raw.(json.Number)
+	if !ok {
+		return 0
+	}
+	value, _ := number.Int64()
+	return int(value)
+}
+
+func jsonStrings(raw interface{}) []string {
+	rawStrings, _ := raw.([]interface{})
+	strs := make([]string, 0, len(rawStrings))
+	for _, rawString := range rawStrings {
+		if s, ok := rawString.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,86 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/strangedev/catchall"
+)
+
+// await waits until lookup succeeds, looking up again whenever the observable notifies the given key.
+// It returns the context's error, if the context is done before. No observer is left behind in either case.
+func await(ctx context.Context, observable *keyObservable, key catchall.Key, lookup func() bool) error {
+	for {
+		observer := observable.Observe(key)
+		// The entry may have been inserted before we started observing
+		if lookup() {
+			observable.Unobserve(key, observer)
+			return nil
+		}
+		select {
+		case <-observer:
+		case <-ctx.Done():
+			observable.Unobserve(key, observer)
+			// The entry may have been inserted while the context was done
+			if lookup() {
+				return nil
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// awaitReady runs await in the background and signals on the returned channel once it succeeds.
+// Since the context is never done, the background goroutine runs until await succeeds.
+func awaitReady(await func(ctx context.Context) error) chan bool {
+	ready := make(chan bool, 1)
+	go (func() {
+		if await(context.Background()) == nil {
+			ready <- true
+		}
+	})()
+	return ready
+}
+
+func (repo LocalRepo) AwaitSchema(ctx context.Context, schema uuid.UUID) error {
+	return await(ctx, &repo.Schemata.keyObservable, schema, func() bool {
+		_, ok := repo.GetSpecification(schema)
+		return ok
+	})
+}
+
+func (repo LocalRepo) AwaitAlias(ctx context.Context, alias Alias) error {
+	var schemaUUID uuid.UUID
+	err := await(ctx, &repo.Aliases.keyObservable, alias, func() (ok bool) {
+		schemaUUID, ok = repo.WhoIs(alias)
+		return ok
+	})
+	if err != nil {
+		return err
+	}
+	return repo.AwaitSchema(ctx, schemaUUID)
+}
+
+func (repo LocalRepo) AwaitVersion(ctx context.Context, schema NameVersion) error {
+	return repo.AwaitAlias(ctx, schema.Alias())
+}
+
+func (repo LocalRepo) AwaitLatestVersion(ctx context.Context, name string) (NameVersion, error) {
+	var latest NameVersion
+	err := await(ctx, &repo.Versions.keyObservable, catchall.NewPlainKey(name), func() (ok bool) {
+		latest, ok = repo.LatestVersion(name)
+		return ok
+	})
+	if err != nil {
+		return NameVersion{}, err
+	}
+	return latest, repo.AwaitVersion(ctx, latest)
+}
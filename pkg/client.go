@@ -0,0 +1,187 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"context"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	core "github.com/strangedev/kafka-golang/pkg"
+	"time"
+)
+
+// ClientRepo is a repo which SchemaProducer and SchemaConsumer encode and decode their messages with.
+// LocalRepo is a ClientRepo.
+type ClientRepo interface {
+	FramedRepo
+	AliasRepo
+	AwaitRepo
+}
+
+// SchemaProducer produces messages whose values, and optionally keys, are encoded with versioned schemata of a repo.
+// Messages are framed as described in FrameUUID, so that consumers can look up the writer schema of every message.
+type SchemaProducer struct {
+	// Producer produces the encoded messages, e.g. a core.Producer.
+	Producer core.LowLevelProducer
+	Repo     ClientRepo
+	// Value is the version of the schema which values are encoded with.
+	Value NameVersion
+	// Key is the version of the schema which keys are encoded with.
+	// If it is nil, keys are produced as they are and need to be a string or a []byte.
+	Key *NameVersion
+}
+
+// NewSchemaProducer constructs a SchemaProducer which encodes values with the given version of a schema.
+func NewSchemaProducer(producer core.LowLevelProducer, repo ClientRepo, value NameVersion) SchemaProducer {
+	return SchemaProducer{Producer: producer, Repo: repo, Value: value}
+}
+
+// WithKeySchema returns a copy of the SchemaProducer which encodes keys with the given version of a schema.
+func (p SchemaProducer) WithKeySchema(key NameVersion) SchemaProducer {
+	p.Key = &key
+	return p
+}
+
+// encode encodes a datum with the given version, waiting until the version becomes available.
+func (p SchemaProducer) encode(ctx context.Context, version NameVersion, datum interface{}) ([]byte, error) {
+	if err := p.Repo.AwaitVersion(ctx, version); err != nil {
+		return nil, fmt.Errorf("schema %v is not available: %w", version, err)
+	}
+	schemaUUID, ok := p.Repo.WhoIs(version.Alias())
+	if !ok {
+		return nil, fmt.Errorf("schema %v is no longer available", version)
+	}
+	return p.Repo.EncodeFramed(schemaUUID, datum)
+}
+
+// encodeKey encodes a key with the key schema, if there is one, or passes it through otherwise.
+func (p SchemaProducer) encodeKey(ctx context.Context, key interface{}) ([]byte, error) {
+	if p.Key != nil {
+		if key == nil {
+			return nil, nil
+		}
+		return p.encode(ctx, *p.Key, key)
+	}
+	switch k := key.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return k, nil
+	case string:
+		return []byte(k), nil
+	default:
+		return nil, fmt.Errorf("key of type %T can not be produced without a key schema", key)
+	}
+}
+
+// Produce encodes the key and value and synchronously produces them into the given topic.
+// If the schemata are not available yet, Produce waits until they become available.
+// It returns the context's error, if the context is done before.
+// A nil value produces a tombstone.
+func (p SchemaProducer) Produce(ctx context.Context, topic string, key interface{}, value interface{}) error {
+	encodedKey, err := p.encodeKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	var encodedValue []byte
+	if value != nil {
+		encodedValue, err = p.encode(ctx, p.Value, value)
+		if err != nil {
+			return err
+		}
+	}
+	return p.Producer.ProduceSync(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            encodedKey,
+		Value:          encodedValue,
+	})
+}
+
+// DecodedMessage is a consumed message along with its decoded key and value.
+type DecodedMessage struct {
+	// Message is the message as it was consumed.
+	Message *kafka.Message
+	// Key is the decoded key, or the raw key if keys are not decoded.
+	Key interface{}
+	// KeyWriter is the UUID of the schema the key was written with, if keys are decoded.
+	KeyWriter uuid.UUID
+	// Value is the decoded value. It is nil for tombstones.
+	Value interface{}
+	// ValueWriter is the UUID of the schema the value was written with.
+	ValueWriter uuid.UUID
+}
+
+// SchemaHandler handles a single decoded message.
+// The SchemaHandler may return an error that will be logged.
+type SchemaHandler func(message DecodedMessage) error
+
+// SchemaConsumer decodes framed messages produced by a SchemaProducer, using the writer schema of every message.
+// Its Handle method is a core.Handler, which can be routed to with core.TopicRouter or any other SchemaLogReader:
+//
+//	router.NewRoute(catchall.NewPlainKey("orders"), schema.NewSchemaConsumer(repo, handleOrder).Handle)
+//
+// If the writer schema is not available yet, Handle waits for it at most Timeout and returns an error then,
+// so that a message written with an unknown or hard-deleted schema does not stall the consumer.
+type SchemaConsumer struct {
+	Repo    FramedRepo
+	Handler SchemaHandler
+	// DecodeKeys determines whether keys are decoded, too. Otherwise, they are passed to the handler as a []byte.
+	DecodeKeys bool
+	// Timeout is how long Handle waits for the writer schema of a message. If it is zero, DefaultDecodeTimeout is used.
+	Timeout time.Duration
+}
+
+// DefaultDecodeTimeout is how long a SchemaConsumer waits for the writer schema of a message by default.
+// Messages may be consumed shortly before the schema they were written with, if it has just been published.
+const DefaultDecodeTimeout = 10 * time.Second
+
+// NewSchemaConsumer constructs a SchemaConsumer which decodes values and passes them to the given handler.
+func NewSchemaConsumer(repo FramedRepo, handler SchemaHandler) SchemaConsumer {
+	return SchemaConsumer{Repo: repo, Handler: handler}
+}
+
+// WithKeys returns a copy of the SchemaConsumer which decodes keys, too.
+func (c SchemaConsumer) WithKeys() SchemaConsumer {
+	c.DecodeKeys = true
+	return c
+}
+
+// WithTimeout returns a copy of the SchemaConsumer which waits for the writer schema of a message at most timeout.
+func (c SchemaConsumer) WithTimeout(timeout time.Duration) SchemaConsumer {
+	c.Timeout = timeout
+	return c
+}
+
+// Handle decodes a message and passes it to the handler.
+func (c SchemaConsumer) Handle(message *kafka.Message) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultDecodeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	decoded := DecodedMessage{Message: message, Key: message.Key}
+	var err error
+	if c.DecodeKeys && message.Key != nil {
+		decoded.Key, decoded.KeyWriter, err = c.Repo.DecodeFramedContext(ctx, message.Key)
+		if err != nil {
+			return fmt.Errorf("unable to decode key: %w", err)
+		}
+	}
+	if message.Value != nil {
+		decoded.Value, decoded.ValueWriter, err = c.Repo.DecodeFramedContext(ctx, message.Value)
+		if err != nil {
+			return fmt.Errorf("unable to decode value: %w", err)
+		}
+	}
+	return c.Handler(decoded)
+}
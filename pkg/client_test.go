@@ -0,0 +1,116 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"context"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	"github.com/strangedev/catchall"
+	"testing"
+	"time"
+)
+
+// TestSchemaProducerConsumer produces messages with a SchemaProducer and consumes them with a SchemaConsumer,
+// checking that keys and values are decoded with the schemata they were written with.
+func TestSchemaProducerConsumer(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	keyVersion := NameVersion{Name: "key", Version: 1}
+	valueVersion := NameVersion{Name: "value", Version: 1}
+	keyUUID := publishVersion(t, updater, repo, keyVersion)
+	valueUUID := publishVersion(t, updater, repo, valueVersion)
+
+	decoded := make(chan DecodedMessage, 2)
+	reader := schemaLog.NewReader()
+	reader.NewRoute(catchall.NewPlainKey("orders"), NewSchemaConsumer(repo, func(message DecodedMessage) error {
+		decoded <- message
+		return nil
+	}).WithKeys().Handle)
+	stopReader, err := reader.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stopReader <- true
+	})()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	producer := NewSchemaProducer(schemaLog, repo, valueVersion).WithKeySchema(keyVersion)
+	if err := producer.Produce(ctx, "orders", map[string]interface{}{"n": int64(1)}, map[string]interface{}{"n": int64(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := producer.Produce(ctx, "orders", map[string]interface{}{"n": int64(1)}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	message := <-decoded
+	if message.KeyWriter != keyUUID || message.ValueWriter != valueUUID {
+		t.Errorf("expected the writers %v and %v, got %v and %v", keyUUID, valueUUID, message.KeyWriter, message.ValueWriter)
+	}
+	if n := message.Key.(map[string]interface{})["n"]; n != int64(1) {
+		t.Errorf("expected the key 1, got %v", n)
+	}
+	if n := message.Value.(map[string]interface{})["n"]; n != int64(2) {
+		t.Errorf("expected the value 2, got %v", n)
+	}
+	if tombstone := <-decoded; tombstone.Value != nil {
+		t.Errorf("expected a tombstone, got %v", tombstone.Value)
+	}
+
+	missing := NewSchemaProducer(schemaLog, repo, NameVersion{Name: "missing", Version: 1})
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := missing.Produce(ctx, "orders", "key", map[string]interface{}{"n": int64(3)}); err == nil {
+		t.Error("expected producing with a missing schema to fail")
+	}
+}
+
+// TestSchemaConsumerUnknownWriter checks that a message written with an unknown schema is reported
+// instead of blocking the consumer.
+func TestSchemaConsumerUnknownWriter(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	handled := false
+	consumer := NewSchemaConsumer(repo, func(message DecodedMessage) error {
+		handled = true
+		return nil
+	}).WithTimeout(50 * time.Millisecond)
+	done := make(chan error, 1)
+	go (func() {
+		done <- consumer.Handle(&kafka.Message{Value: FrameUUID(uuid.New(), []byte{2})})
+	})()
+	select {
+	case err := <-done:
+		if err == nil || handled {
+			t.Error("expected a message written with an unknown schema not to be handled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a message written with an unknown schema not to block the consumer")
+	}
+}
@@ -0,0 +1,254 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Compatibility is a compatibility level, describing how a schema may evolve from one version to the next.
+type Compatibility string
+
+const (
+	// CompatibilityNone allows any change.
+	CompatibilityNone Compatibility = "NONE"
+	// CompatibilityBackward requires that data written with the previous version can be read with the new version.
+	CompatibilityBackward Compatibility = "BACKWARD"
+	// CompatibilityBackwardTransitive requires that data written with any previous version can be read with the new version.
+	CompatibilityBackwardTransitive Compatibility = "BACKWARD_TRANSITIVE"
+	// CompatibilityForward requires that data written with the new version can be read with the previous version.
+	CompatibilityForward Compatibility = "FORWARD"
+	// CompatibilityForwardTransitive requires that data written with the new version can be read with any previous version.
+	CompatibilityForwardTransitive Compatibility = "FORWARD_TRANSITIVE"
+	// CompatibilityFull requires both backward and forward compatibility with the previous version.
+	CompatibilityFull Compatibility = "FULL"
+	// CompatibilityFullTransitive requires both backward and forward compatibility with all previous versions.
+	CompatibilityFullTransitive Compatibility = "FULL_TRANSITIVE"
+)
+
+// DefaultCompatibility is the compatibility level of names which have not been assigned a level.
+// Since it allows any change, versions may evolve freely unless a level has been set explicitly.
+const DefaultCompatibility = CompatibilityNone
+
+// ErrIncompatible is returned (wrapped) when a schema violates a compatibility level.
+var ErrIncompatible = errors.New("incompatible schema")
+
+// ParseCompatibility unmarshals a Compatibility from string, ignoring case.
+func ParseCompatibility(s string) (Compatibility, error) {
+	c := Compatibility(strings.ToUpper(s))
+	switch c {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityBackwardTransitive,
+		CompatibilityForward, CompatibilityForwardTransitive,
+		CompatibilityFull, CompatibilityFullTransitive:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown compatibility level %q", s)
+}
+
+// String casts Compatibility to string.
+func (c Compatibility) String() string {
+	return string(c)
+}
+
+// IsTransitive indicates whether a new version is checked against all previous versions,
+// rather than only the one preceding it.
+func (c Compatibility) IsTransitive() bool {
+	return strings.HasSuffix(string(c), "_TRANSITIVE")
+}
+
+func (c Compatibility) isBackward() bool {
+	return strings.HasPrefix(string(c), "BACKWARD") || strings.HasPrefix(string(c), "FULL")
+}
+
+func (c Compatibility) isForward() bool {
+	return strings.HasPrefix(string(c), "FORWARD") || strings.HasPrefix(string(c), "FULL")
+}
+
+// Check checks whether the candidate specification may succeed the previous specification.
+// Only the two given specifications are compared, so transitivity does not matter here.
+// Violations are reported by an error wrapping ErrIncompatible.
+func (c Compatibility) Check(candidate string, previous string) error {
+	if c.isBackward() {
+		if err := CheckReadable(candidate, previous); err != nil {
+			return err
+		}
+	}
+	if c.isForward() {
+		if err := CheckReadable(previous, candidate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckReadable checks whether data written with the writer specification can be read with the
+// reader specification, following the schema resolution rules of the Avro specification.
+// Violations are reported by an error wrapping ErrIncompatible.
+func CheckReadable(reader string, writer string) error {
+	readerSchema, err := parseAvroSchema(reader)
+	if err != nil {
+		return fmt.Errorf("reader: %v", err)
+	}
+	writerSchema, err := parseAvroSchema(writer)
+	if err != nil {
+		return fmt.Errorf("writer: %v", err)
+	}
+	check := readabilityCheck{inProgress: make(map[[2]*avroSchema]bool)}
+	return check.check(readerSchema, writerSchema, "")
+}
+
+// readabilityCheck implements the schema resolution rules.
+// It keeps track of the pairs of records currently being checked, so that recursive schemata terminate.
+type readabilityCheck struct {
+	inProgress map[[2]*avroSchema]bool
+}
+
+func incompatible(path string, format string, args ...interface{}) error {
+	if path == "" {
+		path = "."
+	}
+	return fmt.Errorf("%w: at %s: %s", ErrIncompatible, path, fmt.Sprintf(format, args...))
+}
+
+// avroPromotions lists the types a writer's type may be promoted to.
+var avroPromotions = map[string][]string{
+	"int":    {"long", "float", "double"},
+	"long":   {"float", "double"},
+	"float":  {"double"},
+	"string": {"bytes"},
+	"bytes":  {"string"},
+}
+
+func isPromotable(writer string, reader string) bool {
+	for _, promotion := range avroPromotions[writer] {
+		if promotion == reader {
+			return true
+		}
+	}
+	return false
+}
+
+func (c readabilityCheck) check(reader *avroSchema, writer *avroSchema, path string) error {
+	if writer.Type == "union" {
+		for _, branch := range writer.Branches {
+			if err := c.check(reader, branch, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if reader.Type == "union" {
+		for _, branch := range reader.Branches {
+			if c.check(branch, writer, path) == nil {
+				return nil
+			}
+		}
+		return incompatible(path, "reader union has no branch that can read writer type %s", writer.typeName())
+	}
+
+	if reader.Type != writer.Type {
+		if isPromotable(writer.Type, reader.Type) {
+			return nil
+		}
+		return incompatible(path, "writer type %s cannot be read as %s", writer.typeName(), reader.typeName())
+	}
+
+	switch reader.Type {
+	case "record":
+		return c.checkRecord(reader, writer, path)
+	case "enum":
+		if !namesMatch(reader, writer) {
+			return incompatible(path, "enum name %s does not match %s", reader.Name, writer.Name)
+		}
+		if reader.HasEnumDefault {
+			return nil
+		}
+		for _, symbol := range writer.Symbols {
+			if !containsString(reader.Symbols, symbol) {
+				return incompatible(path, "enum %s lacks symbol %s and has no default", reader.Name, symbol)
+			}
+		}
+	case "fixed":
+		if !namesMatch(reader, writer) {
+			return incompatible(path, "fixed name %s does not match %s", reader.Name, writer.Name)
+		}
+		if reader.Size != writer.Size {
+			return incompatible(path, "fixed %s has size %d, but writer has size %d", reader.Name, reader.Size, writer.Size)
+		}
+	case "array":
+		return c.check(reader.Items, writer.Items, path+"[]")
+	case "map":
+		return c.check(reader.Values, writer.Values, path+"{}")
+	}
+	return nil
+}
+
+func (c readabilityCheck) checkRecord(reader *avroSchema, writer *avroSchema, path string) error {
+	if !namesMatch(reader, writer) {
+		return incompatible(path, "record name %s does not match %s", reader.Name, writer.Name)
+	}
+	pair := [2]*avroSchema{reader, writer}
+	if c.inProgress[pair] {
+		return nil
+	}
+	c.inProgress[pair] = true
+	defer delete(c.inProgress, pair)
+
+	for _, readerField := range reader.Fields {
+		fieldPath := path + "." + readerField.Name
+		writerField, ok := writer.findField(readerField)
+		if !ok {
+			if !readerField.HasDefault {
+				return incompatible(fieldPath, "field is missing from writer and has no default")
+			}
+			continue
+		}
+		if err := c.check(readerField.Type, writerField.Type, fieldPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findField looks up the field of a record that corresponds to the given field of another record,
+// either by its name or by one of the field's aliases.
+func (s *avroSchema) findField(other *avroField) (*avroField, bool) {
+	for _, field := range s.Fields {
+		if field.Name == other.Name {
+			return field, true
+		}
+	}
+	for _, field := range s.Fields {
+		if containsString(other.Aliases, field.Name) {
+			return field, true
+		}
+	}
+	return nil, false
+}
+
+// namesMatch checks whether the reader's named type matches the writer's named type,
+// either by unqualified name or by one of the reader's aliases.
+func namesMatch(reader *avroSchema, writer *avroSchema) bool {
+	if shortName(reader.Name) == shortName(writer.Name) {
+		return true
+	}
+	return containsString(reader.Aliases, writer.Name)
+}
+
+func containsString(strs []string, s string) bool {
+	for _, candidate := range strs {
+		if candidate == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"testing"
+)
+
+// record returns the specification of a record named r with the given JSON fields.
+func record(fields string) string {
+	return fmt.Sprintf(`{"type": "record", "name": "r", "fields": [%s]}`, fields)
+}
+
+// TestCheckReadable checks the schema resolution rules for pairs of reader and writer specifications.
+func TestCheckReadable(t *testing.T) {
+	cases := []struct {
+		name     string
+		reader   string
+		writer   string
+		readable bool
+	}{
+		{"identical", record(`{"name": "a", "type": "int"}`), record(`{"name": "a", "type": "int"}`), true},
+		{"added field with default", record(`{"name": "a", "type": "int"}, {"name": "b", "type": "string", "default": ""}`), record(`{"name": "a", "type": "int"}`), true},
+		{"added field without default", record(`{"name": "a", "type": "int"}, {"name": "b", "type": "string"}`), record(`{"name": "a", "type": "int"}`), false},
+		{"removed field", record(`{"name": "a", "type": "int"}`), record(`{"name": "a", "type": "int"}, {"name": "b", "type": "string"}`), true},
+		{"renamed field with alias", record(`{"name": "b", "type": "int", "aliases": ["a"]}`), record(`{"name": "a", "type": "int"}`), true},
+		{"renamed field without alias", record(`{"name": "b", "type": "int"}`), record(`{"name": "a", "type": "int"}`), false},
+
+		{"int to long", `"long"`, `"int"`, true},
+		{"int to float", `"float"`, `"int"`, true},
+		{"int to double", `"double"`, `"int"`, true},
+		{"long to float", `"float"`, `"long"`, true},
+		{"long to double", `"double"`, `"long"`, true},
+		{"float to double", `"double"`, `"float"`, true},
+		{"long to int", `"int"`, `"long"`, false},
+		{"double to float", `"float"`, `"double"`, false},
+		{"string to bytes", `"bytes"`, `"string"`, true},
+		{"bytes to string", `"string"`, `"bytes"`, true},
+		{"string to int", `"int"`, `"string"`, false},
+		{"promoted field", record(`{"name": "a", "type": "long"}`), record(`{"name": "a", "type": "int"}`), true},
+
+		{"added enum symbol", `{"type": "enum", "name": "e", "symbols": ["A", "B", "C"]}`, `{"type": "enum", "name": "e", "symbols": ["A", "B"]}`, true},
+		{"removed enum symbol", `{"type": "enum", "name": "e", "symbols": ["A"]}`, `{"type": "enum", "name": "e", "symbols": ["A", "B"]}`, false},
+		{"removed enum symbol with default", `{"type": "enum", "name": "e", "symbols": ["A"], "default": "A"}`, `{"type": "enum", "name": "e", "symbols": ["A", "B"]}`, true},
+		{"renamed enum", `{"type": "enum", "name": "f", "symbols": ["A"]}`, `{"type": "enum", "name": "e", "symbols": ["A"]}`, false},
+
+		{"widened to union", `["null", "string"]`, `"string"`, true},
+		{"widened union", `["null", "string", "int"]`, `["null", "string"]`, true},
+		{"union branch promoted", `["null", "long"]`, `["null", "int"]`, true},
+		{"narrowed to non-union", `"string"`, `["null", "string"]`, false},
+		{"narrowed union", `["null", "string"]`, `["null", "string", "int"]`, false},
+		{"union lacking writer type", `["null", "int"]`, `"string"`, false},
+
+		{"renamed record", `{"type": "record", "name": "s", "fields": []}`, `{"type": "record", "name": "r", "fields": []}`, false},
+		{"renamed record with alias", `{"type": "record", "name": "s", "aliases": ["r"], "fields": []}`, `{"type": "record", "name": "r", "fields": []}`, true},
+		{"moved record", `{"type": "record", "name": "r", "namespace": "new", "fields": []}`, `{"type": "record", "name": "r", "namespace": "old", "fields": []}`, true},
+		{"renamed fixed with alias", `{"type": "fixed", "name": "g", "aliases": ["f"], "size": 4}`, `{"type": "fixed", "name": "f", "size": 4}`, true},
+		{"resized fixed", `{"type": "fixed", "name": "f", "size": 8}`, `{"type": "fixed", "name": "f", "size": 4}`, false},
+
+		{"promoted array items", `{"type": "array", "items": "long"}`, `{"type": "array", "items": "int"}`, true},
+		{"incompatible map values", `{"type": "map", "values": "int"}`, `{"type": "map", "values": "string"}`, false},
+		{"recursive record", record(`{"name": "next", "type": ["null", "r"]}`), record(`{"name": "next", "type": ["null", "r"]}`), true},
+	}
+	for _, c := range cases {
+		err := CheckReadable(c.reader, c.writer)
+		if c.readable && err != nil {
+			t.Errorf("%v: expected %v to be readable as %v, got %v", c.name, c.writer, c.reader, err)
+		}
+		if !c.readable && !errors.Is(err, ErrIncompatible) {
+			t.Errorf("%v: expected %v not to be readable as %v, got %v", c.name, c.writer, c.reader, err)
+		}
+	}
+}
+
+// TestCompatibilityCheck checks the levels against a single previous specification.
+func TestCompatibilityCheck(t *testing.T) {
+	previous := record(`{"name": "a", "type": "int"}`)
+	addedWithDefault := record(`{"name": "a", "type": "int"}, {"name": "b", "type": "string", "default": ""}`)
+	addedWithoutDefault := record(`{"name": "a", "type": "int"}, {"name": "b", "type": "string"}`)
+	removed := record(`{"name": "b", "type": "string", "default": ""}`)
+
+	cases := []struct {
+		candidate  string
+		level      Compatibility
+		compatible bool
+	}{
+		{addedWithDefault, CompatibilityBackward, true},
+		{addedWithDefault, CompatibilityForward, true},
+		{addedWithDefault, CompatibilityFull, true},
+		{addedWithoutDefault, CompatibilityBackward, false},
+		{addedWithoutDefault, CompatibilityForward, true},
+		{addedWithoutDefault, CompatibilityFull, false},
+		{removed, CompatibilityBackward, true},
+		{removed, CompatibilityForward, false},
+		{removed, CompatibilityFull, false},
+		{`"string"`, CompatibilityNone, true},
+	}
+	for _, c := range cases {
+		err := c.level.Check(c.candidate, previous)
+		if c.compatible && err != nil {
+			t.Errorf("expected %v to succeed %v with level %v, got %v", c.candidate, previous, c.level, err)
+		}
+		if !c.compatible && !errors.Is(err, ErrIncompatible) {
+			t.Errorf("expected %v not to succeed %v with level %v, got %v", c.candidate, previous, c.level, err)
+		}
+	}
+}
+
+// TestLocalRepoCheckCompatibility checks that transitive levels check every previous version,
+// while the other levels only check the latest one.
+func TestLocalRepoCheckCompatibility(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	// The versions are published without checking, the first one is incompatible with the second one
+	specifications := []string{record(`{"name": "a", "type": "int"}`), record(`{"name": "b", "type": "string"}`)}
+	for i, specification := range specifications {
+		version := NameVersion{Name: "transitive", Version: uint(i)}
+		ready := repo.WaitVersionReady(version)
+		schemaUUID := uuid.New()
+		if err := updater.UpdateSchema(schemaUUID, specification); err != nil {
+			t.Fatal(err)
+		}
+		if err := updater.UpdateAlias(version.String(), schemaUUID); err != nil {
+			t.Fatal(err)
+		}
+		<-ready
+	}
+
+	candidate := NameVersion{Name: "transitive", Version: 2}
+	for level, compatible := range map[Compatibility]bool{
+		CompatibilityNone:               true,
+		CompatibilityBackward:           true,
+		CompatibilityBackwardTransitive: false,
+		CompatibilityForward:            true,
+		CompatibilityForwardTransitive:  false,
+		CompatibilityFull:               true,
+		CompatibilityFullTransitive:     false,
+	} {
+		err := repo.CheckCompatibility(candidate, specifications[1], level)
+		if compatible && err != nil {
+			t.Errorf("expected %v to be compatible with level %v, got %v", candidate, level, err)
+		}
+		if !compatible && !errors.Is(err, ErrIncompatible) {
+			t.Errorf("expected %v to be incompatible with level %v, got %v", candidate, level, err)
+		}
+	}
+
+	// Only the versions preceding the checked one are checked against
+	if err := repo.CheckCompatibility(NameVersion{Name: "transitive", Version: 1}, specifications[1], CompatibilityFullTransitive); err == nil {
+		t.Error("expected the second version to be checked against the first one")
+	}
+	if err := repo.CheckCompatibility(NewVersionOrigin("transitive"), specifications[1], CompatibilityFullTransitive); err != nil {
+		t.Errorf("expected the first version not to be checked, got %v", err)
+	}
+}
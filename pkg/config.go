@@ -0,0 +1,143 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	"log"
+)
+
+// Logger is where a LocalRepo and its KafkaLogReader log to. *log.Logger is a Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger logs through the standard logger of package log.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// repoConfig is the configuration assembled from RepoOptions.
+type repoConfig struct {
+	consumer kafka.ConfigMap
+	options  Options
+	logger   Logger
+}
+
+// RepoOption configures a LocalRepo constructed with NewLocalRepoWithConfig.
+type RepoOption func(config *repoConfig)
+
+// defaultConsumerConfig is the configuration of the Kafka consumer of a LocalRepo.
+// Every repo uses its own consumer group and does not commit its offsets,
+// so that it always consumes all events from the beginning.
+func defaultConsumerConfig(broker string) kafka.ConfigMap {
+	return kafka.ConfigMap{
+		"bootstrap.servers":     broker,
+		"group.id":              uuid.New().String(),
+		"broker.address.family": "v4",
+		"session.timeout.ms":    6000,
+		"auto.offset.reset":     "earliest",
+		"enable.auto.commit":    false,
+	}
+}
+
+// WithConfigMap sets arbitrary properties of the Kafka consumer, overriding the defaults and earlier options.
+func WithConfigMap(overlay kafka.ConfigMap) RepoOption {
+	return func(config *repoConfig) {
+		for key, value := range overlay {
+			config.consumer[key] = value
+		}
+	}
+}
+
+// WithGroupID sets the group ID of the Kafka consumer, e.g. to comply with ACLs on consumer groups.
+// Every repo needs a group ID of its own, since repos sharing a group would split the partitions among them.
+// Offsets are not committed, so the repo still consumes all events from the beginning.
+func WithGroupID(groupID string) RepoOption {
+	return WithConfigMap(kafka.ConfigMap{"group.id": groupID})
+}
+
+// WithSASL authenticates the Kafka consumer using the given SASL mechanism, e.g. PLAIN or SCRAM-SHA-512.
+// The credentials are only ever sent through TLS, which can be configured further with WithSSL.
+func WithSASL(mechanism string, username string, password string) RepoOption {
+	return WithConfigMap(kafka.ConfigMap{
+		"security.protocol": "SASL_SSL",
+		"sasl.mechanisms":   mechanism,
+		"sasl.username":     username,
+		"sasl.password":     password,
+	})
+}
+
+// WithSSL connects the Kafka consumer through TLS.
+// The CA certificate verifies the brokers, it may be empty to use the system's CA certificates.
+// The client certificate and key authenticate the consumer, they may be empty if the brokers do not require them.
+// All arguments are paths to PEM files.
+func WithSSL(caLocation string, certificateLocation string, keyLocation string) RepoOption {
+	return func(config *repoConfig) {
+		if protocol, _ := config.consumer.Get("security.protocol", ""); protocol != "SASL_SSL" {
+			config.consumer["security.protocol"] = "SSL"
+		}
+		locations := map[string]string{
+			"ssl.ca.location":          caLocation,
+			"ssl.certificate.location": certificateLocation,
+			"ssl.key.location":         keyLocation,
+		}
+		for key, location := range locations {
+			if location != "" {
+				config.consumer[key] = location
+			}
+		}
+	}
+}
+
+// WithLogger sets the logger of the repo and its KafkaLogReader. By default, they use the standard logger of package log.
+func WithLogger(logger Logger) RepoOption {
+	return func(config *repoConfig) {
+		config.logger = logger
+	}
+}
+
+// WithTopicOptions sets the topics of the schema repository to consume, see NewLocalRepoWithOptions.
+func WithTopicOptions(options Options) RepoOption {
+	return func(config *repoConfig) {
+		config.options = options
+	}
+}
+
+func newRepoConfig(broker string, repoOptions []RepoOption) repoConfig {
+	config := repoConfig{consumer: defaultConsumerConfig(broker), logger: stdLogger{}}
+	for _, option := range repoOptions {
+		option(&config)
+	}
+	return config
+}
+
+// NewLocalRepoWithConfig constructs a LocalRepo configured for the specified Kafka broker and the given options.
+// Note that since the repo is a Consumer, it needs to be started with Run() before it starts consuming.
+func NewLocalRepoWithConfig(broker string, repoOptions ...RepoOption) (LocalRepo, error) {
+	config := newRepoConfig(broker, repoOptions)
+	consumer, err := kafka.NewConsumer(&config.consumer)
+	if err != nil {
+		return LocalRepo{}, err
+	}
+	return newLocalRepo(newKafkaLogReader(consumer, config.logger), config.options, config.logger), nil
+}
+
+// NewLocalRepoWithConsumer constructs a LocalRepo that consumes through the given Kafka consumer.
+// The consumer needs its own consumer group and must not commit offsets, as described in NewLocalRepoWithConfig.
+// Options concerning the consumer's configuration are ignored.
+// In most cases, it is fine to use NewLocalRepoWithConfig instead and let it create the consumer.
+func NewLocalRepoWithConsumer(consumer *kafka.Consumer, repoOptions ...RepoOption) LocalRepo {
+	config := newRepoConfig("", repoOptions)
+	return newLocalRepo(newKafkaLogReader(consumer, config.logger), config.options, config.logger)
+}
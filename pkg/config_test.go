@@ -0,0 +1,49 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"testing"
+)
+
+// TestRepoOptions checks that options are applied on top of the default consumer configuration in the order they are given.
+func TestRepoOptions(t *testing.T) {
+	config := newRepoConfig("broker0:9092", []RepoOption{
+		WithSASL("SCRAM-SHA-512", "user", "secret"),
+		WithSSL("/etc/kafka/ca.pem", "", ""),
+		WithGroupID("schema-repo-1"),
+		WithConfigMap(kafka.ConfigMap{"session.timeout.ms": 10000}),
+		WithTopicOptions(Options{Namespace: "tenant"}),
+	})
+
+	expected := map[string]kafka.ConfigValue{
+		"bootstrap.servers":  "broker0:9092",
+		"group.id":           "schema-repo-1",
+		"security.protocol":  "SASL_SSL",
+		"sasl.mechanisms":    "SCRAM-SHA-512",
+		"sasl.username":      "user",
+		"sasl.password":      "secret",
+		"ssl.ca.location":    "/etc/kafka/ca.pem",
+		"session.timeout.ms": 10000,
+		"enable.auto.commit": false,
+	}
+	for key, value := range expected {
+		if actual := config.consumer[key]; actual != value {
+			t.Errorf("expected %v to be %v, got %v", key, value, actual)
+		}
+	}
+	if _, ok := config.consumer["ssl.certificate.location"]; ok {
+		t.Error("expected empty SSL locations not to be set")
+	}
+	if config.options.TopicNames().Alias != "tenant.schema_alias" {
+		t.Errorf("expected the topics of namespace tenant, got %v", config.options.TopicNames())
+	}
+}
@@ -10,13 +10,16 @@
 package kafka_schema
 
 import (
+	"encoding/json"
 	"github.com/google/uuid"
 )
 
 // SchemaDTO is used by the explorer to encode its response body.
 type SchemaDTO struct {
 	UUID          uuid.UUID `json:"uuid"`
+	ID            int32     `json:"id,omitempty"`
 	Specification string    `json:"spec"`
+	Deleted       bool      `json:"deleted,omitempty"`
 }
 
 // SchemataDTO is used by the explorer to encode its response body.
@@ -25,9 +28,11 @@ type SchemataDTO struct {
 }
 
 // SchemaListDTO is used by the explorer to encode its response body.
+// LastID is the highest integer ID which has been assigned to any schema.
 type SchemaListDTO struct {
 	Count    int         `json:"count"`
 	Schemata []uuid.UUID `json:"schemata"`
+	LastID   int32       `json:"lastID,omitempty"`
 }
 
 // AliasDTO is used by the explorer to encode its response body.
@@ -48,13 +53,89 @@ type AliasesDTO struct {
 }
 
 // UpdateRequest sets the given UUID to equal the given plain-text Avro spec.
+// If Deleted is set, it soft-deletes the given UUID, the Spec and ID are kept so that the schema stays decodable
+// once the topic is compacted. ID is the integer ID assigned to the UUID by the Updater. Events written before IDs were part of them have no ID,
+// these UUIDs are assigned IDs in the order in which they are consumed.
 type UpdateRequest struct {
-	UUID uuid.UUID `json:"UUID"`
-	Spec string    `json:"spec"`
+	UUID    uuid.UUID `json:"UUID"`
+	ID      int32     `json:"id,omitempty"`
+	Spec    string    `json:"spec"`
+	Deleted bool      `json:"deleted,omitempty"`
 }
 
 // AliasRequest sets the given Alias to equal the given UUID.
+// If Deleted is set, it soft-deletes the given Alias instead.
 type AliasRequest struct {
-	UUID  uuid.UUID `json:"UUID"`
-	Alias string    `json:"alias"`
+	UUID    uuid.UUID `json:"UUID"`
+	Alias   string    `json:"alias"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+// CompatibilityRequest sets the compatibility level of all versions of the given name.
+type CompatibilityRequest struct {
+	Name          string        `json:"name"`
+	Compatibility Compatibility `json:"compatibility"`
+}
+
+// CompatibilityDTO is used by the explorer to encode its response body.
+type CompatibilityDTO struct {
+	Name          string        `json:"name"`
+	Compatibility Compatibility `json:"compatibility"`
+}
+
+// CompatibilityCheckDTO is the result of checking whether a specification may be published as a version of a name.
+// It is used by the explorer to encode its response body.
+type CompatibilityCheckDTO struct {
+	Name          string        `json:"name"`
+	Version       uint          `json:"version"`
+	Alias         Alias         `json:"alias"`
+	Compatibility Compatibility `json:"compatibility"`
+	Compatible    bool          `json:"compatible"`
+	Reason        string        `json:"reason,omitempty"`
+}
+
+// ViolationDTO is used by the explorer to encode its response body.
+type ViolationDTO struct {
+	Alias         Alias         `json:"alias"`
+	UUID          uuid.UUID     `json:"uuid"`
+	Compatibility Compatibility `json:"compatibility"`
+	Reason        string        `json:"reason"`
+}
+
+// ViolationListDTO is used by the explorer to encode its response body.
+type ViolationListDTO struct {
+	Count      int            `json:"count"`
+	Violations []ViolationDTO `json:"violations"`
+}
+
+// VersionListDTO is used by the explorer to encode its response body.
+type VersionListDTO struct {
+	Name     string `json:"name"`
+	Versions []uint `json:"versions"`
+	Count    int    `json:"count"`
+}
+
+// SubjectVersionDTO is used by the explorer to encode its response body.
+type SubjectVersionDTO struct {
+	Name    string    `json:"name"`
+	Version uint      `json:"version"`
+	Alias   Alias     `json:"alias"`
+	UUID    uuid.UUID `json:"uuid"`
+}
+
+// ReadyDTO is used by the explorer to encode its response body.
+// The explorer is ready once it has consumed every event that existed when it was started.
+type ReadyDTO struct {
+	Ready bool `json:"ready"`
+}
+
+// InspectedDTO describes a message decoded by func LocalRepo.Inspect.
+// It is used by the explorer to encode its response body.
+type InspectedDTO struct {
+	Framing Framing `json:"framing"`
+	// UUID identifies the writer schema, Versions are the versions pointing at it.
+	UUID     uuid.UUID           `json:"uuid"`
+	Versions []SubjectVersionDTO `json:"versions"`
+	// Datum is the decoded datum in the Avro JSON encoding.
+	Datum json.RawMessage `json:"datum"`
 }
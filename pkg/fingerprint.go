@@ -0,0 +1,143 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// crc64AvroEmpty is the fingerprint of empty input, as defined by the Avro specification.
+const crc64AvroEmpty uint64 = 0xc15d213aa4d7a795
+
+var crc64AvroTable = makeCRC64AvroTable()
+
+func makeCRC64AvroTable() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		fp := uint64(i)
+		for j := 0; j < 8; j++ {
+			fp = (fp >> 1) ^ (crc64AvroEmpty & -(fp & 1))
+		}
+		table[i] = fp
+	}
+	return table
+}
+
+// crc64Avro computes the CRC-64-AVRO fingerprint of the given data.
+func crc64Avro(data []byte) uint64 {
+	fp := crc64AvroEmpty
+	for _, b := range data {
+		fp = (fp >> 8) ^ crc64AvroTable[byte(fp)^b]
+	}
+	return fp
+}
+
+// CanonicalForm returns the Parsing Canonical Form of a plain-text Avro specification.
+// Two specifications with the same canonical form describe the same binary encoding.
+func CanonicalForm(specification string) (string, error) {
+	schema, err := parseAvroSchema(specification)
+	if err != nil {
+		return "", err
+	}
+	return canonicalForm(schema), nil
+}
+
+func canonicalForm(schema *avroSchema) string {
+	canonical := bytes.Buffer{}
+	writeCanonicalForm(&canonical, schema, make(map[string]bool))
+	return canonical.String()
+}
+
+// Fingerprint computes the CRC-64-AVRO fingerprint of the canonical form of a plain-text Avro specification.
+func Fingerprint(specification string) (uint64, error) {
+	schema, err := parseAvroSchema(specification)
+	if err != nil {
+		return 0, err
+	}
+	return schemaFingerprint(schema), nil
+}
+
+func schemaFingerprint(schema *avroSchema) uint64 {
+	return crc64Avro([]byte(canonicalForm(schema)))
+}
+
+// writeCanonicalForm writes the canonical form of schema, keeping only the attributes relevant
+// for the binary encoding, in the order given by the specification.
+// Named types that have already been written are referenced by their full name.
+func writeCanonicalForm(buf *bytes.Buffer, schema *avroSchema, written map[string]bool) {
+	if schema.isNamed() {
+		if written[schema.Name] {
+			writeJSONString(buf, schema.Name)
+			return
+		}
+		written[schema.Name] = true
+	}
+
+	switch schema.Type {
+	case "record":
+		buf.WriteString(`{"name":`)
+		writeJSONString(buf, schema.Name)
+		buf.WriteString(`,"type":"record","fields":[`)
+		for i, field := range schema.Fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"name":`)
+			writeJSONString(buf, field.Name)
+			buf.WriteString(`,"type":`)
+			writeCanonicalForm(buf, field.Type, written)
+			buf.WriteByte('}')
+		}
+		buf.WriteString(`]}`)
+	case "enum":
+		buf.WriteString(`{"name":`)
+		writeJSONString(buf, schema.Name)
+		buf.WriteString(`,"type":"enum","symbols":[`)
+		for i, symbol := range schema.Symbols {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, symbol)
+		}
+		buf.WriteString(`]}`)
+	case "fixed":
+		buf.WriteString(`{"name":`)
+		writeJSONString(buf, schema.Name)
+		buf.WriteString(`,"type":"fixed","size":`)
+		buf.WriteString(strconv.Itoa(schema.Size))
+		buf.WriteByte('}')
+	case "array":
+		buf.WriteString(`{"type":"array","items":`)
+		writeCanonicalForm(buf, schema.Items, written)
+		buf.WriteByte('}')
+	case "map":
+		buf.WriteString(`{"type":"map","values":`)
+		writeCanonicalForm(buf, schema.Values, written)
+		buf.WriteByte('}')
+	case "union":
+		buf.WriteByte('[')
+		for i, branch := range schema.Branches {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalForm(buf, branch, written)
+		}
+		buf.WriteByte(']')
+	default:
+		writeJSONString(buf, schema.Type)
+	}
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	encoded, _ := json.Marshal(s)
+	buf.Write(encoded)
+}
@@ -0,0 +1,163 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestCanonicalForm checks the Parsing Canonical Form against vectors from the Avro specification.
+func TestCanonicalForm(t *testing.T) {
+	cases := []struct {
+		specification string
+		canonical     string
+	}{
+		{`"null"`, `"null"`},
+		{`{"type": "null"}`, `"null"`},
+		{`{"type": "int", "doc": "primitive"}`, `"int"`},
+		{`["int", "boolean"]`, `["int","boolean"]`},
+		{`{"fields": [], "type": "record", "name": "foo"}`, `{"name":"foo","type":"record","fields":[]}`},
+		{`{"fields": [], "type": "record", "name": "foo", "namespace": "x.y"}`, `{"name":"x.y.foo","type":"record","fields":[]}`},
+		{`{"fields": [], "type": "record", "name": "a.b.foo", "namespace": "x.y"}`, `{"name":"a.b.foo","type":"record","fields":[]}`},
+		{
+			`{"doc": "test", "aliases": ["bar"], "type": "record", "name": "foo", "fields": [{"type": "int", "name": "f1", "default": 0, "doc": "field"}]}`,
+			`{"name":"foo","type":"record","fields":[{"name":"f1","type":"int"}]}`,
+		},
+		{
+			`{"type": "record", "name": "foo", "namespace": "x", "fields": [{"name": "next", "type": ["null", "foo"]}, {"name": "same", "type": "x.foo"}]}`,
+			`{"name":"x.foo","type":"record","fields":[{"name":"next","type":["null","x.foo"]},{"name":"same","type":"x.foo"}]}`,
+		},
+		{`{"type": "enum", "name": "foo", "symbols": ["A1"], "doc": "enum"}`, `{"name":"foo","type":"enum","symbols":["A1"]}`},
+		{`{"namespace": "x.y.z", "type": "enum", "name": "foo", "symbols": ["A1", "A2"]}`, `{"name":"x.y.z.foo","type":"enum","symbols":["A1","A2"]}`},
+		{`{"name": "foo", "type": "fixed", "size": 15}`, `{"name":"foo","type":"fixed","size":15}`},
+		{`{"items": "null", "type": "array"}`, `{"type":"array","items":"null"}`},
+		{`{"values": "string", "type": "map"}`, `{"type":"map","values":"string"}`},
+	}
+	for _, c := range cases {
+		canonical, err := CanonicalForm(c.specification)
+		if err != nil {
+			t.Errorf("%v: %v", c.specification, err)
+			continue
+		}
+		if canonical != c.canonical {
+			t.Errorf("expected the canonical form of %v to be %v, got %v", c.specification, c.canonical, canonical)
+		}
+	}
+}
+
+// TestFingerprint checks the CRC-64-AVRO fingerprints against vectors from the Avro specification,
+// which lists them as signed 64 bit integers.
+func TestFingerprint(t *testing.T) {
+	if fp := crc64Avro(nil); fp != crc64AvroEmpty {
+		t.Errorf("expected the fingerprint of empty input to be %x, got %x", crc64AvroEmpty, fp)
+	}
+
+	cases := []struct {
+		specification string
+		fingerprint   int64
+	}{
+		{`"null"`, 7195948357588979594},
+		{`{"type": "null"}`, 7195948357588979594},
+		{`"boolean"`, -6970731678124411036},
+		{`"int"`, 8247732601305521295},
+		{`"long"`, -3434872931120570953},
+		{`"float"`, 5583340709985441680},
+		{`"double"`, -8181574048448539266},
+		{`"bytes"`, 5746618253357095269},
+		{`"string"`, -8142146995180207161},
+		{`[]`, -1241056759729112623},
+		{`["int"]`, -5232228896498058493},
+		{`["int", "boolean"]`, 5392556393470105090},
+		{`{"fields": [], "type": "record", "name": "foo"}`, -4824392279771201922},
+		{`{"type": "enum", "name": "foo", "symbols": ["A1"]}`, -6342190197741309591},
+		{`{"type": "fixed", "name": "foo", "size": 15}`, 1756455273707447556},
+	}
+	for _, c := range cases {
+		fp, err := Fingerprint(c.specification)
+		if err != nil {
+			t.Errorf("%v: %v", c.specification, err)
+			continue
+		}
+		if int64(fp) != c.fingerprint {
+			t.Errorf("expected the fingerprint of %v to be %v, got %v", c.specification, c.fingerprint, int64(fp))
+		}
+	}
+}
+
+// TestSingleObjectFraming frames and unframes messages in the Avro single-object encoding.
+func TestSingleObjectFraming(t *testing.T) {
+	fp := uint64(0x0102030405060708)
+	datum := []byte{0x02, 0x61}
+	framed := FrameSingleObject(fp, datum)
+	expected := []byte{0xC3, 0x01, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01, 0x02, 0x61}
+	if !bytes.Equal(framed, expected) {
+		t.Fatalf("expected %x, got %x", expected, framed)
+	}
+	unframedFP, unframed, err := UnframeSingleObject(framed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unframedFP != fp || !bytes.Equal(unframed, datum) {
+		t.Errorf("expected %x and %x, got %x and %x", fp, datum, unframedFP, unframed)
+	}
+	for _, message := range [][]byte{nil, {0xC3, 0x01, 0x00}, {0xC3, 0x02, 0, 0, 0, 0, 0, 0, 0, 0}} {
+		if _, _, err := UnframeSingleObject(message); !errors.Is(err, ErrInvalidFraming) {
+			t.Errorf("expected unframing %x to fail with %v, got %v", message, ErrInvalidFraming, err)
+		}
+	}
+}
+
+// TestLocalRepoSingleObject encodes and decodes a datum in the single-object encoding of a published schema.
+func TestLocalRepoSingleObject(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	specification := record(`{"name": "a", "type": "string"}`)
+	schemaUUID := publishSchema(t, updater, repo, specification)
+	datum := map[string]interface{}{"a": "x"}
+	message, err := repo.EncodeSingleObject(schemaUUID, datum)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp, _, err := UnframeSingleObject(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := Fingerprint(specification); fp != expected {
+		t.Errorf("expected the message to carry the fingerprint %x, got %x", expected, fp)
+	}
+
+	decoded, writer, err := repo.DecodeSingleObject(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writer != schemaUUID {
+		t.Errorf("expected the writer schema %v, got %v", schemaUUID, writer)
+	}
+	if !reflect.DeepEqual(decoded, datum) {
+		t.Errorf("expected %#v, got %#v", datum, decoded)
+	}
+
+	if _, _, err := repo.DecodeSingleObject(FrameSingleObject(fp+1, message[singleObjectHeaderLength:])); err == nil {
+		t.Error("expected decoding a message with an unknown fingerprint to fail")
+	}
+}
@@ -0,0 +1,120 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+)
+
+// Framing names a way of identifying the writer schema of a message.
+type Framing string
+
+const (
+	// FramingRaw messages consist of the Avro binary datum only, the writer schema needs to be known by other means.
+	FramingRaw Framing = "raw"
+	// FramingUUID messages start with the header written by FrameUUID.
+	FramingUUID Framing = "uuid"
+	// FramingSingleObject messages use the Avro single-object encoding written by FrameSingleObject.
+	FramingSingleObject Framing = "single-object"
+	// FramingConfluent messages use the compact framing written by FrameID, which is the Confluent wire format.
+	FramingConfluent Framing = "confluent"
+)
+
+// ParseFraming unmarshals a Framing from string, ignoring case.
+func ParseFraming(s string) (Framing, error) {
+	f := Framing(strings.ToLower(s))
+	switch f {
+	case FramingRaw, FramingUUID, FramingSingleObject, FramingConfluent:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown framing %q", s)
+}
+
+// FramingMagicUUID is the first byte of every framed message.
+// It is followed by the 16 bytes of the writer schema's UUID and the Avro binary datum.
+// The layout mirrors the Confluent wire format, which uses the magic byte 0x00 and a 4 byte schema ID instead.
+const FramingMagicUUID byte = 0x01
+
+// FramingMagicID is the first byte of every message in the compact framing.
+// It is followed by the 4 byte big-endian integer ID of the writer schema and the Avro binary datum.
+// This is the Confluent wire format, so messages in the compact framing can be read by Confluent deserializers.
+const FramingMagicID byte = 0x00
+
+// framingHeaderLength is the length of the header preceding the datum in a framed message.
+const framingHeaderLength = 1 + 16
+
+// compactHeaderLength is the length of the header preceding the datum in the compact framing.
+const compactHeaderLength = 1 + 4
+
+// singleObjectMarker is the two byte marker preceding every message in the Avro single-object encoding.
+var singleObjectMarker = [2]byte{0xC3, 0x01}
+
+// singleObjectHeaderLength is the length of the header preceding the datum in the single-object encoding.
+const singleObjectHeaderLength = 2 + 8
+
+// ErrInvalidFraming is returned when decoding a message whose header does not match the expected framing.
+var ErrInvalidFraming = errors.New("invalid message framing")
+
+// FrameUUID prepends the framing header for the given schema to an Avro binary datum.
+func FrameUUID(schema uuid.UUID, datum []byte) []byte {
+	framed := make([]byte, 0, framingHeaderLength+len(datum))
+	framed = append(framed, FramingMagicUUID)
+	framed = append(framed, schema[:]...)
+	return append(framed, datum...)
+}
+
+// UnframeUUID splits a framed message into the writer schema's UUID and the Avro binary datum.
+func UnframeUUID(message []byte) (uuid.UUID, []byte, error) {
+	if len(message) < framingHeaderLength || message[0] != FramingMagicUUID {
+		return uuid.Nil, nil, ErrInvalidFraming
+	}
+	schema, err := uuid.FromBytes(message[1:framingHeaderLength])
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	return schema, message[framingHeaderLength:], nil
+}
+
+// FrameID prepends the header of the compact framing for the given integer schema ID to an Avro binary datum.
+func FrameID(id int32, datum []byte) []byte {
+	framed := make([]byte, compactHeaderLength, compactHeaderLength+len(datum))
+	framed[0] = FramingMagicID
+	binary.BigEndian.PutUint32(framed[1:], uint32(id))
+	return append(framed, datum...)
+}
+
+// UnframeID splits a message in the compact framing into the writer schema's integer ID and the Avro binary datum.
+func UnframeID(message []byte) (int32, []byte, error) {
+	if len(message) < compactHeaderLength || message[0] != FramingMagicID {
+		return 0, nil, ErrInvalidFraming
+	}
+	return int32(binary.BigEndian.Uint32(message[1:])), message[compactHeaderLength:], nil
+}
+
+// FrameSingleObject prepends the header of the Avro single-object encoding to an Avro binary datum.
+// The header consists of the marker 0xC3 0x01 and the little-endian CRC-64-AVRO fingerprint of the writer schema.
+func FrameSingleObject(fingerprint uint64, datum []byte) []byte {
+	framed := make([]byte, singleObjectHeaderLength, singleObjectHeaderLength+len(datum))
+	copy(framed, singleObjectMarker[:])
+	binary.LittleEndian.PutUint64(framed[2:], fingerprint)
+	return append(framed, datum...)
+}
+
+// UnframeSingleObject splits a single-object encoded message into the writer schema's fingerprint and the Avro binary datum.
+func UnframeSingleObject(message []byte) (uint64, []byte, error) {
+	if len(message) < singleObjectHeaderLength || message[0] != singleObjectMarker[0] || message[1] != singleObjectMarker[1] {
+		return 0, nil, ErrInvalidFraming
+	}
+	return binary.LittleEndian.Uint64(message[2:]), message[singleObjectHeaderLength:], nil
+}
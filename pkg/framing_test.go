@@ -0,0 +1,96 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+)
+
+// TestUUIDFraming frames and unframes messages with the UUID of their writer schema.
+func TestUUIDFraming(t *testing.T) {
+	schemaUUID := uuid.MustParse("00112233-4455-6677-8899-aabbccddeeff")
+	datum := []byte{0x02, 0x61}
+	framed := FrameUUID(schemaUUID, datum)
+	expected := []byte{FramingMagicUUID, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x02, 0x61}
+	if !bytes.Equal(framed, expected) {
+		t.Fatalf("expected %x, got %x", expected, framed)
+	}
+	writer, unframed, err := UnframeUUID(framed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writer != schemaUUID || !bytes.Equal(unframed, datum) {
+		t.Errorf("expected %v and %x, got %v and %x", schemaUUID, datum, writer, unframed)
+	}
+
+	// A datum may be empty, e.g. for the null type
+	if writer, unframed, err := UnframeUUID(FrameUUID(schemaUUID, nil)); err != nil || writer != schemaUUID || len(unframed) != 0 {
+		t.Errorf("expected an empty datum written with %v, got %x written with %v (%v)", schemaUUID, unframed, writer, err)
+	}
+
+	wrongMagic := append([]byte{}, framed...)
+	wrongMagic[0] = FramingMagicUUID + 1
+	for name, message := range map[string][]byte{
+		"empty":       nil,
+		"magic only":  {FramingMagicUUID},
+		"truncated":   framed[:framingHeaderLength-1],
+		"wrong magic": wrongMagic,
+	} {
+		if _, _, err := UnframeUUID(message); !errors.Is(err, ErrInvalidFraming) {
+			t.Errorf("%v: expected unframing %x to fail with %v, got %v", name, message, ErrInvalidFraming, err)
+		}
+	}
+}
+
+// TestLocalRepoFramed encodes and decodes a datum framed with the UUID of a published schema.
+func TestLocalRepoFramed(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	schemaUUID := uuid.New()
+	ready := repo.WaitSchemaReady(schemaUUID)
+	if err := updater.UpdateSchema(schemaUUID, `"string"`); err != nil {
+		t.Fatal(err)
+	}
+	<-ready
+
+	message, err := repo.EncodeFramed(schemaUUID, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writer, _, err := UnframeUUID(message); err != nil || writer != schemaUUID {
+		t.Errorf("expected the message to be framed with %v, got %v (%v)", schemaUUID, writer, err)
+	}
+	decoded, writer, err := repo.DecodeFramed(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writer != schemaUUID || decoded != "x" {
+		t.Errorf("expected %v written with %v, got %v written with %v", "x", schemaUUID, decoded, writer)
+	}
+
+	if _, err := repo.EncodeFramed(uuid.New(), "x"); err == nil {
+		t.Error("expected encoding with an unknown schema to fail")
+	}
+	if _, _, err := repo.DecodeFramed(message[:framingHeaderLength-1]); !errors.Is(err, ErrInvalidFraming) {
+		t.Errorf("expected decoding a truncated message to fail with %v, got %v", ErrInvalidFraming, err)
+	}
+}
@@ -0,0 +1,678 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GoOptions configure the code generated by GenerateGo.
+type GoOptions struct {
+	// Package is the name of the generated package.
+	Package string
+	// Source names the schema the specification belongs to, e.g. its UUID or alias.
+	// It is only mentioned in the comments of the generated code.
+	Source string
+}
+
+// goInitialisms are spelled in upper case in Go identifiers.
+var goInitialisms = map[string]bool{"id": true, "uuid": true, "url": true, "uri": true, "http": true, "json": true, "api": true}
+
+// goNativeTypes are the Go types goavro uses for the primitive types.
+var goNativeTypes = map[string]string{
+	"boolean": "bool",
+	"int":     "int32",
+	"long":    "int64",
+	"float":   "float32",
+	"double":  "float64",
+	"bytes":   "[]byte",
+	"string":  "string",
+}
+
+// GenerateGo generates Go types for an Avro specification whose top-level type is a record, enum or fixed.
+//
+// Records become structs whose fields are tagged with their Avro names, enums become strings with a constant
+// for every symbol and fixed types become byte arrays. Arrays become slices and maps become maps with string keys.
+// Unions of null and a single other type become pointers, all other unions become structs with a pointer per branch.
+// The logical types date, timestamp-millis and timestamp-micros become time.Time, time-millis and time-micros
+// become time.Duration and decimal becomes *big.Rat.
+//
+// Every generated type has the methods AvroNative and FromAvroNative, which convert it to and from the native
+// form used by goavro, e.g. to encode it with Repo.Encode or to convert the result of Repo.Decode.
+// For the top-level type, Marshal<Type> and Unmarshal<Type> encode and decode Avro binary data
+// using a codec of the specification.
+// The names of all other package-level declarations start with the name of the top-level type, so that
+// the code of several specifications can be generated into the same package, as long as their named types differ.
+func GenerateGo(specification string, options GoOptions) ([]byte, error) {
+	root, err := parseAvroSchema(specification)
+	if err != nil {
+		return nil, err
+	}
+	if !root.isNamed() || goLogicalType(root) != "" {
+		return nil, fmt.Errorf("only records, enums and fixed types can be generated, got %v", root.typeName())
+	}
+
+	g := newGoGenerator(root)
+	g.prefix = unexportedIdentifier(g.names[root.Name])
+	g.require(root)
+	for i := 0; i < len(g.queue); i++ {
+		g.emit(g.queue[i])
+	}
+
+	source := "an Avro schema"
+	if options.Source != "" {
+		source = "Avro schema " + options.Source
+	}
+	name := g.names[root.Name]
+	literal := "`" + specification + "`"
+	if strings.Contains(specification, "`") {
+		literal = strconv.Quote(specification)
+	}
+	imports := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		imports = append(imports, strconv.Quote(path))
+	}
+	sort.Strings(imports)
+
+	out := strings.Builder{}
+	fmt.Fprintf(&out, "// Code generated from %s by kschema. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&out, "package %s\n\nimport (\n\t%s\n)\n\n", options.Package, strings.Join(imports, "\n\t"))
+	fmt.Fprintf(&out, "// %sSpecification is the specification of %s, which %s was generated from.\n", name, source, name)
+	fmt.Fprintf(&out, "const %sSpecification = %s\n\n", name, literal)
+	fmt.Fprintf(&out, "var %[1]sCodec, %[1]sCodecErr = goavro.NewCodec(%[2]sSpecification)\n\n", g.prefix, name)
+	fmt.Fprintf(&out, "// Marshal%s encodes its argument as an Avro binary datum of %sSpecification.\n", name, name)
+	fmt.Fprintf(&out, "func Marshal%s(value %s) ([]byte, error) {\n", name, name)
+	fmt.Fprintf(&out, "\tif %[1]sCodecErr != nil {\n\t\treturn nil, %[1]sCodecErr\n\t}\n", g.prefix)
+	fmt.Fprintf(&out, "\treturn %sCodec.BinaryFromNative(nil, value.AvroNative())\n}\n\n", g.prefix)
+	fmt.Fprintf(&out, "// Unmarshal%s decodes an Avro binary datum of %sSpecification.\n", name, name)
+	fmt.Fprintf(&out, "// Data written with other schemata can be decoded with a repo, e.g. using Repo.DecodeAs, and converted using FromAvroNative.\n")
+	fmt.Fprintf(&out, "func Unmarshal%s(datum []byte) (%s, error) {\n", name, name)
+	fmt.Fprintf(&out, "\tvar value %s\n", name)
+	fmt.Fprintf(&out, "\tif %[1]sCodecErr != nil {\n\t\treturn value, %[1]sCodecErr\n\t}\n", g.prefix)
+	fmt.Fprintf(&out, "\tnative, _, err := %sCodec.NativeFromBinary(datum)\n", g.prefix)
+	fmt.Fprintf(&out, "\tif err != nil {\n\t\treturn value, err\n\t}\n")
+	fmt.Fprintf(&out, "\terr = value.FromAvroNative(native)\n\treturn value, err\n}\n\n")
+	out.WriteString(g.code.String())
+
+	formatted, err := format.Source([]byte(out.String()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to format generated code: %w", err)
+	}
+	return formatted, nil
+}
+
+// goGenerator generates the Go code for the types of a single specification.
+// Every type is generated once, in the order in which the types are first required.
+type goGenerator struct {
+	// names are the Go names of the named types by their full names.
+	names map[string]string
+	// prefix is prepended to the names of package-level helpers, so that several specifications can be generated into one package.
+	prefix   string
+	required map[string]bool
+	queue    []*avroSchema
+	imports  map[string]bool
+	decimals bool
+	code     strings.Builder
+}
+
+func newGoGenerator(root *avroSchema) *goGenerator {
+	g := &goGenerator{
+		names:    make(map[string]string),
+		required: make(map[string]bool),
+		imports:  map[string]bool{"fmt": true, "github.com/linkedin/goavro": true},
+	}
+	named := make(map[string]*avroSchema)
+	collectNamed(root, named)
+	shortNames := make(map[string]int)
+	for fullName := range named {
+		shortNames[goIdentifier(shortName(fullName))]++
+	}
+	for fullName := range named {
+		// Named types of different namespaces may share a short name
+		if name := goIdentifier(shortName(fullName)); shortNames[name] == 1 {
+			g.names[fullName] = name
+		} else {
+			g.names[fullName] = goIdentifier(fullName)
+		}
+	}
+	return g
+}
+
+// collectNamed collects all named types of a schema by their full names.
+func collectNamed(schema *avroSchema, named map[string]*avroSchema) {
+	if schema.isNamed() {
+		if _, ok := named[schema.Name]; ok {
+			return
+		}
+		named[schema.Name] = schema
+	}
+	for _, field := range schema.Fields {
+		collectNamed(field.Type, named)
+	}
+	for _, branch := range schema.Branches {
+		collectNamed(branch, named)
+	}
+	if schema.Items != nil {
+		collectNamed(schema.Items, named)
+	}
+	if schema.Values != nil {
+		collectNamed(schema.Values, named)
+	}
+}
+
+// goIdentifier converts an Avro name into an exported Go identifier, e.g. order_id into OrderID.
+func goIdentifier(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	identifier := strings.Builder{}
+	for _, part := range parts {
+		if strings.ToUpper(part) == part {
+			part = strings.ToLower(part)
+		}
+		if goInitialisms[strings.ToLower(part)] {
+			identifier.WriteString(strings.ToUpper(part))
+			continue
+		}
+		identifier.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	if identifier.Len() == 0 || unicode.IsDigit(rune(identifier.String()[0])) {
+		return "X" + identifier.String()
+	}
+	return identifier.String()
+}
+
+// unexportedIdentifier converts an exported Go identifier into an unexported one, e.g. UUIDRecord into uuidRecord.
+func unexportedIdentifier(identifier string) string {
+	runes := []rune(identifier)
+	upper := 0
+	for upper < len(runes) && unicode.IsUpper(runes[upper]) {
+		upper++
+	}
+	// The last upper case letter of an initialism followed by a word starts that word
+	if upper > 1 && upper < len(runes) && unicode.IsLower(runes[upper]) {
+		upper--
+	}
+	if upper == 0 {
+		upper = 1
+	}
+	return strings.ToLower(string(runes[:upper])) + string(runes[upper:])
+}
+
+// uniqueIdentifier appends a number to an identifier which is already used.
+func uniqueIdentifier(identifier string, used map[string]bool) string {
+	unique := identifier
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", identifier, i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// goLogicalType returns the logical type of a schema, if it is one that is represented by a Go type of its own.
+// Logical types annotating the wrong underlying type are ignored, as required by the Avro specification.
+func goLogicalType(schema *avroSchema) string {
+	switch {
+	case schema.LogicalType == "date" && schema.Type == "int",
+		schema.LogicalType == "time-millis" && schema.Type == "int",
+		schema.LogicalType == "time-micros" && schema.Type == "long",
+		schema.LogicalType == "timestamp-millis" && schema.Type == "long",
+		schema.LogicalType == "timestamp-micros" && schema.Type == "long",
+		schema.LogicalType == "decimal" && (schema.Type == "bytes" || schema.Type == "fixed") && schema.Precision > 0:
+		return schema.LogicalType
+	}
+	return ""
+}
+
+// nullable returns the other branch of a union of null and a single other type.
+func nullable(schema *avroSchema) (*avroSchema, bool) {
+	if schema.Type != "union" || len(schema.Branches) != 2 {
+		return nil, false
+	}
+	if schema.Branches[0].Type == "null" {
+		return schema.Branches[1], true
+	}
+	if schema.Branches[1].Type == "null" {
+		return schema.Branches[0], true
+	}
+	return nil, false
+}
+
+// hasMethods indicates whether the Go type of a schema is a generated type with AvroNative and FromAvroNative methods.
+func hasMethods(schema *avroSchema) bool {
+	if schema.isNamed() {
+		return goLogicalType(schema) == ""
+	}
+	_, isNullable := nullable(schema)
+	return schema.Type == "union" && !isNullable
+}
+
+// id identifies the Go type of a schema within the names of generated types and helper functions.
+func (g *goGenerator) id(schema *avroSchema) string {
+	logicalType := goLogicalType(schema)
+	switch {
+	case logicalType == "decimal" && schema.Type == "bytes":
+		return fmt.Sprintf("DecimalScale%d", schema.Scale)
+	case logicalType != "" && !schema.isNamed():
+		return goIdentifier(logicalType)
+	case schema.isNamed():
+		return g.names[schema.Name]
+	case schema.Type == "array":
+		return "ArrayOf" + g.id(schema.Items)
+	case schema.Type == "map":
+		return "MapOf" + g.id(schema.Values)
+	case schema.Type == "union":
+		if other, ok := nullable(schema); ok {
+			return "Nullable" + g.id(other)
+		}
+		id := "Union"
+		for _, branch := range schema.Branches {
+			id += g.id(branch)
+		}
+		return id
+	default:
+		return goIdentifier(schema.Type)
+	}
+}
+
+// goType returns the Go type representing a schema.
+func (g *goGenerator) goType(schema *avroSchema) string {
+	switch goLogicalType(schema) {
+	case "date", "timestamp-millis", "timestamp-micros":
+		g.imports["time"] = true
+		return "time.Time"
+	case "time-millis", "time-micros":
+		g.imports["time"] = true
+		return "time.Duration"
+	case "decimal":
+		g.imports["math/big"] = true
+		return "*big.Rat"
+	}
+	switch schema.Type {
+	case "null":
+		return "struct{}"
+	case "array":
+		return "[]" + g.goType(schema.Items)
+	case "map":
+		return "map[string]" + g.goType(schema.Values)
+	case "union":
+		if other, ok := nullable(schema); ok {
+			return "*" + g.goType(other)
+		}
+		return g.id(schema)
+	}
+	if native, ok := goNativeTypes[schema.Type]; ok {
+		return native
+	}
+	return g.id(schema)
+}
+
+// require makes sure that the type and helper functions of a schema are generated.
+func (g *goGenerator) require(schema *avroSchema) {
+	id := g.id(schema)
+	if !g.required[id] {
+		g.required[id] = true
+		g.queue = append(g.queue, schema)
+	}
+}
+
+// toNative returns an expression converting the Go expression of a schema's type into its native form.
+func (g *goGenerator) toNative(schema *avroSchema, expression string) string {
+	if schema.Type == "null" {
+		return "nil"
+	}
+	if _, ok := goNativeTypes[schema.Type]; ok && goLogicalType(schema) == "" {
+		return expression
+	}
+	g.require(schema)
+	if hasMethods(schema) {
+		return expression + ".AvroNative()"
+	}
+	return fmt.Sprintf("%sToNative%s(%s)", g.prefix, g.id(schema), expression)
+}
+
+// fromNative returns an expression converting a native value into the Go type of a schema, along with an error.
+func (g *goGenerator) fromNative(schema *avroSchema, expression string) string {
+	g.require(schema)
+	return fmt.Sprintf("%sFromNative%s(%s)", g.prefix, g.id(schema), expression)
+}
+
+func (g *goGenerator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.code, format, args...)
+}
+
+// comment writes a comment consisting of the given text, followed by the documentation of the schema, if any.
+func (g *goGenerator) comment(indent string, text string, doc string) {
+	if doc != "" {
+		text += "\n" + doc
+	}
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		g.printf("%s// %s\n", indent, strings.TrimSpace(line))
+	}
+}
+
+// emit generates the type and helper functions of a schema.
+func (g *goGenerator) emit(schema *avroSchema) {
+	id, goType := g.id(schema), g.goType(schema)
+	_, isNullable := nullable(schema)
+	switch {
+	case goLogicalType(schema) != "":
+		g.emitLogical(schema)
+	case schema.Type == "record":
+		g.emitRecord(schema)
+	case schema.Type == "enum":
+		g.emitEnum(schema)
+	case schema.Type == "fixed":
+		g.emitFixed(schema)
+	case schema.Type == "array":
+		g.emitArray(schema)
+	case schema.Type == "map":
+		g.emitMap(schema)
+	case isNullable:
+		g.emitNullable(schema)
+	case schema.Type == "union":
+		g.emitUnion(schema)
+	case schema.Type == "null":
+		g.printf("func %sFromNativeNull(native interface{}) (struct{}, error) {\n", g.prefix)
+		g.printf("\tif native != nil {\n\t\treturn struct{}{}, fmt.Errorf(\"expected null, got %%T\", native)\n\t}\n")
+		g.printf("\treturn struct{}{}, nil\n}\n\n")
+	default:
+		g.printf("func %sFromNative%s(native interface{}) (%s, error) {\n", g.prefix, id, goType)
+		g.printf("\tvalue, ok := native.(%s)\n", goType)
+		g.printf("\tif !ok {\n\t\treturn value, fmt.Errorf(\"expected %s, got %%T\", native)\n\t}\n", schema.Type)
+		g.printf("\treturn value, nil\n}\n\n")
+	}
+	if hasMethods(schema) {
+		g.printf("func %sFromNative%s(native interface{}) (%s, error) {\n", g.prefix, id, goType)
+		g.printf("\tvar value %s\n\terr := value.FromAvroNative(native)\n\treturn value, err\n}\n\n", goType)
+	}
+}
+
+func (g *goGenerator) emitRecord(schema *avroSchema) {
+	name := g.names[schema.Name]
+	fields := make([]string, len(schema.Fields))
+	used := map[string]bool{"AvroNative": true, "FromAvroNative": true}
+	for i, field := range schema.Fields {
+		fields[i] = uniqueIdentifier(goIdentifier(field.Name), used)
+	}
+
+	g.comment("", fmt.Sprintf("%s is the Go representation of the Avro record %s.", name, schema.Name), schema.Doc)
+	g.printf("type %s struct {\n", name)
+	for i, field := range schema.Fields {
+		if field.Doc != "" {
+			g.comment("\t", field.Doc, "")
+		}
+		g.printf("\t%s %s `avro:%q`\n", fields[i], g.goType(field.Type), field.Name)
+	}
+	g.printf("}\n\n")
+
+	g.printf("// AvroNative converts the %s into the native form used by goavro.\n", name)
+	g.printf("func (r %s) AvroNative() interface{} {\n\treturn map[string]interface{}{\n", name)
+	for i, field := range schema.Fields {
+		g.printf("\t\t%q: %s,\n", field.Name, g.toNative(field.Type, "r."+fields[i]))
+	}
+	g.printf("\t}\n}\n\n")
+
+	g.printf("// FromAvroNative converts the native form used by goavro into the %s.\n", name)
+	g.printf("func (r *%s) FromAvroNative(native interface{}) error {\n", name)
+	if len(schema.Fields) == 0 {
+		g.printf("\tif _, ok := native.(map[string]interface{}); !ok {\n")
+	} else {
+		g.printf("\trecord, ok := native.(map[string]interface{})\n\tif !ok {\n")
+	}
+	g.printf("\t\treturn fmt.Errorf(\"expected record %s, got %%T\", native)\n\t}\n", schema.Name)
+	if len(schema.Fields) > 0 {
+		g.printf("\tvar err error\n")
+	}
+	for i, field := range schema.Fields {
+		g.printf("\tif r.%s, err = %s; err != nil {\n", fields[i], g.fromNative(field.Type, fmt.Sprintf("record[%q]", field.Name)))
+		g.printf("\t\treturn fmt.Errorf(\"field %s: %%w\", err)\n\t}\n", field.Name)
+	}
+	g.printf("\treturn nil\n}\n\n")
+}
+
+func (g *goGenerator) emitEnum(schema *avroSchema) {
+	name := g.names[schema.Name]
+	g.comment("", fmt.Sprintf("%s is the Go representation of the Avro enum %s.", name, schema.Name), schema.Doc)
+	g.printf("type %s string\n\n", name)
+	g.printf("// Symbols of %s.\nconst (\n", name)
+	used := make(map[string]bool)
+	for _, symbol := range schema.Symbols {
+		g.printf("\t%s %s = %q\n", uniqueIdentifier(name+goIdentifier(symbol), used), name, symbol)
+	}
+	g.printf(")\n\n")
+
+	g.printf("// AvroNative converts the %s into the native form used by goavro.\n", name)
+	g.printf("func (e %s) AvroNative() interface{} {\n\treturn string(e)\n}\n\n", name)
+	g.printf("// FromAvroNative converts the native form used by goavro into the %s.\n", name)
+	g.printf("func (e *%s) FromAvroNative(native interface{}) error {\n", name)
+	g.printf("\tsymbol, ok := native.(string)\n")
+	g.printf("\tif !ok {\n\t\treturn fmt.Errorf(\"expected enum %s, got %%T\", native)\n\t}\n", schema.Name)
+	g.printf("\t*e = %s(symbol)\n\treturn nil\n}\n\n", name)
+}
+
+func (g *goGenerator) emitFixed(schema *avroSchema) {
+	name := g.names[schema.Name]
+	g.comment("", fmt.Sprintf("%s is the Go representation of the Avro fixed type %s.", name, schema.Name), schema.Doc)
+	g.printf("type %s [%d]byte\n\n", name, schema.Size)
+
+	g.printf("// AvroNative converts the %s into the native form used by goavro.\n", name)
+	g.printf("func (f %s) AvroNative() interface{} {\n\treturn f[:]\n}\n\n", name)
+	g.printf("// FromAvroNative converts the native form used by goavro into the %s.\n", name)
+	g.printf("func (f *%s) FromAvroNative(native interface{}) error {\n", name)
+	g.printf("\tvalue, ok := native.([]byte)\n")
+	g.printf("\tif !ok || len(value) != %d {\n", schema.Size)
+	g.printf("\t\treturn fmt.Errorf(\"expected fixed %s of size %d, got %%T\", native)\n\t}\n", schema.Name, schema.Size)
+	g.printf("\tcopy(f[:], value)\n\treturn nil\n}\n\n")
+}
+
+func (g *goGenerator) emitArray(schema *avroSchema) {
+	id, goType := g.id(schema), g.goType(schema)
+	g.printf("func %sToNative%s(values %s) interface{} {\n", g.prefix, id, goType)
+	g.printf("\tnative := make([]interface{}, 0, len(values))\n")
+	g.printf("\tfor _, value := range values {\n\t\tnative = append(native, %s)\n\t}\n", g.toNative(schema.Items, "value"))
+	g.printf("\treturn native\n}\n\n")
+
+	g.printf("func %sFromNative%s(native interface{}) (%s, error) {\n", g.prefix, id, goType)
+	g.printf("\tarray, ok := native.([]interface{})\n")
+	g.printf("\tif !ok {\n\t\treturn nil, fmt.Errorf(\"expected array, got %%T\", native)\n\t}\n")
+	g.printf("\tvalues := make(%s, 0, len(array))\n", goType)
+	g.printf("\tfor i, item := range array {\n")
+	g.printf("\t\tvalue, err := %s\n", g.fromNative(schema.Items, "item"))
+	g.printf("\t\tif err != nil {\n\t\t\treturn nil, fmt.Errorf(\"item %%v: %%w\", i, err)\n\t\t}\n")
+	g.printf("\t\tvalues = append(values, value)\n\t}\n")
+	g.printf("\treturn values, nil\n}\n\n")
+}
+
+func (g *goGenerator) emitMap(schema *avroSchema) {
+	id, goType := g.id(schema), g.goType(schema)
+	g.printf("func %sToNative%s(values %s) interface{} {\n", g.prefix, id, goType)
+	g.printf("\tnative := make(map[string]interface{}, len(values))\n")
+	g.printf("\tfor key, value := range values {\n\t\tnative[key] = %s\n\t}\n", g.toNative(schema.Values, "value"))
+	g.printf("\treturn native\n}\n\n")
+
+	g.printf("func %sFromNative%s(native interface{}) (%s, error) {\n", g.prefix, id, goType)
+	g.printf("\tentries, ok := native.(map[string]interface{})\n")
+	g.printf("\tif !ok {\n\t\treturn nil, fmt.Errorf(\"expected map, got %%T\", native)\n\t}\n")
+	g.printf("\tvalues := make(%s, len(entries))\n", goType)
+	g.printf("\tfor key, entry := range entries {\n")
+	g.printf("\t\tvalue, err := %s\n", g.fromNative(schema.Values, "entry"))
+	g.printf("\t\tif err != nil {\n\t\t\treturn nil, fmt.Errorf(\"value %%v: %%w\", key, err)\n\t\t}\n")
+	g.printf("\t\tvalues[key] = value\n\t}\n")
+	g.printf("\treturn values, nil\n}\n\n")
+}
+
+func (g *goGenerator) emitNullable(schema *avroSchema) {
+	other, _ := nullable(schema)
+	id, goType := g.id(schema), g.goType(schema)
+	g.printf("func %sToNative%s(value %s) interface{} {\n", g.prefix, id, goType)
+	g.printf("\tif value == nil {\n\t\treturn nil\n\t}\n")
+	g.printf("\treturn goavro.Union(%q, %s)\n}\n\n", other.typeName(), g.toNative(other, "(*value)"))
+
+	g.printf("func %sFromNative%s(native interface{}) (%s, error) {\n", g.prefix, id, goType)
+	g.printf("\tif native == nil {\n\t\treturn nil, nil\n\t}\n")
+	g.printf("\tunion, ok := native.(map[string]interface{})\n")
+	g.printf("\tif !ok {\n\t\treturn nil, fmt.Errorf(\"expected union, got %%T\", native)\n\t}\n")
+	g.printf("\tvalue, err := %s\n", g.fromNative(other, fmt.Sprintf("union[%q]", other.typeName())))
+	g.printf("\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	g.printf("\treturn &value, nil\n}\n\n")
+}
+
+func (g *goGenerator) emitUnion(schema *avroSchema) {
+	name := g.id(schema)
+	branches := make([]*avroSchema, 0, len(schema.Branches))
+	names := make([]string, 0, len(schema.Branches))
+	hasNull := false
+	for _, branch := range schema.Branches {
+		if branch.Type == "null" {
+			hasNull = true
+			continue
+		}
+		branches = append(branches, branch)
+		names = append(names, branch.typeName())
+	}
+
+	g.printf("// %s is the Go representation of an Avro union of %s.\n", name, strings.Join(names, ", "))
+	if hasNull {
+		g.printf("// At most one of its fields is set, the union is null if none is set.\n")
+	} else {
+		g.printf("// Exactly one of its fields needs to be set.\n")
+	}
+	g.printf("type %s struct {\n", name)
+	for _, branch := range branches {
+		g.printf("\t%s *%s\n", g.id(branch), g.goType(branch))
+	}
+	g.printf("}\n\n")
+
+	g.printf("// AvroNative converts the %s into the native form used by goavro.\n", name)
+	g.printf("func (u %s) AvroNative() interface{} {\n\tswitch {\n", name)
+	for _, branch := range branches {
+		field := "u." + g.id(branch)
+		g.printf("\tcase %s != nil:\n\t\treturn goavro.Union(%q, %s)\n", field, branch.typeName(), g.toNative(branch, "(*"+field+")"))
+	}
+	g.printf("\t}\n\treturn nil\n}\n\n")
+
+	g.printf("// FromAvroNative converts the native form used by goavro into the %s.\n", name)
+	g.printf("func (u *%s) FromAvroNative(native interface{}) error {\n", name)
+	g.printf("\t*u = %s{}\n", name)
+	g.printf("\tif native == nil {\n\t\treturn nil\n\t}\n")
+	g.printf("\tunion, ok := native.(map[string]interface{})\n")
+	g.printf("\tif !ok {\n\t\treturn fmt.Errorf(\"expected union, got %%T\", native)\n\t}\n")
+	if len(branches) == 0 {
+		g.printf("\tfor name := range union {\n\t\tswitch name {\n")
+	} else {
+		g.printf("\tfor name, value := range union {\n\t\tswitch name {\n")
+	}
+	for _, branch := range branches {
+		g.printf("\t\tcase %q:\n", branch.typeName())
+		g.printf("\t\t\tbranch, err := %s\n", g.fromNative(branch, "value"))
+		g.printf("\t\t\tif err != nil {\n\t\t\t\treturn fmt.Errorf(\"branch %%v: %%w\", name, err)\n\t\t\t}\n")
+		g.printf("\t\t\tu.%s = &branch\n", g.id(branch))
+	}
+	g.printf("\t\tdefault:\n\t\t\treturn fmt.Errorf(\"unknown branch %%v\", name)\n\t\t}\n\t}\n")
+	g.printf("\treturn nil\n}\n\n")
+}
+
+func (g *goGenerator) emitLogical(schema *avroSchema) {
+	id, goType := g.id(schema), g.goType(schema)
+	g.printf("func %sToNative%s(value %s) interface{} {\n", g.prefix, id, goType)
+	switch schema.LogicalType {
+	case "date":
+		g.printf("\tseconds := value.Unix()\n\tdays := seconds / 86400\n")
+		g.printf("\tif seconds%%86400 < 0 {\n\t\tdays--\n\t}\n\treturn int32(days)\n")
+	case "time-millis":
+		g.printf("\treturn int32(value / time.Millisecond)\n")
+	case "time-micros":
+		g.printf("\treturn int64(value / time.Microsecond)\n")
+	case "timestamp-millis":
+		g.printf("\treturn value.Unix()*1000 + int64(value.Nanosecond())/int64(time.Millisecond)\n")
+	case "timestamp-micros":
+		g.printf("\treturn value.Unix()*1000000 + int64(value.Nanosecond())/int64(time.Microsecond)\n")
+	case "decimal":
+		g.printf("\treturn %sDecimalToNative(value, %d, %d)\n", g.prefix, schema.Scale, schema.Size)
+	}
+	g.printf("}\n\n")
+
+	g.printf("func %sFromNative%s(native interface{}) (%s, error) {\n", g.prefix, id, goType)
+	if schema.LogicalType == "decimal" {
+		g.printf("\treturn %sDecimalFromNative(native, %d)\n}\n\n", g.prefix, schema.Scale)
+		g.emitDecimalHelpers()
+		return
+	}
+	underlying := &avroSchema{Type: schema.Type}
+	g.printf("\tvalue, err := %s\n", g.fromNative(underlying, "native"))
+	zero := "0"
+	if goType == "time.Time" {
+		zero = "time.Time{}"
+	}
+	g.printf("\tif err != nil {\n\t\treturn %s, err\n\t}\n", zero)
+	switch schema.LogicalType {
+	case "date":
+		g.printf("\treturn time.Unix(int64(value)*86400, 0).UTC(), nil\n")
+	case "time-millis":
+		g.printf("\treturn time.Duration(value) * time.Millisecond, nil\n")
+	case "time-micros":
+		g.printf("\treturn time.Duration(value) * time.Microsecond, nil\n")
+	case "timestamp-millis":
+		g.printf("\treturn time.Unix(value/1000, value%%1000*int64(time.Millisecond)).UTC(), nil\n")
+	case "timestamp-micros":
+		g.printf("\treturn time.Unix(value/1000000, value%%1000000*int64(time.Microsecond)).UTC(), nil\n")
+	}
+	g.printf("}\n\n")
+}
+
+// emitDecimalHelpers generates the conversion of decimals, which is shared by all decimal types.
+func (g *goGenerator) emitDecimalHelpers() {
+	if g.decimals {
+		return
+	}
+	g.decimals = true
+	g.printf(`// %[1]sDecimalToNative encodes a decimal as the big-endian two's complement of its unscaled value.
+// Digits beyond the scale are truncated. Decimals of a fixed size are sign-extended to that size.
+func %[1]sDecimalToNative(value *big.Rat, scale int, size int) []byte {
+	unscaled := new(big.Int)
+	if value != nil {
+		scaled := new(big.Rat).Mul(value, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+		unscaled.Quo(scaled.Num(), scaled.Denom())
+	}
+	length := unscaled.BitLen()/8 + 1
+	if size > length {
+		length = size
+	}
+	twos := unscaled
+	if unscaled.Sign() < 0 {
+		twos = new(big.Int).Add(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*length)))
+	}
+	encoded := make([]byte, length)
+	magnitude := twos.Bytes()
+	copy(encoded[length-len(magnitude):], magnitude)
+	return encoded
+}
+
+// %[1]sDecimalFromNative decodes a decimal from the big-endian two's complement of its unscaled value.
+func %[1]sDecimalFromNative(native interface{}, scale int) (*big.Rat, error) {
+	encoded, ok := native.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("expected decimal, got %%T", native)
+	}
+	unscaled := new(big.Int).SetBytes(encoded)
+	if len(encoded) > 0 && encoded[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(encoded))))
+	}
+	return new(big.Rat).SetFrac(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)), nil
+}
+
+`, g.prefix)
+}
@@ -0,0 +1,206 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const orderSpecification = `{"type": "record", "name": "Order", "namespace": "com.example", "fields": [
+	{"name": "order_id", "type": "string"},
+	{"name": "total", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+	{"name": "placed", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+	{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["PLACED", "SHIPPED_OUT"]}},
+	{"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 16}},
+	{"name": "note", "type": ["null", "string"]},
+	{"name": "items", "type": {"type": "array", "items": {"type": "record", "name": "Item", "fields": [{"name": "sku", "type": "string"}]}}},
+	{"name": "labels", "type": {"type": "map", "values": ["long", "Item"]}},
+	{"name": "next", "type": ["null", "Order"]}
+]}`
+
+// TestGenerateGo generates Go code for a schema using all kinds of types and checks the generated declarations.
+func TestGenerateGo(t *testing.T) {
+	code, err := GenerateGo(orderSpecification, GoOptions{Package: "orders", Source: "orders:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "orders.go", code, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name.Name != "orders" {
+		t.Errorf("expected package orders, got %v", file.Name.Name)
+	}
+
+	declared := make(map[string]string)
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			name := d.Name.Name
+			if d.Recv != nil {
+				name = string(code[d.Recv.List[0].Type.Pos()-1:d.Recv.List[0].Type.End()-1]) + "." + name
+			}
+			declared[name] = "func"
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					declared[s.Name.Name] = string(code[s.Type.Pos()-1 : s.Type.End()-1])
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						declared[name.Name] = "value"
+					}
+				}
+			}
+		}
+	}
+
+	expected := []string{
+		"OrderSpecification", "MarshalOrder", "UnmarshalOrder", "Order.AvroNative", "*Order.FromAvroNative",
+		"Item", "StatusShippedOut", "*Hash.FromAvroNative", "UnionLongItem", "orderToNativeNullableOrder", "orderCodec",
+	}
+	for _, name := range expected {
+		if _, ok := declared[name]; !ok {
+			t.Errorf("expected %v to be declared", name)
+		}
+	}
+	if declared["Hash"] != "[16]byte" {
+		t.Errorf("expected Hash to be a [16]byte, got %v", declared["Hash"])
+	}
+	for _, field := range []string{"OrderID string `avro:\"order_id\"`", "Total *big.Rat", "Placed time.Time", "Note *string", "Items []Item", "Labels map[string]UnionLongItem", "Next *Order"} {
+		if !strings.Contains(strings.Join(strings.Fields(declared["Order"]), " "), field) {
+			t.Errorf("expected Order to have the field %v, got %v", field, declared["Order"])
+		}
+	}
+
+	if _, err := GenerateGo(`{"type": "array", "items": "string"}`, GoOptions{Package: "orders"}); err == nil {
+		t.Error("expected generating code for an unnamed top-level type to fail")
+	}
+}
+
+const invoiceSpecification = `{"type": "record", "name": "Invoice", "fields": [
+	{"name": "invoice_id", "type": "string"},
+	{"name": "amount", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+	{"name": "issued", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+	{"name": "note", "type": ["null", "string"]},
+	{"name": "lines", "type": {"type": "array", "items": "string"}}
+]}`
+
+// roundTripMain marshals and unmarshals values of the types generated for orderSpecification and invoiceSpecification.
+const roundTripMain = `package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"time"
+)
+
+func main() {
+	note := "fragile"
+	placed := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	order := Order{
+		OrderID: "o-1",
+		Total:   big.NewRat(-1999, 100),
+		Placed:  placed,
+		Status:  StatusShippedOut,
+		Hash:    Hash{1, 2, 3},
+		Note:    &note,
+		Items:   []Item{{Sku: "s-1"}},
+		Labels:  map[string]UnionLongItem{"l": {Item: &Item{Sku: "s-2"}}},
+		Next:    &Order{OrderID: "o-2", Total: big.NewRat(1, 1), Placed: placed, Status: StatusPlaced, Items: []Item{}, Labels: map[string]UnionLongItem{}},
+	}
+	invoice := Invoice{InvoiceID: "i-1", Amount: big.NewRat(5, 4), Issued: placed, Lines: []string{"a", "b"}}
+
+	datum, err := MarshalOrder(order)
+	if err != nil {
+		fail(err)
+	}
+	decodedOrder, err := UnmarshalOrder(datum)
+	if err != nil {
+		fail(err)
+	}
+	if decodedOrder.Total.Cmp(order.Total) != 0 || decodedOrder.Next.Total.Cmp(order.Next.Total) != 0 {
+		fail(fmt.Errorf("expected the totals %v and %v, got %v and %v", order.Total, order.Next.Total, decodedOrder.Total, decodedOrder.Next.Total))
+	}
+	order.Total, order.Next.Total, decodedOrder.Total, decodedOrder.Next.Total = nil, nil, nil, nil
+	if !reflect.DeepEqual(decodedOrder, order) {
+		fail(fmt.Errorf("expected %#v, got %#v", order, decodedOrder))
+	}
+
+	datum, err = MarshalInvoice(invoice)
+	if err != nil {
+		fail(err)
+	}
+	decodedInvoice, err := UnmarshalInvoice(datum)
+	if err != nil {
+		fail(err)
+	}
+	if decodedInvoice.Amount.Cmp(invoice.Amount) != 0 {
+		fail(fmt.Errorf("expected the amount %v, got %v", invoice.Amount, decodedInvoice.Amount))
+	}
+	invoice.Amount, decodedInvoice.Amount = nil, nil
+	if !reflect.DeepEqual(decodedInvoice, invoice) {
+		fail(fmt.Errorf("expected %#v, got %#v", invoice, decodedInvoice))
+	}
+}
+
+func fail(err error) {
+	fmt.Println(err)
+	os.Exit(1)
+}
+`
+
+// TestGenerateGoCompiles generates two schemata sharing helper types into the same package,
+// then compiles and runs a program which round-trips values of both through Marshal and Unmarshal.
+func TestGenerateGoCompiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiling generated code is slow")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is not available")
+	}
+	// The package needs to be part of this module to build with its dependencies
+	dir, err := ioutil.TempDir(".", "gogen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{"main.go": roundTripMain}
+	for name, specification := range map[string]string{"order.go": orderSpecification, "invoice.go": invoiceSpecification} {
+		code, err := GenerateGo(specification, GoOptions{Package: "main"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(code)
+	}
+	for name, code := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := exec.Command(goTool, "run", ".")
+	run.Dir = dir
+	if output, err := run.CombinedOutput(); err != nil {
+		t.Errorf("unable to run the generated code: %v\n%s", err, output)
+	}
+}
@@ -0,0 +1,271 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/linkedin/goavro"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// avroTag is the parsed avro tag of a struct field, e.g. `avro:"total,precision=10,scale=2"`.
+type avroTag struct {
+	name      string
+	skip      bool
+	precision int
+	scale     int
+	logical   string
+}
+
+func parseAvroTag(tag string) (avroTag, error) {
+	parts := strings.Split(tag, ",")
+	parsed := avroTag{name: parts[0], skip: parts[0] == "-"}
+	for _, option := range parts[1:] {
+		key := strings.SplitN(option, "=", 2)
+		if len(key) != 2 {
+			return parsed, fmt.Errorf("invalid avro tag option %q", option)
+		}
+		var err error
+		switch key[0] {
+		case "precision":
+			parsed.precision, err = strconv.Atoi(key[1])
+		case "scale":
+			parsed.scale, err = strconv.Atoi(key[1])
+		case "logical":
+			parsed.logical = key[1]
+		default:
+			err = fmt.Errorf("unknown avro tag option %q", key[0])
+		}
+		if err != nil {
+			return parsed, err
+		}
+	}
+	return parsed, nil
+}
+
+// inferredRecord, inferredField and inferredType are the JSON form of inferred schemata.
+// They are structs rather than maps, so that the attributes are marshalled in the usual order.
+type inferredRecord struct {
+	Type      string          `json:"type"`
+	Name      string          `json:"name"`
+	Namespace string          `json:"namespace,omitempty"`
+	Fields    []inferredField `json:"fields"`
+}
+
+type inferredField struct {
+	Name    string          `json:"name"`
+	Type    interface{}     `json:"type"`
+	Default json.RawMessage `json:"default,omitempty"`
+}
+
+type inferredType struct {
+	Type        string      `json:"type"`
+	Name        string      `json:"name,omitempty"`
+	Items       interface{} `json:"items,omitempty"`
+	Values      interface{} `json:"values,omitempty"`
+	Size        int         `json:"size,omitempty"`
+	LogicalType string      `json:"logicalType,omitempty"`
+	Precision   int         `json:"precision,omitempty"`
+	Scale       int         `json:"scale,omitempty"`
+}
+
+// InferSchema derives an Avro record schema from a Go struct, or a pointer to one, in the given namespace.
+// The schema describes the struct such that EncodeStruct and DecodeInto convert it without losing information.
+//
+// Records are named after their Go types, anonymous structs after the field they are declared by.
+// Exported fields become record fields named after their avro tag, e.g. `avro:"order_id"`, or their Go name.
+// Fields tagged with `avro:"-"` are skipped.
+// Pointers become unions of null and the type they point to, which default to null.
+// Slices and arrays become arrays, maps with string keys become maps, []byte becomes bytes and
+// byte arrays become fixed types. Integers become int or long, depending on their size.
+// time.Time becomes timestamp-millis and time.Duration time-micros, other logical types can be chosen with
+// the tag option logical, e.g. `avro:"due,logical=date"`. uuid.UUID becomes a string with the logical type uuid.
+// big.Rat, or a pointer to it, becomes a decimal, whose precision and scale need to be given with the tag options
+// precision and scale, e.g. `avro:"total,precision=10,scale=2"`.
+func InferSchema(v interface{}, namespace string) (string, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return "", fmt.Errorf("cannot infer a record schema from %T, need a struct", v)
+	}
+
+	inferrer := schemaInferrer{defined: make(map[reflect.Type]string), names: make(map[string]reflect.Type)}
+	root, err := inferrer.infer(t, t.Name(), avroTag{}, t.Name())
+	if err != nil {
+		return "", err
+	}
+	record := root.(inferredRecord)
+	record.Namespace = namespace
+
+	specification, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	if _, err := goavro.NewCodec(string(specification)); err != nil {
+		return "", fmt.Errorf("inferred an invalid schema: %w", err)
+	}
+	return string(specification), nil
+}
+
+// schemaInferrer keeps track of the named types defined while inferring a schema.
+type schemaInferrer struct {
+	defined map[reflect.Type]string
+	names   map[string]reflect.Type
+}
+
+// define registers the name of a named type. It returns false, if the type has been defined before.
+func (i schemaInferrer) define(t reflect.Type, name string, path string) (bool, error) {
+	if _, ok := i.defined[t]; ok {
+		return false, nil
+	}
+	if name == "" {
+		return false, fmt.Errorf("%v: cannot name %v", path, t)
+	}
+	if other, ok := i.names[name]; ok {
+		return false, fmt.Errorf("%v: %v and %v would both be named %v", path, t, other, name)
+	}
+	i.defined[t] = name
+	i.names[name] = t
+	return true, nil
+}
+
+// infer derives the schema of a Go type. Anonymous named types are given the fallback name.
+func (i schemaInferrer) infer(t reflect.Type, fallback string, tag avroTag, path string) (interface{}, error) {
+	switch t {
+	case timeType:
+		switch tag.logical {
+		case "":
+			return inferredType{Type: "long", LogicalType: "timestamp-millis"}, nil
+		case "date":
+			return inferredType{Type: "int", LogicalType: tag.logical}, nil
+		case "timestamp-millis", "timestamp-micros":
+			return inferredType{Type: "long", LogicalType: tag.logical}, nil
+		}
+		return nil, fmt.Errorf("%v: time.Time cannot be %v", path, tag.logical)
+	case durationType:
+		switch tag.logical {
+		case "", "time-micros":
+			return inferredType{Type: "long", LogicalType: "time-micros"}, nil
+		case "time-millis":
+			return inferredType{Type: "int", LogicalType: tag.logical}, nil
+		}
+		return nil, fmt.Errorf("%v: time.Duration cannot be %v", path, tag.logical)
+	case ratType, reflect.PtrTo(ratType):
+		if tag.precision <= 0 {
+			return nil, fmt.Errorf("%v: decimals need a precision, e.g. `avro:\"name,precision=10,scale=2\"`", path)
+		}
+		return inferredType{Type: "bytes", LogicalType: "decimal", Precision: tag.precision, Scale: tag.scale}, nil
+	case uuidType:
+		return inferredType{Type: "string", LogicalType: "uuid"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return "int", nil
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "long", nil
+	case reflect.Float32:
+		return "float", nil
+	case reflect.Float64:
+		return "double", nil
+	case reflect.String:
+		return "string", nil
+	case reflect.Ptr:
+		elem, err := i.infer(t.Elem(), fallback, tag, path)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"null", elem}, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes", nil
+		}
+		items, err := i.infer(t.Elem(), fallback, avroTag{}, path+"[]")
+		if err != nil {
+			return nil, err
+		}
+		return inferredType{Type: "array", Items: items}, nil
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			name := namedOr(t, fallback)
+			if isNew, err := i.define(t, name, path); err != nil || !isNew {
+				return i.defined[t], err
+			}
+			return inferredType{Type: "fixed", Name: name, Size: t.Len()}, nil
+		}
+		items, err := i.infer(t.Elem(), fallback, avroTag{}, path+"[]")
+		if err != nil {
+			return nil, err
+		}
+		return inferredType{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("%v: map keys need to be strings, got %v", path, t.Key())
+		}
+		values, err := i.infer(t.Elem(), fallback, avroTag{}, path+"[]")
+		if err != nil {
+			return nil, err
+		}
+		return inferredType{Type: "map", Values: values}, nil
+	case reflect.Struct:
+		return i.inferRecord(t, fallback, path)
+	}
+	return nil, fmt.Errorf("%v: cannot infer a schema from %v", path, t)
+}
+
+func (i schemaInferrer) inferRecord(t reflect.Type, fallback string, path string) (interface{}, error) {
+	name := namedOr(t, fallback)
+	if isNew, err := i.define(t, name, path); err != nil || !isNew {
+		return i.defined[t], err
+	}
+	record := inferredRecord{Type: "record", Name: name, Fields: make([]inferredField, 0, t.NumField())}
+	for n := 0; n < t.NumField(); n++ {
+		structField := t.Field(n)
+		if structField.PkgPath != "" {
+			continue
+		}
+		tag, err := parseAvroTag(structField.Tag.Get("avro"))
+		if err != nil {
+			return nil, fmt.Errorf("%v.%v: %w", path, structField.Name, err)
+		}
+		if tag.skip {
+			continue
+		}
+		field := inferredField{Name: tag.name}
+		if field.Name == "" {
+			field.Name = structField.Name
+		}
+		fieldPath := path + "." + field.Name
+		field.Type, err = i.infer(structField.Type, goIdentifier(name+"_"+structField.Name), tag, fieldPath)
+		if err != nil {
+			return nil, err
+		}
+		if _, isUnion := field.Type.([]interface{}); isUnion {
+			field.Default = json.RawMessage("null")
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	return record, nil
+}
+
+// namedOr returns the name of a named Go type, or the fallback for anonymous types.
+func namedOr(t reflect.Type, fallback string) string {
+	if t.Name() != "" {
+		return t.Name()
+	}
+	return fallback
+}
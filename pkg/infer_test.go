@@ -0,0 +1,117 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"github.com/google/uuid"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type inferredShipment struct {
+	ID       uuid.UUID `avro:"id"`
+	Weight   float64
+	Parcels  int8
+	Total    *big.Rat  `avro:"total,precision=10,scale=2"`
+	Due      time.Time `avro:"due,logical=date"`
+	Window   time.Duration
+	Checksum [4]byte
+	Tags     map[string]string
+	Items    []struct {
+		SKU string `avro:"sku"`
+	} `avro:"items"`
+	Next    *inferredShipment `avro:"next"`
+	Skipped chan bool         `avro:"-"`
+	hidden  string
+}
+
+// TestInferSchema infers a schema from a struct and checks that the struct survives being encoded with it.
+func TestInferSchema(t *testing.T) {
+	specification, err := InferSchema(&inferredShipment{}, "com.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"name":"inferredShipment","namespace":"com.example"`,
+		`{"name":"id","type":{"type":"string","logicalType":"uuid"}}`,
+		`{"name":"Parcels","type":"int"}`,
+		`{"type":"bytes","logicalType":"decimal","precision":10,"scale":2}`,
+		`{"name":"due","type":{"type":"int","logicalType":"date"}}`,
+		`{"type":"fixed","name":"InferredShipmentChecksum","size":4}`,
+		`{"type":"array","items":{"type":"record","name":"InferredShipmentItems"`,
+		`{"name":"next","type":["null","inferredShipment"],"default":null}`,
+	} {
+		if !strings.Contains(specification, expected) {
+			t.Errorf("expected the specification to contain %v, got %v", expected, specification)
+		}
+	}
+	if strings.Contains(specification, "Skipped") || strings.Contains(specification, "hidden") {
+		t.Errorf("expected skipped and unexported fields to be left out, got %v", specification)
+	}
+
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	schemaUUID := uuid.New()
+	ready := repo.WaitSchemaReady(schemaUUID)
+	if err := updater.UpdateSchema(schemaUUID, specification); err != nil {
+		t.Fatal(err)
+	}
+	<-ready
+
+	shipment := inferredShipment{
+		ID:       uuid.New(),
+		Weight:   2.5,
+		Parcels:  3,
+		Total:    big.NewRat(1999, 100),
+		Due:      time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC),
+		Window:   90 * time.Minute,
+		Checksum: [4]byte{1, 2, 3, 4},
+		Tags:     map[string]string{"priority": "high"},
+		Next:     &inferredShipment{Total: big.NewRat(0, 1), Due: time.Unix(0, 0).UTC(), Tags: map[string]string{}},
+	}
+	shipment.Items = append(shipment.Items, struct {
+		SKU string `avro:"sku"`
+	}{SKU: "x"})
+	// Empty arrays are decoded into empty slices rather than nil slices
+	shipment.Next.Items = shipment.Items[:0]
+	datum, err := repo.EncodeStruct(schemaUUID, shipment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded inferredShipment
+	if err := repo.DecodeInto(schemaUUID, datum, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Total.Cmp(shipment.Total) != 0 {
+		t.Errorf("expected the total %v, got %v", shipment.Total, decoded.Total)
+	}
+	shipment.Total, decoded.Total, shipment.Next.Total, decoded.Next.Total = nil, nil, nil, nil
+	if !reflect.DeepEqual(shipment, decoded) {
+		t.Errorf("expected %+v, got %+v", shipment, decoded)
+	}
+
+	if _, err := InferSchema(struct{ Total big.Rat }{}, ""); err == nil {
+		t.Error("expected inferring a decimal without a precision to fail")
+	}
+	if _, err := InferSchema("not a struct", ""); err == nil {
+		t.Error("expected inferring a schema from a string to fail")
+	}
+}
@@ -0,0 +1,102 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"sort"
+)
+
+// detectedFramings are the framings tried by Inspect, in order. The headers of the framed messages are tried first,
+// since raw data could be decoded by the wrong schema without any error.
+var detectedFramings = []Framing{FramingSingleObject, FramingUUID, FramingConfluent, FramingRaw}
+
+// Inspect decodes a message for debugging purposes, e.g. when a consumer fails to decode it.
+// Raw messages are decoded with the schema raw. If it is given and framing is empty, the message is known to be raw.
+// Otherwise, if framing is empty, the framing is detected from the message's header. A header is only recognized if
+// the repo knows the writer schema it identifies, so that raw data which happens to start like a header is decoded
+// as raw data.
+// The datum is returned in the Avro JSON encoding, together with the framing, the writer schema and its versions.
+func (repo LocalRepo) Inspect(message []byte, framing Framing, raw uuid.UUID) (InspectedDTO, error) {
+	framings := detectedFramings
+	switch {
+	case framing != "":
+		framings = []Framing{framing}
+	case raw != uuid.Nil:
+		// Raw data may start like a header by chance, it would be decoded with the wrong schema
+		framing = FramingRaw
+		framings = []Framing{framing}
+	}
+	for _, f := range framings {
+		writer, datum, ok := repo.unframe(message, f, raw)
+		if !ok {
+			continue
+		}
+		textual, err := repo.DecodeJSON(writer, datum)
+		if err != nil {
+			return InspectedDTO{}, fmt.Errorf("unable to decode %v message with schema %v: %w", f, writer, err)
+		}
+		return InspectedDTO{Framing: f, UUID: writer, Versions: repo.versionsOf(writer), Datum: textual}, nil
+	}
+	if framing != "" {
+		return InspectedDTO{}, fmt.Errorf("%w: not a %v message written with a known schema", ErrInvalidFraming, framing)
+	}
+	return InspectedDTO{}, fmt.Errorf("%w: no header identifies a known schema, the schema of raw messages needs to be given", ErrInvalidFraming)
+}
+
+// unframe splits a message in the given framing into the writer schema and the Avro binary datum.
+// It returns false, if the message is not in the framing or the writer schema is unknown.
+func (repo LocalRepo) unframe(message []byte, framing Framing, raw uuid.UUID) (uuid.UUID, []byte, bool) {
+	writer, datum, ok := uuid.Nil, message, false
+	switch framing {
+	case FramingRaw:
+		writer = raw
+		_, ok = repo.GetSpecification(writer)
+	case FramingUUID:
+		var err error
+		writer, datum, err = UnframeUUID(message)
+		if err == nil {
+			_, ok = repo.GetSpecification(writer)
+		}
+	case FramingSingleObject:
+		fingerprint, unframed, err := UnframeSingleObject(message)
+		if err == nil {
+			writer, ok = repo.Schemata.WhoHas(fingerprint)
+			datum = unframed
+		}
+	case FramingConfluent:
+		id, unframed, err := UnframeID(message)
+		if err == nil {
+			writer, ok = repo.WhoHasID(id)
+			datum = unframed
+		}
+	}
+	return writer, datum, ok
+}
+
+// versionsOf returns the versions pointing at a schema, ordered by name and version.
+func (repo LocalRepo) versionsOf(schemaUUID uuid.UUID) []SubjectVersionDTO {
+	versions := make([]SubjectVersionDTO, 0)
+	for _, alias := range repo.Aliases.AliasesOf(schemaUUID) {
+		version, err := VersionFromAlias(alias)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, SubjectVersionDTO{Name: version.Name, Version: version.Version, Alias: alias, UUID: schemaUUID})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Name != versions[j].Name {
+			return versions[i].Name < versions[j].Name
+		}
+		return versions[i].Version < versions[j].Version
+	})
+	return versions
+}
@@ -10,25 +10,39 @@
 package kafka_schema
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/google/uuid"
 	"github.com/linkedin/goavro"
 	"github.com/strangedev/catchall"
 	core "github.com/strangedev/kafka-golang/pkg"
-	"log"
 )
 
-// LocalRepo is a local Kafka consumer that implements the various schema.*Repo interfaces.
+// LocalRepo is a local consumer of a SchemaLogReader that implements the various schema.*Repo interfaces.
+// Every event overwrites or deletes the entry of its key, so the repo only relies on the latest event of each key
+// and may consume compacted topics, see TopicConfig.
 type LocalRepo struct {
-	Schemata SchemaMap
-	Aliases  AliasMap
-	core.TopicRouter
+	Schemata        *SchemaMap
+	Aliases         *AliasMap
+	Versions        *VersionMap
+	Compatibilities *CompatibilityMap
+	// Violations are the versioned aliases which have been published in violation of
+	// the compatibility level of their name, e.g. by an Updater that does not check compatibility.
+	Violations *ViolationMap
+	// Offsets are the offsets at which the repo continues consuming, as they are written into snapshots.
+	Offsets *OffsetMap
+	// Topics are the topics the repo consumes.
+	Topics    TopicNames
+	Logger    Logger
+	readiness *readiness
+	SchemaLogReader
 }
 
 func (repo LocalRepo) Decode(schema uuid.UUID, datum []byte) (interface{}, error) {
-	codec, ok := repo.Schemata.Map[schema]
+	codec, ok := repo.Schemata.Get(schema)
 	if !ok {
 		return nil, errors.New("schema not present")
 	}
@@ -36,66 +50,170 @@ func (repo LocalRepo) Decode(schema uuid.UUID, datum []byte) (interface{}, error
 	return native, err
 }
 
+func (repo LocalRepo) DecodeAs(writer uuid.UUID, reader uuid.UUID, datum []byte) (interface{}, error) {
+	decoded, err := repo.Decode(writer, datum)
+	if err != nil {
+		return nil, err
+	}
+	writerSchema, ok := repo.Schemata.parsedSchema(writer)
+	if !ok {
+		return nil, errors.New("writer schema not present")
+	}
+	readerSchema, ok := repo.Schemata.parsedSchema(reader)
+	if !ok {
+		return nil, errors.New("reader schema not present")
+	}
+	return resolveNative(readerSchema, writerSchema, decoded)
+}
+
 func (repo LocalRepo) Encode(schema uuid.UUID, datum interface{}) ([]byte, error) {
-	codec, ok := repo.Schemata.Map[schema]
+	codec, ok := repo.Schemata.Get(schema)
 	if !ok {
 		return nil, errors.New("schema not present")
 	}
+	if repo.Schemata.IsDeleted(schema) {
+		return nil, errors.New("schema has been deleted")
+	}
 	binary, err := codec.BinaryFromNative(nil, datum)
 	return binary, err
 }
 
-func (repo LocalRepo) WaitSchemaReady(schema uuid.UUID) chan bool {
-	_, ok := repo.GetSpecification(schema)
+func (repo LocalRepo) DecodeJSON(schema uuid.UUID, datum []byte) ([]byte, error) {
+	codec, ok := repo.Schemata.Get(schema)
 	if !ok {
-		return repo.Schemata.Observe(schema)
+		return nil, errors.New("schema not present")
 	}
-	ready := make(chan bool)
-	go (func() {
-		ready <- true
-	})()
-	return ready
+	native, _, err := codec.NativeFromBinary(datum)
+	if err != nil {
+		return nil, err
+	}
+	return codec.TextualFromNative(nil, native)
 }
 
-func (repo LocalRepo) WaitAliasReady(alias Alias) chan bool {
-	schemaUUID, ok := repo.WhoIs(alias)
+func (repo LocalRepo) EncodeJSON(schema uuid.UUID, datum []byte) ([]byte, error) {
+	codec, ok := repo.Schemata.Get(schema)
 	if !ok {
-		aliasIsReady := make(chan bool)
-		go (func() {
-			<-repo.Aliases.Observe(alias)
-			schemaUUID, _ := repo.Aliases.Map[alias]
-			<-repo.WaitSchemaReady(schemaUUID)
-			aliasIsReady <- true
-		})()
-		return aliasIsReady
+		return nil, errors.New("schema not present")
 	}
+	native, _, err := codec.NativeFromTextual(datum)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Encode(schema, native)
+}
 
-	return repo.WaitSchemaReady(schemaUUID)
+func (repo LocalRepo) EncodeFramed(schema uuid.UUID, datum interface{}) ([]byte, error) {
+	binary, err := repo.Encode(schema, datum)
+	if err != nil {
+		return nil, err
+	}
+	return FrameUUID(schema, binary), nil
 }
 
-func (repo LocalRepo) ListSchemata() []uuid.UUID {
-	schemata := make([]uuid.UUID, 0, len(repo.Schemata.Map))
-	for schemaUUID := range repo.Schemata.Map {
-		schemata = append(schemata, schemaUUID)
+func (repo LocalRepo) DecodeFramed(message []byte) (interface{}, uuid.UUID, error) {
+	ctx, cancel := repo.untilCaughtUp()
+	defer cancel()
+	return repo.DecodeFramedContext(ctx, message)
+}
+
+func (repo LocalRepo) DecodeFramedContext(ctx context.Context, message []byte) (interface{}, uuid.UUID, error) {
+	schemaUUID, datum, err := UnframeUUID(message)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	if err := repo.AwaitSchema(ctx, schemaUUID); err != nil {
+		return nil, schemaUUID, fmt.Errorf("writer schema %v not present: %w", schemaUUID, err)
 	}
-	return schemata
+	decoded, err := repo.Decode(schemaUUID, datum)
+	return decoded, schemaUUID, err
 }
 
-func (repo LocalRepo) ListAliases() []Alias {
-	aliases := make([]Alias, 0, len(repo.Aliases.Map))
-	for alias := range repo.Aliases.Map {
-		aliases = append(aliases, alias)
+func (repo LocalRepo) EncodeSingleObject(schema uuid.UUID, datum interface{}) ([]byte, error) {
+	fingerprint, ok := repo.Schemata.Fingerprint(schema)
+	if !ok {
+		return nil, errors.New("schema not present")
 	}
-	return aliases
+	binary, err := repo.Encode(schema, datum)
+	if err != nil {
+		return nil, err
+	}
+	return FrameSingleObject(fingerprint, binary), nil
+}
+
+func (repo LocalRepo) DecodeSingleObject(message []byte) (interface{}, uuid.UUID, error) {
+	fingerprint, datum, err := UnframeSingleObject(message)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	schemaUUID, ok := repo.Schemata.WhoHas(fingerprint)
+	if !ok {
+		return nil, uuid.Nil, fmt.Errorf("no schema with fingerprint %016x present", fingerprint)
+	}
+	decoded, err := repo.Decode(schemaUUID, datum)
+	return decoded, schemaUUID, err
+}
+
+func (repo LocalRepo) EncodeCompact(schema uuid.UUID, datum interface{}) ([]byte, error) {
+	id, ok := repo.SchemaID(schema)
+	if !ok {
+		return nil, errors.New("schema not present")
+	}
+	binary, err := repo.Encode(schema, datum)
+	if err != nil {
+		return nil, err
+	}
+	return FrameID(id, binary), nil
+}
+
+func (repo LocalRepo) DecodeCompact(message []byte) (interface{}, uuid.UUID, error) {
+	ctx, cancel := repo.untilCaughtUp()
+	defer cancel()
+	return repo.DecodeCompactContext(ctx, message)
+}
+
+func (repo LocalRepo) DecodeCompactContext(ctx context.Context, message []byte) (interface{}, uuid.UUID, error) {
+	id, datum, err := UnframeID(message)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	var schemaUUID uuid.UUID
+	err = await(ctx, &repo.Schemata.keyObservable, idKey(id), func() (ok bool) {
+		schemaUUID, ok = repo.WhoHasID(id)
+		return ok
+	})
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("writer schema with ID %v not present: %w", id, err)
+	}
+	decoded, err := repo.Decode(schemaUUID, datum)
+	return decoded, schemaUUID, err
+}
+
+func (repo LocalRepo) WaitSchemaReady(schema uuid.UUID) chan bool {
+	return awaitReady(func(ctx context.Context) error {
+		return repo.AwaitSchema(ctx, schema)
+	})
+}
+
+func (repo LocalRepo) WaitAliasReady(alias Alias) chan bool {
+	return awaitReady(func(ctx context.Context) error {
+		return repo.AwaitAlias(ctx, alias)
+	})
+}
+
+func (repo LocalRepo) ListSchemata() []uuid.UUID {
+	return repo.Schemata.Keys()
+}
+
+func (repo LocalRepo) ListAliases() []Alias {
+	return repo.Aliases.Keys()
 }
 
 func (repo LocalRepo) WhoIs(alias Alias) (uuid.UUID, bool) {
-	value, ok := repo.Aliases.Map[alias]
-	return value, ok
+	return repo.Aliases.Get(alias)
 }
 
 func (repo LocalRepo) GetSpecification(schema uuid.UUID) (string, bool) {
-	codec, ok := repo.Schemata.Map[schema]
+	codec, ok := repo.Schemata.Get(schema)
 	if !ok {
 		return "", false
 	}
@@ -103,78 +221,240 @@ func (repo LocalRepo) GetSpecification(schema uuid.UUID) (string, bool) {
 }
 
 func (repo LocalRepo) Count() int {
-	return len(repo.Schemata.Map)
+	return repo.Schemata.Len()
+}
+
+func (repo LocalRepo) SchemaID(schema uuid.UUID) (int32, bool) {
+	return repo.Schemata.ID(schema)
+}
+
+func (repo LocalRepo) WhoHasID(id int32) (uuid.UUID, bool) {
+	return repo.Schemata.WhoHasID(id)
+}
+
+// LastID returns the highest integer ID which has been assigned to any schema, including hard-deleted ones.
+// Updaters using the repo as their SchemaSource assign the following IDs to new schemata.
+func (repo LocalRepo) LastID() int32 {
+	return repo.Schemata.LastID()
 }
 
 func (repo LocalRepo) handleSchemaUpdate(message *kafka.Message) error {
+	if message.Value == nil {
+		schemaUUID, err := uuid.ParseBytes(message.Key)
+		if err != nil {
+			return fmt.Errorf("tombstone with invalid key %q: %w", message.Key, err)
+		}
+		repo.Logger.Printf("^^ Tombstone %v\n", schemaUUID)
+		repo.Schemata.Delete(schemaUUID, HardDelete)
+		return nil
+	}
+
 	var request UpdateRequest
 	err := json.Unmarshal(message.Value, &request)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("^^ UpdateRequest %v: %v\n", request.UUID, request.Spec)
+	if request.Deleted && request.Spec == "" {
+		// Soft-deletions written by older updaters do not carry the specification
+		repo.Logger.Printf("^^ UpdateRequest %v: deleted\n", request.UUID)
+		repo.Schemata.Delete(request.UUID, SoftDelete)
+		return nil
+	}
+
+	repo.Logger.Printf("^^ UpdateRequest %v: %v\n", request.UUID, request.Spec)
 
 	codec, err := goavro.NewCodec(request.Spec)
 	if err != nil {
-		log.Println(err)
+		repo.Logger.Printf("!! %v", err)
 		return err
 	}
 
-	repo.Schemata.Upsert(request.UUID, codec)
+	repo.Schemata.upsert(request.UUID, codec, request.ID)
+	if request.Deleted {
+		repo.Logger.Printf("^^ UpdateRequest %v: deleted\n", request.UUID)
+		repo.Schemata.Delete(request.UUID, SoftDelete)
+		return nil
+	}
+
+	for _, alias := range repo.Aliases.AliasesOf(request.UUID) {
+		repo.checkPolicy(alias)
+	}
 
 	return nil
 }
 
 func (repo LocalRepo) handleAliasUpdate(message *kafka.Message) error {
+	if message.Value == nil {
+		repo.Logger.Printf("^^ Tombstone %v\n", string(message.Key))
+		repo.deleteAlias(Alias(message.Key))
+		return nil
+	}
+
 	var request AliasRequest
 	err := json.Unmarshal(message.Value, &request)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("^^ AliasRequest %v: %v\n", request.UUID, request.Alias)
+	if request.Deleted {
+		repo.Logger.Printf("^^ AliasRequest %v: deleted\n", request.Alias)
+		repo.deleteAlias(Alias(request.Alias))
+		return nil
+	}
+
+	repo.Logger.Printf("^^ AliasRequest %v: %v\n", request.UUID, request.Alias)
 
 	repo.Aliases.Insert(Alias(request.Alias), request.UUID)
+	if version, err := VersionFromAlias(Alias(request.Alias)); err == nil {
+		repo.Versions.Insert(version)
+	}
+	repo.checkPolicy(Alias(request.Alias))
 
 	return nil
 }
 
+func (repo LocalRepo) handleCompatibilityUpdate(message *kafka.Message) error {
+	if message.Value == nil {
+		repo.Logger.Printf("^^ Tombstone %v\n", string(message.Key))
+		repo.Compatibilities.Delete(string(message.Key))
+		return nil
+	}
+
+	var request CompatibilityRequest
+	err := json.Unmarshal(message.Value, &request)
+	if err != nil {
+		return err
+	}
+
+	repo.Logger.Printf("^^ CompatibilityRequest %v: %v\n", request.Name, request.Compatibility)
+
+	level, err := ParseCompatibility(string(request.Compatibility))
+	if err != nil {
+		return err
+	}
+	repo.Compatibilities.Set(request.Name, level)
+
+	return nil
+}
+
+// deleteAlias deletes an alias and, if it is versioned, its version.
+// Soft- and hard-deleted aliases are treated alike, since aliases are not needed for decoding.
+func (repo LocalRepo) deleteAlias(alias Alias) {
+	repo.Aliases.Delete(alias)
+	if version, err := VersionFromAlias(alias); err == nil {
+		repo.Versions.Remove(version)
+	}
+	repo.Violations.Clear(alias)
+}
+
+// consumed wraps a handler so that the offset of every message is recorded once it has been handled.
+// Messages which cannot be handled are skipped, since consuming them again would not change that.
+func (repo LocalRepo) consumed(handler core.Handler) core.Handler {
+	return func(message *kafka.Message) error {
+		err := handler(message)
+		repo.Offsets.Advance(message.TopicPartition)
+		repo.readiness.check(repo.Offsets)
+		return err
+	}
+}
+
+// checkPolicy checks a versioned alias against the compatibility level of its name and flags it, if it is in violation.
+// Aliases whose schema has not been consumed yet are checked once the schema arrives.
+func (repo LocalRepo) checkPolicy(alias Alias) {
+	version, err := VersionFromAlias(alias)
+	if err != nil {
+		return
+	}
+	schemaUUID, ok := repo.WhoIs(alias)
+	if !ok {
+		return
+	}
+	specification, ok := repo.GetSpecification(schemaUUID)
+	if !ok {
+		return
+	}
+
+	level := repo.GetCompatibility(version.Name)
+	err = repo.CheckCompatibility(version, specification, level)
+	if err != nil {
+		violation := PolicyViolation{Alias: alias, UUID: schemaUUID, Level: level, Err: err}
+		repo.Logger.Printf("!! %v", violation)
+		repo.Violations.Flag(violation)
+		return
+	}
+	repo.Violations.Clear(alias)
+}
+
 // NewLocalRepo constructs a LocalRepo configured for the specified Kafka broker.
 // Note that since the repo is a Consumer, it needs to be started with Run() before it starts consuming.
 func NewLocalRepo(broker string) (LocalRepo, error) {
-	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers":     broker,
-		"group.id":              uuid.New().String(),
-		"broker.address.family": "v4",
-		"session.timeout.ms":    6000,
-		"auto.offset.reset":     "earliest",
-	})
-	if err != nil {
-		return LocalRepo{}, err
-	}
+	return NewLocalRepoWithOptions(broker, Options{})
+}
+
+// NewLocalRepoWithOptions constructs a LocalRepo of the configured schema repository for the specified Kafka broker.
+// Note that since the repo is a Consumer, it needs to be started with Run() before it starts consuming.
+// Use NewLocalRepoWithConfig to configure its Kafka consumer as well.
+func NewLocalRepoWithOptions(broker string, options Options) (LocalRepo, error) {
+	return NewLocalRepoWithConfig(broker, WithTopicOptions(options))
+}
 
+// NewLocalRepoWithLog constructs a LocalRepo that consumes from the given SchemaLogReader.
+// In most cases, it is fine to use NewLocalRepo instead and let it consume from Kafka.
+// Note that since the repo is a Consumer, it needs to be started with Run() before it starts consuming.
+func NewLocalRepoWithLog(reader SchemaLogReader) LocalRepo {
+	return NewLocalRepoWithLogAndOptions(reader, Options{})
+}
+
+// NewLocalRepoWithLogAndOptions constructs a LocalRepo that consumes the topics of the configured schema repository
+// from the given SchemaLogReader.
+// Note that since the repo is a Consumer, it needs to be started with Run() before it starts consuming.
+func NewLocalRepoWithLogAndOptions(reader SchemaLogReader, options Options) LocalRepo {
+	return newLocalRepo(reader, options, stdLogger{})
+}
+
+func newLocalRepo(reader SchemaLogReader, options Options, logger Logger) LocalRepo {
 	repo := LocalRepo{
-		TopicRouter: core.NewTopicRouter(consumer),
-		Schemata:    NewSchemaMap(),
-		Aliases:     NewAliasMap(),
+		SchemaLogReader: reader,
+		Schemata:        NewSchemaMap(),
+		Aliases:         NewAliasMap(),
+		Versions:        NewVersionMap(),
+		Compatibilities: NewCompatibilityMap(),
+		Violations:      NewViolationMap(),
+		Offsets:         NewOffsetMap(),
+		Topics:          options.TopicNames(),
+		Logger:          logger,
+		readiness:       newReadiness(),
 	}
-	log.Printf("Created schema repository with TopicRouter %v", repo.TopicRouter)
+	repo.Logger.Printf("Created schema repository with SchemaLogReader %v and topics %v", repo.SchemaLogReader, repo.Topics.List())
 
-	repo.NewRoute(catchall.NewPlainKey("schema_update"), repo.handleSchemaUpdate)
-	repo.NewRoute(catchall.NewPlainKey("schema_alias"), repo.handleAliasUpdate)
+	repo.NewRoute(catchall.NewPlainKey(repo.Topics.Update), repo.consumed(repo.handleSchemaUpdate))
+	repo.NewRoute(catchall.NewPlainKey(repo.Topics.Alias), repo.consumed(repo.handleAliasUpdate))
+	repo.NewRoute(catchall.NewPlainKey(repo.Topics.Compatibility), repo.consumed(repo.handleCompatibilityUpdate))
 
-	return repo, nil
+	return repo
 }
 
 func (repo LocalRepo) DecodeVersion(schema NameVersion, datum []byte) (interface{}, error) {
-	if schemaUUID, ok := repo.WhoIs(schema.Alias()); !ok {
+	if schemaUUID, ok := repo.WhoIs(schema.Alias()); ok {
 		decoded, err := repo.Decode(schemaUUID, datum)
 		return decoded, err
 	}
 	return nil, errors.New("schema not know to this repo")
 }
 
+func (repo LocalRepo) DecodeVersionAs(writer NameVersion, reader NameVersion, datum []byte) (interface{}, error) {
+	writerUUID, ok := repo.WhoIs(writer.Alias())
+	if !ok {
+		return nil, errors.New("writer schema not know to this repo")
+	}
+	readerUUID, ok := repo.WhoIs(reader.Alias())
+	if !ok {
+		return nil, errors.New("reader schema not know to this repo")
+	}
+	return repo.DecodeAs(writerUUID, readerUUID, datum)
+}
+
 func (repo LocalRepo) EncodeVersion(schema NameVersion, datum interface{}) ([]byte, error) {
 	if schemaUUID, ok := repo.WhoIs(schema.Alias()); ok {
 		encoded, err := repo.Encode(schemaUUID, datum)
@@ -183,6 +463,74 @@ func (repo LocalRepo) EncodeVersion(schema NameVersion, datum interface{}) ([]by
 	return nil, errors.New("schema not know to this repo")
 }
 
+func (repo LocalRepo) DecodeVersionJSON(schema NameVersion, datum []byte) ([]byte, error) {
+	if schemaUUID, ok := repo.WhoIs(schema.Alias()); ok {
+		return repo.DecodeJSON(schemaUUID, datum)
+	}
+	return nil, errors.New("schema not know to this repo")
+}
+
+func (repo LocalRepo) EncodeVersionJSON(schema NameVersion, datum []byte) ([]byte, error) {
+	if schemaUUID, ok := repo.WhoIs(schema.Alias()); ok {
+		return repo.EncodeJSON(schemaUUID, datum)
+	}
+	return nil, errors.New("schema not know to this repo")
+}
+
 func (repo LocalRepo) WaitVersionReady(schema NameVersion) chan bool {
-	return repo.WaitAliasReady(schema.Alias())
+	return awaitReady(func(ctx context.Context) error {
+		return repo.AwaitVersion(ctx, schema)
+	})
+}
+
+func (repo LocalRepo) WaitLatestVersionReady(name string) chan bool {
+	return awaitReady(func(ctx context.Context) error {
+		_, err := repo.AwaitLatestVersion(ctx, name)
+		return err
+	})
+}
+
+func (repo LocalRepo) LatestVersion(name string) (NameVersion, bool) {
+	return repo.Versions.Latest(name)
+}
+
+func (repo LocalRepo) ListVersions(name string) []NameVersion {
+	return repo.Versions.List(name)
+}
+
+func (repo LocalRepo) GetNext(schema NameVersion) (NameVersion, bool) {
+	return repo.Versions.Next(schema)
+}
+
+func (repo LocalRepo) CheckCompatibility(schema NameVersion, specification string, level Compatibility) error {
+	versions := repo.ListVersions(schema.Name)
+	for i := len(versions) - 1; i >= 0; i-- {
+		previous := versions[i]
+		if previous.Version >= schema.Version {
+			continue
+		}
+		schemaUUID, known := repo.WhoIs(previous.Alias())
+		if !known {
+			continue
+		}
+		previousSpecification, known := repo.GetSpecification(schemaUUID)
+		if !known {
+			continue
+		}
+		if err := level.Check(specification, previousSpecification); err != nil {
+			return fmt.Errorf("%v: %w", previous, err)
+		}
+		if !level.IsTransitive() {
+			break
+		}
+	}
+	return nil
+}
+
+func (repo LocalRepo) GetCompatibility(name string) Compatibility {
+	return repo.Compatibilities.Get(name)
+}
+
+func (repo LocalRepo) ListViolations() []PolicyViolation {
+	return repo.Violations.List()
 }
@@ -0,0 +1,934 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// LocalRepo implements all repo interfaces.
+var (
+	_ Repo          = LocalRepo{}
+	_ FramedRepo    = LocalRepo{}
+	_ IDRepo        = LocalRepo{}
+	_ AliasRepo     = LocalRepo{}
+	_ VersionedRepo = LocalRepo{}
+	_ PolicyRepo    = LocalRepo{}
+	_ AwaitRepo     = LocalRepo{}
+	_ ReadyRepo     = LocalRepo{}
+	_ ClientRepo    = LocalRepo{}
+)
+
+// These tests are meant to be run with the race detector, i.e. go test -race.
+
+const stressSpecification = `{"type": "record", "name": "stress", "fields": [{"name": "n", "type": "long"}]}`
+
+// stressMessages constructs the messages a TopicRouter would hand to the repo's handlers.
+func stressMessages(t *testing.T, n int) ([]*kafka.Message, []*kafka.Message) {
+	schemaTopic, aliasTopic := "schema_update", "schema_alias"
+	schemaMessages := make([]*kafka.Message, 0, n)
+	aliasMessages := make([]*kafka.Message, 0, n)
+	for i := 0; i < n; i++ {
+		schemaUUID := uuid.New()
+		schemaUpdate, err := json.Marshal(UpdateRequest{UUID: schemaUUID, Spec: stressSpecification})
+		if err != nil {
+			t.Fatal(err)
+		}
+		aliasUpdate, err := json.Marshal(AliasRequest{UUID: schemaUUID, Alias: NameVersion{Name: "stress", Version: uint(i)}.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		schemaMessages = append(schemaMessages, &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &schemaTopic}, Value: schemaUpdate})
+		aliasMessages = append(aliasMessages, &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &aliasTopic}, Value: aliasUpdate})
+	}
+	return schemaMessages, aliasMessages
+}
+
+// hammer calls every read method of the repo until done is closed.
+func hammer(repo LocalRepo, done chan struct{}) {
+	datum := map[string]interface{}{"n": int64(1)}
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		for _, schemaUUID := range repo.ListSchemata() {
+			repo.GetSpecification(schemaUUID)
+			encoded, err := repo.Encode(schemaUUID, datum)
+			if err == nil {
+				_, _ = repo.Decode(schemaUUID, encoded)
+			}
+			framed, err := repo.EncodeSingleObject(schemaUUID, datum)
+			if err == nil {
+				_, _, _ = repo.DecodeSingleObject(framed)
+			}
+		}
+		for _, alias := range repo.ListAliases() {
+			repo.WhoIs(alias)
+		}
+		repo.Count()
+		repo.ListVersions("stress")
+		repo.LatestVersion("stress")
+		repo.ListViolations()
+	}
+}
+
+// TestLocalRepoConcurrentHandlers hands updates to the repo concurrently, like core.TopicRouter does,
+// while reading from the repo.
+func TestLocalRepoConcurrentHandlers(t *testing.T) {
+	const updates = 200
+	repo := NewLocalRepoWithLog(NewMemoryLog().NewReader())
+	schemaMessages, aliasMessages := stressMessages(t, updates)
+
+	done := make(chan struct{})
+	readers := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		readers.Add(1)
+		go (func() {
+			defer readers.Done()
+			hammer(repo, done)
+		})()
+	}
+
+	handlers := sync.WaitGroup{}
+	for i := 0; i < updates; i++ {
+		handlers.Add(2)
+		go (func(message *kafka.Message) {
+			defer handlers.Done()
+			if err := repo.handleSchemaUpdate(message); err != nil {
+				t.Error(err)
+			}
+		})(schemaMessages[i])
+		go (func(message *kafka.Message) {
+			defer handlers.Done()
+			if err := repo.handleAliasUpdate(message); err != nil {
+				t.Error(err)
+			}
+		})(aliasMessages[i])
+	}
+	handlers.Wait()
+	close(done)
+	readers.Wait()
+
+	if count := repo.Count(); count != updates {
+		t.Errorf("expected %v schemata, got %v", updates, count)
+	}
+	if count := len(repo.ListAliases()); count != updates {
+		t.Errorf("expected %v aliases, got %v", updates, count)
+	}
+	if latest, _ := repo.LatestVersion("stress"); latest.Version != updates-1 {
+		t.Errorf("expected latest version %v, got %v", updates-1, latest.Version)
+	}
+}
+
+// TestLocalRepoConcurrentWaiters waits for schemata and aliases from many goroutines while they are being published.
+func TestLocalRepoConcurrentWaiters(t *testing.T) {
+	const updates = 100
+	schemaLog := NewMemoryLog()
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	updater := NewUpdaterWithLog(schemaLog)
+
+	done := make(chan struct{})
+	readers := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go (func() {
+			defer readers.Done()
+			hammer(repo, done)
+		})()
+	}
+
+	waiters := sync.WaitGroup{}
+	for i := 0; i < updates; i++ {
+		version := NameVersion{Name: "stress", Version: uint(i)}
+		waiters.Add(2)
+		go (func() {
+			defer waiters.Done()
+			<-repo.WaitVersionReady(version)
+		})()
+		go (func() {
+			defer waiters.Done()
+			<-repo.WaitAliasReady(version.Alias())
+		})()
+
+		schemaUUID := uuid.New()
+		if err := updater.UpdateSchema(schemaUUID, stressSpecification); err != nil {
+			t.Fatal(err)
+		}
+		if err := updater.UpdateAlias(version.String(), schemaUUID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waiters.Wait()
+	close(done)
+	readers.Wait()
+
+	for i := 0; i < updates; i++ {
+		version := NameVersion{Name: "stress", Version: uint(i)}
+		if _, err := repo.EncodeVersion(version, map[string]interface{}{"n": int64(i)}); err != nil {
+			t.Errorf("%v: %v", version, fmt.Sprint(err))
+		}
+	}
+}
+
+// TestLocalRepoSchemaIDs checks that repos consuming the same log agree on the integer IDs of the schemata.
+func TestLocalRepoSchemaIDs(t *testing.T) {
+	const updates = 20
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	schemata := make([]uuid.UUID, 0, updates)
+	for i := 0; i < updates; i++ {
+		schemaUUID := uuid.New()
+		if err := updater.UpdateSchema(schemaUUID, stressSpecification); err != nil {
+			t.Fatal(err)
+		}
+		schemata = append(schemata, schemaUUID)
+	}
+	// Updating a schema does not assign a new ID
+	if err := updater.UpdateSchema(schemata[0], stressSpecification); err != nil {
+		t.Fatal(err)
+	}
+
+	repos := []LocalRepo{NewLocalRepoWithLog(schemaLog.NewReader()), NewLocalRepoWithLog(schemaLog.NewReader())}
+	for _, repo := range repos {
+		ready := repo.WaitSchemaReady(schemata[updates-1])
+		stop, err := repo.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer (func() {
+			stop <- true
+		})()
+		<-ready
+	}
+
+	// Republishing a schema moves its event behind the others once the log is compacted
+	if err := updater.UpdateSchema(schemata[0], stressSpecification); err != nil {
+		t.Fatal(err)
+	}
+	schemaLog.Compact()
+	compacted := NewLocalRepoWithLog(schemaLog.NewReader())
+	ready := compacted.WaitSchemaReady(schemata[0])
+	stopCompacted, err := compacted.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stopCompacted <- true
+	})()
+	<-ready
+	repos = append(repos, compacted)
+
+	ids := make(map[int32]uuid.UUID)
+	for i, schemaUUID := range schemata {
+		id, ok := repos[0].SchemaID(schemaUUID)
+		if !ok {
+			t.Fatalf("expected %v to have an ID", schemaUUID)
+		}
+		if id != int32(i+1) {
+			t.Errorf("expected %v to have ID %v in log order, got %v", schemaUUID, i+1, id)
+		}
+		if other, taken := ids[id]; taken {
+			t.Errorf("expected %v and %v to have different IDs, both have %v", schemaUUID, other, id)
+		}
+		ids[id] = schemaUUID
+		for _, repo := range repos[1:] {
+			if other, _ := repo.SchemaID(schemaUUID); other != id {
+				t.Errorf("expected ID %v for %v, got %v", id, schemaUUID, other)
+			}
+		}
+	}
+
+	encoded, err := repos[0].EncodeCompact(schemata[3], map[string]interface{}{"n": int64(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, writer, err := repos[1].DecodeCompact(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writer != schemata[3] || decoded.(map[string]interface{})["n"] != int64(3) {
+		t.Errorf("expected %v written with %v, got %v written with %v", 3, schemata[3], decoded, writer)
+	}
+}
+
+// TestUpdaterAssignsSequentialIDs checks that updaters observing the log assign IDs sequentially in log order
+// and do not assign the IDs of hard-deleted schemata again.
+func TestUpdaterAssignsSequentialIDs(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	updaters := []Updater{NewUpdaterWithLogAndSource(schemaLog, Options{}, repo), NewUpdaterWithLogAndSource(schemaLog, Options{}, repo)}
+	schemata := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for i, schemaUUID := range schemata {
+		ready := repo.WaitSchemaReady(schemaUUID)
+		if err := updaters[i%len(updaters)].UpdateSchema(schemaUUID, stressSpecification); err != nil {
+			t.Fatal(err)
+		}
+		<-ready
+		if id, _ := repo.SchemaID(schemaUUID); id != int32(i+1) {
+			t.Errorf("expected %v to have ID %v, got %v", schemaUUID, i+1, id)
+		}
+	}
+
+	deleted := repo.Schemata.ObserveDeletion(schemata[2])
+	if err := updaters[0].DeleteSchema(schemata[2], HardDelete); err != nil {
+		t.Fatal(err)
+	}
+	<-deleted
+	schemaUUID := publishSchema(t, updaters[1], repo, stressSpecification)
+	if id, _ := repo.SchemaID(schemaUUID); id != 4 {
+		t.Errorf("expected %v to have ID 4 after ID 3 has been retired, got %v", schemaUUID, id)
+	}
+}
+
+// TestLocalRepoIDCollision checks that repos agree on the IDs of schemata which have been published
+// with the same ID by updaters that do not observe each other.
+func TestLocalRepoIDCollision(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	schemata := []uuid.UUID{uuid.New(), uuid.New()}
+	for _, schemaUUID := range schemata {
+		if err := NewUpdaterWithLog(schemaLog).UpdateSchema(schemaUUID, stressSpecification); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repos := []LocalRepo{NewLocalRepoWithLog(schemaLog.NewReader()), NewLocalRepoWithLog(schemaLog.NewReader())}
+	for _, repo := range repos {
+		stop, err := repo.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer (func() {
+			stop <- true
+		})()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := repo.WaitCaughtUp(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for i, schemaUUID := range schemata {
+			if id, ok := repo.SchemaID(schemaUUID); !ok || id != int32(i+1) {
+				t.Errorf("expected %v to have ID %v, got %v", schemaUUID, i+1, id)
+			}
+		}
+	}
+
+	encoded, err := repos[0].EncodeCompact(schemata[1], map[string]interface{}{"n": int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, writer, err := repos[1].DecodeCompact(encoded); err != nil || writer != schemata[1] {
+		t.Errorf("expected the message to be written with %v, got %v (%v)", schemata[1], writer, err)
+	}
+}
+
+// TestLocalRepoDeletion checks that soft-deleted schemata remain decodable, that hard-deleted ones are removed
+// and that the IDs of hard-deleted schemata are not assigned again.
+func TestLocalRepoDeletion(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	first, second := NewVersionOrigin("stress"), NameVersion{Name: "stress", Version: 1}
+	firstUUID := publishVersion(t, updater, repo, first)
+	secondUUID := publishVersion(t, updater, repo, second)
+	encoded, err := repo.Encode(firstUUID, map[string]interface{}{"n": int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := repo.Schemata.ObserveDeletion(firstUUID)
+	if err := updater.DeleteSchema(firstUUID, SoftDelete); err != nil {
+		t.Fatal(err)
+	}
+	<-deleted
+	if repo.Count() != 1 || len(repo.ListSchemata()) != 1 {
+		t.Errorf("expected a soft-deleted schema not to be listed, got %v", repo.ListSchemata())
+	}
+	if _, err := repo.Decode(firstUUID, encoded); err != nil {
+		t.Errorf("expected a soft-deleted schema to remain decodable, got %v", err)
+	}
+	if _, err := repo.Encode(firstUUID, map[string]interface{}{"n": int64(1)}); err == nil {
+		t.Error("expected a soft-deleted schema not to be usable for encoding")
+	}
+
+	secondID, _ := repo.SchemaID(secondUUID)
+	deleted = repo.Schemata.ObserveDeletion(secondUUID)
+	if err := updater.DeleteSchema(secondUUID, HardDelete); err != nil {
+		t.Fatal(err)
+	}
+	<-deleted
+	if _, ok := repo.GetSpecification(secondUUID); ok {
+		t.Error("expected a hard-deleted schema to be removed")
+	}
+	if _, ok := repo.WhoHasID(secondID); ok {
+		t.Error("expected the ID of a hard-deleted schema to be removed")
+	}
+
+	deleted = repo.Aliases.ObserveDeletion(second.Alias())
+	if err := updater.DeleteAlias(second.Alias().String(), HardDelete); err != nil {
+		t.Fatal(err)
+	}
+	<-deleted
+	if latest, ok := repo.LatestVersion("stress"); !ok || latest != first {
+		t.Errorf("expected %v to be the latest version, got %v", first, latest)
+	}
+	deleted = repo.Aliases.ObserveDeletion(first.Alias())
+	if err := updater.DeleteAlias(first.Alias().String(), SoftDelete); err != nil {
+		t.Fatal(err)
+	}
+	<-deleted
+	if _, ok := repo.WhoIs(first.Alias()); ok {
+		t.Errorf("expected %v to be deleted", first.Alias())
+	}
+
+	thirdUUID := publishVersion(t, updater, repo, first)
+	if id, _ := repo.SchemaID(thirdUUID); id == 0 || id == secondID {
+		t.Errorf("expected a new ID for %v, got %v", thirdUUID, id)
+	}
+}
+
+// TestLocalRepoDeletionKeys checks that deleting an alias does not notify the observers of other aliases,
+// even if their names differ only by a prefix, and that tombstones of compatibility levels reset them.
+func TestLocalRepoDeletionKeys(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	schemaUUID := publishVersion(t, updater, repo, NewVersionOrigin("stress"))
+	for _, alias := range []Alias{"foo", "-foo"} {
+		ready := repo.WaitAliasReady(alias)
+		if err := updater.UpdateAlias(alias.String(), schemaUUID); err != nil {
+			t.Fatal(err)
+		}
+		<-ready
+	}
+	unrelated := repo.Aliases.ObserveDeletion("foo")
+	deleted := repo.Aliases.ObserveDeletion("-foo")
+	if err := updater.DeleteAlias("-foo", HardDelete); err != nil {
+		t.Fatal(err)
+	}
+	<-deleted
+	select {
+	case <-unrelated:
+		t.Error("expected deleting -foo not to notify the observers of foo's deletion")
+	default:
+	}
+	repo.Aliases.UnobserveDeletion("foo", unrelated)
+	if _, ok := repo.WhoIs("foo"); !ok {
+		t.Error("expected foo to remain")
+	}
+
+	if err := updater.UpdateCompatibility("stress", CompatibilityNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := updater.(Commander).produceKeyed(repo.Topics.Compatibility, "stress", nil); err != nil {
+		t.Fatal(err)
+	}
+	// Events are consumed in order, so the tombstone has been consumed once the following schema is present
+	publishVersion(t, updater, repo, NameVersion{Name: "stress", Version: 1})
+	if level := repo.GetCompatibility("stress"); level != DefaultCompatibility {
+		t.Errorf("expected a tombstone to reset the compatibility level to %v, got %v", DefaultCompatibility, level)
+	}
+}
+
+// TestLocalRepoCompaction checks that a repo consuming a compacted log ends up in the same state
+// as a repo which consumed the log before it was compacted.
+func TestLocalRepoCompaction(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	first, second := NewVersionOrigin("stress"), NameVersion{Name: "stress", Version: 1}
+	firstUUID := publishVersion(t, updater, repo, first)
+	secondUUID := publishVersion(t, updater, repo, second)
+	if err := updater.UpdateCompatibility("stress", CompatibilityFull); err != nil {
+		t.Fatal(err)
+	}
+	if err := updater.UpdateCompatibility("stress", CompatibilityNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := updater.DeleteAlias(second.Alias().String(), HardDelete); err != nil {
+		t.Fatal(err)
+	}
+	deleted := repo.Schemata.ObserveDeletion(secondUUID)
+	if err := updater.DeleteSchema(secondUUID, HardDelete); err != nil {
+		t.Fatal(err)
+	}
+	<-deleted
+
+	third := NameVersion{Name: "stress", Version: 2}
+	thirdUUID := publishVersion(t, updater, repo, third)
+	encoded, err := repo.Encode(thirdUUID, map[string]interface{}{"n": int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updater.DeleteAlias(third.Alias().String(), HardDelete); err != nil {
+		t.Fatal(err)
+	}
+	deleted = repo.Schemata.ObserveDeletion(thirdUUID)
+	if err := updater.DeleteSchema(thirdUUID, SoftDelete); err != nil {
+		t.Fatal(err)
+	}
+	<-deleted
+
+	schemaLog.Compact()
+	if events, _, _ := schemaLog.read(0); len(events) != 4 {
+		t.Errorf("expected 4 events to remain after compaction, got %v", len(events))
+	}
+
+	compacted := NewLocalRepoWithLog(schemaLog.NewReader())
+	stopCompacted, err := compacted.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stopCompacted <- true
+	})()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := compacted.WaitCaughtUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []LocalRepo{repo, compacted} {
+		if r.Count() != 1 || len(r.ListAliases()) != 1 {
+			t.Errorf("expected one schema and alias, got %v and %v", r.ListSchemata(), r.ListAliases())
+		}
+		if schemaUUID, _ := r.WhoIs(first.Alias()); schemaUUID != firstUUID {
+			t.Errorf("expected %v to refer to %v, got %v", first.Alias(), firstUUID, schemaUUID)
+		}
+		if _, err := r.Decode(thirdUUID, encoded); err != nil || !r.Schemata.IsDeleted(thirdUUID) {
+			t.Errorf("expected a soft-deleted schema to remain decodable, got %v", err)
+		}
+	}
+	thirdID, _ := repo.SchemaID(thirdUUID)
+	if id, _ := compacted.SchemaID(thirdUUID); id != thirdID {
+		t.Errorf("expected the soft-deleted schema to keep ID %v, got %v", thirdID, id)
+	}
+}
+
+// TestLocalRepoNamespaces checks that schema repositories in different namespaces share a log without seeing each other's events.
+func TestLocalRepoNamespaces(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	staging, production := Options{Namespace: "staging"}, Options{Prefix: "production-"}
+	if topics := staging.TopicNames(); topics.Update != "staging.schema_update" {
+		t.Errorf("expected namespaced topic staging.schema_update, got %v", topics.Update)
+	}
+
+	repos := make([]LocalRepo, 0, 2)
+	schemata := make([]uuid.UUID, 0, 2)
+	for _, options := range []Options{staging, production} {
+		repo := NewLocalRepoWithLogAndOptions(schemaLog.NewReader(), options)
+		stop, err := repo.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer (func() {
+			stop <- true
+		})()
+		repos = append(repos, repo)
+		schemata = append(schemata, publishVersion(t, NewUpdaterWithLogAndOptions(schemaLog, options), repo, NewVersionOrigin("stress")))
+	}
+
+	for i, repo := range repos {
+		if repo.Count() != 1 {
+			t.Errorf("expected one schema in %v, got %v", repo.Topics.List(), repo.ListSchemata())
+		}
+		if schemaUUID, _ := repo.WhoIs(NewVersionOrigin("stress").Alias()); schemaUUID != schemata[i] {
+			t.Errorf("expected %v in %v, got %v", schemata[i], repo.Topics.List(), schemaUUID)
+		}
+	}
+}
+
+// TestLocalRepoCaughtUp checks that a repo is ready once it has consumed every event which existed when it was started.
+func TestLocalRepoCaughtUp(t *testing.T) {
+	const updates = 50
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	for i := 0; i < updates; i++ {
+		if err := updater.UpdateSchema(uuid.New(), stressSpecification); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A tombstone which has been compacted away is not waited for
+	last := uuid.New()
+	if err := updater.DeleteSchema(last, HardDelete); err != nil {
+		t.Fatal(err)
+	}
+	schemaLog.Compact()
+
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	if repo.Ready() {
+		t.Error("expected the repo not to be ready before it has been started")
+	}
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := repo.WaitCaughtUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !repo.Ready() || repo.Count() != updates {
+		t.Errorf("expected the repo to be ready with %v schemata, got %v", updates, repo.Count())
+	}
+
+	empty := NewLocalRepoWithLog(NewMemoryLog().NewReader())
+	stopEmpty, err := empty.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stopEmpty <- true
+	})()
+	if !empty.Ready() {
+		t.Error("expected a repo consuming an empty log to be ready right away")
+	}
+}
+
+// unreachableWatermarks is a MemoryLogReader whose high-water marks cannot be queried the first few times,
+// like those of a KafkaLogReader while the brokers are unreachable.
+type unreachableWatermarks struct {
+	*MemoryLogReader
+	lock     sync.Mutex
+	failures int
+}
+
+func (r *unreachableWatermarks) HighWatermarks() ([]kafka.TopicPartition, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		return nil, errors.New("broker unreachable")
+	}
+	return r.MemoryLogReader.HighWatermarks()
+}
+
+// TestLocalRepoCaughtUpRetry checks that a repo whose high-water marks cannot be queried consumes nonetheless,
+// and becomes ready once querying them succeeds.
+func TestLocalRepoCaughtUpRetry(t *testing.T) {
+	defer (func(interval time.Duration) {
+		watermarkRetryInterval = interval
+	})(watermarkRetryInterval)
+	watermarkRetryInterval = 10 * time.Millisecond
+
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	schemaUUID := uuid.New()
+	if err := updater.UpdateSchema(schemaUUID, stressSpecification); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewLocalRepoWithLog(&unreachableWatermarks{MemoryLogReader: schemaLog.NewReader(), failures: 3})
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	if repo.Ready() {
+		t.Error("expected the repo not to be ready before its high-water marks are known")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := repo.AwaitSchema(ctx, schemaUUID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.WaitCaughtUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// observerCount returns the number of observers which have not been notified yet.
+func observerCount(o *keyObservable) int {
+	o.observerLock.Lock()
+	defer o.observerLock.Unlock()
+	count := 0
+	for _, observers := range o.observers {
+		count += len(observers)
+	}
+	return count
+}
+
+// TestLocalRepoAwaitLeaks checks that waiting leaves neither goroutines nor observers behind,
+// whether the awaited schemata arrive or the waiting is cancelled.
+func TestLocalRepoAwaitLeaks(t *testing.T) {
+	const waiters = 50
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	wg := sync.WaitGroup{}
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go (func() {
+			defer wg.Done()
+			if err := repo.AwaitSchema(ctx, uuid.New()); err != context.DeadlineExceeded {
+				t.Errorf("expected the deadline to be exceeded, got %v", err)
+			}
+			if err := repo.AwaitAlias(ctx, "missing"); err != context.DeadlineExceeded {
+				t.Errorf("expected the deadline to be exceeded, got %v", err)
+			}
+			if _, err := repo.AwaitLatestVersion(ctx, "missing"); err != context.DeadlineExceeded {
+				t.Errorf("expected the deadline to be exceeded, got %v", err)
+			}
+		})()
+	}
+	wg.Wait()
+
+	for i := 0; i < waiters; i++ {
+		schemaUUID := publishVersion(t, updater, repo, NameVersion{Name: "stress", Version: uint(i)})
+		// Notifies observers which have already been notified before
+		if err := updater.UpdateSchema(schemaUUID, stressSpecification); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.AwaitLatestVersion(context.Background(), "stress"); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > baseline && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if leaked := runtime.NumGoroutine() - baseline; leaked > 0 {
+		t.Errorf("expected no goroutines to remain, %v are left", leaked)
+	}
+	for _, observable := range []*keyObservable{&repo.Schemata.keyObservable, &repo.Aliases.keyObservable, &repo.Versions.keyObservable} {
+		if count := observerCount(observable); count > 0 {
+			t.Errorf("expected no observers to remain, %v are left", count)
+		}
+	}
+}
+
+// TestLocalRepoDecodeUnknownWriter checks that decoding a message written with an unknown schema fails,
+// once the repo has caught up or the context is done.
+func TestLocalRepoDecodeUnknownWriter(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	schemaUUID := publishVersion(t, updater, repo, NewVersionOrigin("stress"))
+	datum := map[string]interface{}{"n": int64(1)}
+	framed, err := repo.EncodeFramed(schemaUUID, datum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compact, err := repo.EncodeCompact(schemaUUID, datum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := repo.Schemata.ObserveDeletion(schemaUUID)
+	if err := updater.DeleteSchema(schemaUUID, HardDelete); err != nil {
+		t.Fatal(err)
+	}
+	<-deleted
+
+	decoders := map[string]func(message []byte) (interface{}, uuid.UUID, error){
+		"framed":  repo.DecodeFramed,
+		"compact": repo.DecodeCompact,
+	}
+	contextDecoders := map[string]func(ctx context.Context, message []byte) (interface{}, uuid.UUID, error){
+		"framed":  repo.DecodeFramedContext,
+		"compact": repo.DecodeCompactContext,
+	}
+	messages := map[string][]byte{"framed": framed, "compact": compact}
+	for name, message := range messages {
+		done := make(chan error, 1)
+		go (func() {
+			_, _, err := decoders[name](message)
+			done <- err
+		})()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("expected decoding a %v message written with a hard-deleted schema to fail", name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected decoding a %v message written with a hard-deleted schema not to block", name)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		if _, _, err := contextDecoders[name](ctx, message); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the deadline to be exceeded decoding a %v message, got %v", name, err)
+		}
+		cancel()
+	}
+}
+
+// TestLocalRepoJSON converts a datum into the Avro JSON encoding and back.
+func TestLocalRepoJSON(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	version := NewVersionOrigin("stress")
+	schemaUUID := publishVersion(t, updater, repo, version)
+
+	binary, err := repo.Encode(schemaUUID, map[string]interface{}{"n": int64(42)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	textual, err := repo.DecodeVersionJSON(version, binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(textual) != `{"n":42}` {
+		t.Errorf("expected {\"n\":42}, got %s", textual)
+	}
+	encoded, err := repo.EncodeJSON(schemaUUID, textual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != string(binary) {
+		t.Errorf("expected %v, got %v", binary, encoded)
+	}
+
+	if _, err := repo.EncodeVersionJSON(version, []byte(`{"n":"forty-two"}`)); err == nil {
+		t.Error("expected encoding JSON that does not match the schema to fail")
+	}
+	if _, err := repo.DecodeJSON(uuid.New(), binary); err == nil {
+		t.Error("expected decoding with an unknown schema to fail")
+	}
+}
+
+// TestLocalRepoInspect decodes messages in every framing, detecting the framing from their headers.
+func TestLocalRepoInspect(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	version := NewVersionOrigin("stress")
+	schemaUUID := publishVersion(t, updater, repo, version)
+
+	datum := map[string]interface{}{"n": int64(7)}
+	raw, err := repo.Encode(schemaUUID, datum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := map[Framing][]byte{FramingRaw: raw}
+	if messages[FramingUUID], err = repo.EncodeFramed(schemaUUID, datum); err != nil {
+		t.Fatal(err)
+	}
+	if messages[FramingSingleObject], err = repo.EncodeSingleObject(schemaUUID, datum); err != nil {
+		t.Fatal(err)
+	}
+	if messages[FramingConfluent], err = repo.EncodeCompact(schemaUUID, datum); err != nil {
+		t.Fatal(err)
+	}
+	for framing, message := range messages {
+		// The framing is detected, unless the schema of a raw message is given
+		writer := uuid.Nil
+		if framing == FramingRaw {
+			writer = schemaUUID
+		}
+		inspected, err := repo.Inspect(message, "", writer)
+		if err != nil {
+			t.Fatalf("%v: %v", framing, err)
+		}
+		if inspected.Framing != framing || inspected.UUID != schemaUUID || string(inspected.Datum) != `{"n":7}` {
+			t.Errorf("expected %v message {\"n\":7} written with %v, got %v message %s written with %v",
+				framing, schemaUUID, inspected.Framing, inspected.Datum, inspected.UUID)
+		}
+		if len(inspected.Versions) != 1 || inspected.Versions[0].Alias != version.Alias() {
+			t.Errorf("expected the version %v, got %v", version, inspected.Versions)
+		}
+	}
+
+	// Raw data starting like a header is decoded with the given schema
+	inspected, err := repo.Inspect(messages[FramingConfluent], "", schemaUUID)
+	if err != nil || inspected.Framing != FramingRaw || string(inspected.Datum) != `{"n":0}` {
+		t.Errorf("expected a raw message {\"n\":0}, got %v message %s (%v)", inspected.Framing, inspected.Datum, err)
+	}
+	if _, err := repo.Inspect(raw, "", uuid.Nil); !errors.Is(err, ErrInvalidFraming) {
+		t.Errorf("expected inspecting a raw message without a schema to fail with %v, got %v", ErrInvalidFraming, err)
+	}
+	if _, err := repo.Inspect(messages[FramingUUID], FramingConfluent, uuid.Nil); !errors.Is(err, ErrInvalidFraming) {
+		t.Errorf("expected inspecting a message in the wrong framing to fail with %v, got %v", ErrInvalidFraming, err)
+	}
+}
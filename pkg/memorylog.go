@@ -0,0 +1,200 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"errors"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/strangedev/catchall"
+	core "github.com/strangedev/kafka-golang/pkg"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryLog is an in-process event log that keeps all schema events in memory.
+// It can be used instead of Kafka for tests and local development, so that
+// the Updater and any number of LocalRepos can run in a single process.
+// Every topic has exactly one partition.
+type MemoryLog struct {
+	lock   sync.RWMutex
+	events []*kafka.Message
+	// sequence holds the position of each event among all events ever appended,
+	// so that readers keep their position when the log is compacted.
+	sequence []int
+	length   int
+	offsets  map[string]kafka.Offset
+	appended chan struct{}
+}
+
+// NewMemoryLog constructs an empty MemoryLog.
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{
+		offsets:  make(map[string]kafka.Offset),
+		appended: make(chan struct{}),
+	}
+}
+
+// ProduceSync appends a message to the log.
+// The message's partition and offset are assigned by the log.
+func (l *MemoryLog) ProduceSync(message *kafka.Message) error {
+	if message.TopicPartition.Topic == nil {
+		return errors.New("message has no topic")
+	}
+	topic := *message.TopicPartition.Topic
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	stored := *message
+	stored.TopicPartition = kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: l.offsets[topic]}
+	stored.Timestamp = time.Now()
+	l.events = append(l.events, &stored)
+	l.sequence = append(l.sequence, l.length)
+	l.length++
+	l.offsets[topic]++
+	// Wake up all readers waiting for new events
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return nil
+}
+
+// ProduceSimpleSync appends a message without headers or key to the log.
+// Since every topic has only one partition, the partition is ignored.
+func (l *MemoryLog) ProduceSimpleSync(topic string, partition int32, value []byte) error {
+	return l.ProduceSync(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
+		Value:          value,
+	})
+}
+
+// read returns all events from position onwards and the position following them,
+// together with a channel that is closed as soon as more events have been appended.
+func (l *MemoryLog) read(position int) ([]*kafka.Message, int, chan struct{}) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	i := sort.SearchInts(l.sequence, position)
+	return l.events[i:], l.length, l.appended
+}
+
+// highWatermarks returns the offsets following the most recent event of the given topics.
+// Unlike Kafka, a compacted MemoryLog may remove the most recent event of a topic, so these are the offsets
+// following the most recent event which is still present.
+func (l *MemoryLog) highWatermarks(topics map[string]core.Handler) []kafka.TopicPartition {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	latest := make(map[string]kafka.Offset)
+	for _, event := range l.events {
+		if _, ok := topics[*event.TopicPartition.Topic]; ok {
+			latest[*event.TopicPartition.Topic] = event.TopicPartition.Offset + 1
+		}
+	}
+	watermarks := make([]kafka.TopicPartition, 0, len(latest))
+	for topic, offset := range latest {
+		topic := topic
+		watermarks = append(watermarks, kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: offset})
+	}
+	return watermarks
+}
+
+// Compact removes every event that is superseded by a later event with the same key in the same topic,
+// as well as all tombstones, like log compaction in Kafka does once the tombstones' retention has passed.
+// Events without a key are kept. Offsets are retained, so the compacted topics have gaps.
+// Readers which have not consumed a tombstone before it is removed miss the deletion.
+func (l *MemoryLog) Compact() {
+	type topicKey struct {
+		topic string
+		key   string
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	latest := make(map[topicKey]int)
+	for i, event := range l.events {
+		if event.Key != nil {
+			latest[topicKey{*event.TopicPartition.Topic, string(event.Key)}] = i
+		}
+	}
+	events := make([]*kafka.Message, 0, len(latest))
+	sequence := make([]int, 0, len(latest))
+	for i, event := range l.events {
+		if event.Key != nil && (latest[topicKey{*event.TopicPartition.Topic, string(event.Key)}] != i || event.Value == nil) {
+			continue
+		}
+		events = append(events, event)
+		sequence = append(sequence, l.sequence[i])
+	}
+	l.events = events
+	l.sequence = sequence
+}
+
+// NewReader constructs a MemoryLogReader that consumes this log from the beginning.
+func (l *MemoryLog) NewReader() *MemoryLogReader {
+	return &MemoryLogReader{
+		log:      l,
+		handlers: make(map[string]core.Handler),
+		resume:   NewOffsetMap(),
+	}
+}
+
+// MemoryLogReader consumes events from a MemoryLog.
+// Unlike core.TopicRouter, events are handled one after another in the order they were appended.
+type MemoryLogReader struct {
+	log      *MemoryLog
+	handlers map[string]core.Handler
+	resume   *OffsetMap
+}
+
+func (r *MemoryLogReader) NewRoute(topic catchall.Key, handler core.Handler) {
+	r.handlers[topic.String()] = handler
+}
+
+func (r *MemoryLogReader) ResumeFrom(offsets []kafka.TopicPartition) {
+	r.resume.Set(offsets)
+}
+
+func (r *MemoryLogReader) HighWatermarks() ([]kafka.TopicPartition, error) {
+	return r.log.highWatermarks(r.handlers), nil
+}
+
+func (r *MemoryLogReader) Run() (chan bool, error) {
+	stop := make(chan bool, 1)
+	go (func() {
+		position := 0
+		for {
+			events, next, appended := r.log.read(position)
+			for _, event := range events {
+				r.handle(event)
+			}
+			position = next
+
+			select {
+			case <-stop:
+				return
+			case <-appended:
+			}
+		}
+	})()
+	return stop, nil
+}
+
+func (r *MemoryLogReader) handle(event *kafka.Message) {
+	handler, ok := r.handlers[*event.TopicPartition.Topic]
+	if !ok {
+		return
+	}
+	if resume, ok := r.resume.Get(*event.TopicPartition.Topic, 0); ok && event.TopicPartition.Offset < resume {
+		return
+	}
+	message := *event
+	err := handler(&message)
+	if err != nil {
+		log.Printf("!! Error in route handler: %v", err.Error())
+	}
+}
@@ -0,0 +1,207 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"context"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	"github.com/strangedev/catchall"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// produce appends a keyed message to the given topic of the log.
+func produce(t *testing.T, schemaLog *MemoryLog, topic string, key string, value string) {
+	message := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}}
+	if key != "" {
+		message.Key = []byte(key)
+	}
+	if value != "" {
+		message.Value = []byte(value)
+	}
+	if err := schemaLog.ProduceSync(message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMemoryLogOrdering checks that events are handled in the order they were appended, across topics,
+// and that every topic has its own offsets.
+func TestMemoryLogOrdering(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	if err := schemaLog.ProduceSync(&kafka.Message{Value: []byte("x")}); err == nil {
+		t.Error("expected producing a message without a topic to fail")
+	}
+	produce(t, schemaLog, "a", "", "1")
+	produce(t, schemaLog, "b", "", "2")
+	produce(t, schemaLog, "a", "", "3")
+
+	type handled struct {
+		topic  string
+		offset kafka.Offset
+		value  string
+	}
+	events := make(chan handled, 16)
+	reader := schemaLog.NewReader()
+	for _, topic := range []string{"a", "b"} {
+		reader.NewRoute(catchall.NewPlainKey(topic), func(message *kafka.Message) error {
+			events <- handled{*message.TopicPartition.Topic, message.TopicPartition.Offset, string(message.Value)}
+			return nil
+		})
+	}
+	watermarks, err := reader.HighWatermarks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedWatermarks := map[string]kafka.Offset{"a": 2, "b": 1}
+	for _, watermark := range watermarks {
+		if expectedWatermarks[*watermark.Topic] != watermark.Offset {
+			t.Errorf("expected the high-water mark of %v to be %v, got %v", *watermark.Topic, expectedWatermarks[*watermark.Topic], watermark.Offset)
+		}
+	}
+	if len(watermarks) != len(expectedWatermarks) {
+		t.Errorf("expected %v high-water marks, got %v", len(expectedWatermarks), watermarks)
+	}
+
+	// Events are skipped up to the offsets the reader resumes from
+	resume := "b"
+	reader.ResumeFrom([]kafka.TopicPartition{{Topic: &resume, Partition: 0, Offset: 1}})
+	stop, err := reader.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	// Events appended while the reader is running are handled as well
+	produce(t, schemaLog, "b", "", "4")
+
+	expected := []handled{{"a", 0, "1"}, {"a", 1, "3"}, {"b", 1, "4"}}
+	for _, e := range expected {
+		select {
+		case event := <-events:
+			if event != e {
+				t.Errorf("expected %v, got %v", e, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %v to be handled", e)
+		}
+	}
+}
+
+// TestMemoryLogCompact checks that compaction keeps the latest event of every key, removes tombstones
+// and retains offsets, so that readers keep their position.
+func TestMemoryLogCompact(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	produce(t, schemaLog, "a", "k", "1")
+	produce(t, schemaLog, "a", "", "unkeyed")
+	produce(t, schemaLog, "b", "k", "2")
+	produce(t, schemaLog, "a", "k", "3")
+	produce(t, schemaLog, "a", "deleted", "4")
+	produce(t, schemaLog, "a", "deleted", "")
+	_, position, _ := schemaLog.read(0)
+
+	schemaLog.Compact()
+	events, next, _ := schemaLog.read(0)
+	type compacted struct {
+		topic  string
+		offset kafka.Offset
+		value  string
+	}
+	remaining := make([]compacted, 0, len(events))
+	for _, event := range events {
+		remaining = append(remaining, compacted{*event.TopicPartition.Topic, event.TopicPartition.Offset, string(event.Value)})
+	}
+	expected := []compacted{{"a", 1, "unkeyed"}, {"b", 0, "2"}, {"a", 2, "3"}}
+	if !reflect.DeepEqual(remaining, expected) {
+		t.Errorf("expected %v after compaction, got %v", expected, remaining)
+	}
+	if next != position {
+		t.Errorf("expected compaction to retain the position %v, got %v", position, next)
+	}
+
+	produce(t, schemaLog, "a", "k", "5")
+	events, _, _ = schemaLog.read(position)
+	if len(events) != 1 || string(events[0].Value) != "5" || events[0].TopicPartition.Offset != 5 {
+		t.Errorf("expected a reader at %v to only read the appended event at offset 5, got %v", position, events)
+	}
+}
+
+// TestMemoryLogRoundTrip publishes schemata through an Updater and consumes them with LocalRepos,
+// one of which only starts once the log has been compacted.
+func TestMemoryLogRoundTrip(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	// The alias is published before its schema and then replaced, only the order of the events tells which one wins
+	schemaUUID, replacedUUID, lastUUID := uuid.New(), uuid.New(), uuid.New()
+	if err := updater.UpdateAlias("round-trip", replacedUUID); err != nil {
+		t.Fatal(err)
+	}
+	if err := updater.UpdateSchema(replacedUUID, `"int"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := updater.UpdateSchema(schemaUUID, `"string"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := updater.UpdateAlias("round-trip", schemaUUID); err != nil {
+		t.Fatal(err)
+	}
+	if err := updater.UpdateSchema(lastUUID, `"long"`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Since events are handled in order, all of them have been handled once the last one has
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := repo.AwaitSchema(ctx, lastUUID); err != nil {
+		t.Fatal(err)
+	}
+	message, err := repo.EncodeFramed(schemaUUID, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schemaLog.Compact()
+	compacted := NewLocalRepoWithLog(schemaLog.NewReader())
+	stopCompacted, err := compacted.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stopCompacted <- true
+	})()
+	if err := compacted.WaitCaughtUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, r := range map[string]LocalRepo{"running": repo, "compacted": compacted} {
+		if aliased, ok := r.WhoIs("round-trip"); !ok || aliased != schemaUUID {
+			t.Errorf("%v: expected the alias to refer to %v, got %v", name, schemaUUID, aliased)
+		}
+		decoded, writer, err := r.DecodeFramed(message)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if writer != schemaUUID || decoded != "x" {
+			t.Errorf("%v: expected %v written with %v, got %v written with %v", name, "x", schemaUUID, decoded, writer)
+		}
+	}
+}
@@ -10,63 +10,523 @@
 package kafka_schema
 
 import (
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/linkedin/goavro"
 	"github.com/strangedev/catchall"
+	"log"
+	"sort"
+	"sync"
 )
 
+// keyObservable implements catchall.KeyObservable.
+// Unlike catchall.ConcurrentObservable, it is only used by pointer, so that its lock is never copied.
+// Every observer is notified at most once and then forgotten, so notifying never blocks and never leaks an observer.
+// Observers which stop waiting before they are notified must be removed with Unobserve.
+type keyObservable struct {
+	observerLock sync.Mutex
+	observers    map[string][]chan bool
+}
+
+func (o *keyObservable) Observe(k catchall.Key) chan bool {
+	o.observerLock.Lock()
+	defer o.observerLock.Unlock()
+	// The channel is buffered, so that it can be notified even if no-one is receiving anymore
+	observer := make(chan bool, 1)
+	key := k.String()
+	o.observers[key] = append(o.observers[key], observer)
+	return observer
+}
+
+// Unobserve removes an observer which has not been notified yet.
+func (o *keyObservable) Unobserve(k catchall.Key, observer chan bool) {
+	o.observerLock.Lock()
+	defer o.observerLock.Unlock()
+	key := k.String()
+	observers := o.observers[key]
+	for i, other := range observers {
+		if other == observer {
+			observers = append(observers[:i], observers[i+1:]...)
+			break
+		}
+	}
+	if len(observers) == 0 {
+		delete(o.observers, key)
+	} else {
+		o.observers[key] = observers
+	}
+}
+
+func (o *keyObservable) Notify(k catchall.Key) {
+	o.observerLock.Lock()
+	defer o.observerLock.Unlock()
+	key := k.String()
+	for _, observer := range o.observers[key] {
+		observer <- true
+	}
+	delete(o.observers, key)
+}
+
+func newKeyObservable() keyObservable {
+	return keyObservable{observers: make(map[string][]chan bool)}
+}
+
 type aliasMapType map[Alias]uuid.UUID
 
-// SchemaMap is a KeyObservable map of Aliases to UUIDs
+// AliasMap is a KeyObservable map of Aliases to UUIDs.
+// It is safe for concurrent use, all access to the map goes through its methods.
 type AliasMap struct {
-	catchall.ConcurrentObservable
-	Map aliasMapType
+	keyObservable
+	// deletions notifies the observers of an alias' deletion, it is separate so that no alias can collide with it.
+	deletions keyObservable
+	DataLock  sync.RWMutex
+	aliases   aliasMapType
 }
 
-// Upsert inserts or updates an Alias, UUID pair into the map.
+// Insert inserts or updates an Alias, UUID pair into the map.
 // All of the map's observers are notified of this change.
 // It returns true, if the map entry did already exist and was overwritten.
-func (m AliasMap) Insert(alias Alias, schemaUUID uuid.UUID) bool {
+func (m *AliasMap) Insert(alias Alias, schemaUUID uuid.UUID) bool {
 	m.DataLock.Lock()
-	_, overwritten := m.Map[alias]
-	m.Map[alias] = schemaUUID
+	_, overwritten := m.aliases[alias]
+	m.aliases[alias] = schemaUUID
 	m.DataLock.Unlock()
 	m.Notify(alias)
 	return overwritten
 }
 
+// Delete removes an Alias from the map.
+// Observers of the alias' deletion are notified of this change.
+// It returns true, if the map entry did exist.
+func (m *AliasMap) Delete(alias Alias) bool {
+	m.DataLock.Lock()
+	_, existed := m.aliases[alias]
+	delete(m.aliases, alias)
+	m.DataLock.Unlock()
+	m.deletions.Notify(alias)
+	return existed
+}
+
+// ObserveDeletion returns a channel which receives once the given alias has been deleted.
+func (m *AliasMap) ObserveDeletion(alias Alias) chan bool {
+	return m.deletions.Observe(alias)
+}
+
+// UnobserveDeletion removes an observer returned by ObserveDeletion which has not been notified yet.
+func (m *AliasMap) UnobserveDeletion(alias Alias, observer chan bool) {
+	m.deletions.Unobserve(alias, observer)
+}
+
+// Get looks up the UUID of the given alias.
+func (m *AliasMap) Get(alias Alias) (uuid.UUID, bool) {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	schemaUUID, ok := m.aliases[alias]
+	return schemaUUID, ok
+}
+
+// Keys returns all aliases in the map.
+func (m *AliasMap) Keys() []Alias {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	aliases := make([]Alias, 0, len(m.aliases))
+	for alias := range m.aliases {
+		aliases = append(aliases, alias)
+	}
+	return aliases
+}
+
+// AliasesOf returns all aliases of the given UUID.
+func (m *AliasMap) AliasesOf(schemaUUID uuid.UUID) []Alias {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	aliases := make([]Alias, 0)
+	for alias, aliasUUID := range m.aliases {
+		if aliasUUID == schemaUUID {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
 // NewAliasMap constructs an empty AliasMap with no observers.
-func NewAliasMap() AliasMap {
-	return AliasMap{
-		ConcurrentObservable: catchall.NewConcurrentObservable(),
-		Map:                  make(aliasMapType),
+func NewAliasMap() *AliasMap {
+	return &AliasMap{
+		keyObservable: newKeyObservable(),
+		deletions:     newKeyObservable(),
+		aliases:       make(aliasMapType),
+	}
+}
+
+type versionMapType map[string][]uint
+
+// VersionMap is a KeyObservable index of names to the versions that are known for them.
+// Observers are keyed by name and notified whenever a new version of the name is inserted.
+// It is safe for concurrent use, all access to the index goes through its methods.
+type VersionMap struct {
+	keyObservable
+	DataLock sync.RWMutex
+	versions versionMapType
+}
+
+// Insert inserts a version into the index, keeping the versions of each name in ascending order.
+// All of the map's observers are notified of this change.
+// It returns true, if the version was already present.
+func (m *VersionMap) Insert(version NameVersion) bool {
+	m.DataLock.Lock()
+	versions := m.versions[version.Name]
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i] >= version.Version
+	})
+	present := i < len(versions) && versions[i] == version.Version
+	if !present {
+		versions = append(versions, 0)
+		copy(versions[i+1:], versions[i:])
+		versions[i] = version.Version
+		m.versions[version.Name] = versions
+	}
+	m.DataLock.Unlock()
+	if !present {
+		m.Notify(catchall.NewPlainKey(version.Name))
+	}
+	return present
+}
+
+// Remove removes a version from the index.
+// The observers of its name are not notified, since they wait for new versions only.
+// It returns true, if the version was present.
+func (m *VersionMap) Remove(version NameVersion) bool {
+	m.DataLock.Lock()
+	defer m.DataLock.Unlock()
+	versions := m.versions[version.Name]
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i] >= version.Version
+	})
+	present := i < len(versions) && versions[i] == version.Version
+	if !present {
+		return false
+	}
+	versions = append(versions[:i], versions[i+1:]...)
+	if len(versions) == 0 {
+		delete(m.versions, version.Name)
+	} else {
+		m.versions[version.Name] = versions
+	}
+	return true
+}
+
+// Latest returns the most recent version of the given name.
+func (m *VersionMap) Latest(name string) (NameVersion, bool) {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	versions := m.versions[name]
+	if len(versions) == 0 {
+		return NameVersion{}, false
+	}
+	return NameVersion{Name: name, Version: versions[len(versions)-1]}, true
+}
+
+// List returns all versions of the given name in ascending order.
+func (m *VersionMap) List(name string) []NameVersion {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	versions := make([]NameVersion, 0, len(m.versions[name]))
+	for _, version := range m.versions[name] {
+		versions = append(versions, NameVersion{Name: name, Version: version})
+	}
+	return versions
+}
+
+// Names returns all names which have at least one version, in ascending order.
+func (m *VersionMap) Names() []string {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	names := make([]string, 0, len(m.versions))
+	for name := range m.versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Next returns the version of the same name that succeeds the given version.
+// It returns false, if there is no known version succeeding it.
+func (m *VersionMap) Next(version NameVersion) (NameVersion, bool) {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	versions := m.versions[version.Name]
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i] > version.Version
+	})
+	if i == len(versions) {
+		return version, false
+	}
+	return NameVersion{Name: version.Name, Version: versions[i]}, true
+}
+
+// NewVersionMap constructs an empty VersionMap with no observers.
+func NewVersionMap() *VersionMap {
+	return &VersionMap{
+		keyObservable: newKeyObservable(),
+		versions:      make(versionMapType),
 	}
 }
 
 type schemaMapType map[uuid.UUID]*goavro.Codec
 
-// SchemaMap is a KeyObservable map of UUIDs to Avro codecs
+type fingerprintMapType map[uint64]uuid.UUID
+
+// SchemaMap is a KeyObservable map of UUIDs to Avro codecs.
+// It is safe for concurrent use, all access to the map goes through its methods.
 type SchemaMap struct {
-	catchall.ConcurrentObservable
-	Map schemaMapType
+	keyObservable
+	// deletions notifies the observers of a UUID's deletion.
+	deletions keyObservable
+	DataLock  sync.RWMutex
+	codecs    schemaMapType
+	// fingerprints indexes the UUIDs by the CRC-64-AVRO fingerprint of their schema.
+	// If several UUIDs share the same schema, the most recently upserted UUID is indexed.
+	fingerprints  fingerprintMapType
+	fingerprintOf map[uuid.UUID]uint64
+	parsed        map[uuid.UUID]*avroSchema
+	// ids are the integer IDs of the UUIDs, which are assigned by the Updater and read from the schema events.
+	// UUIDs upserted without an ID, e.g. from events written before IDs were part of them,
+	// are assigned the ID following the highest ID so far, in the order in which they are first upserted.
+	// So are UUIDs whose ID is already assigned to another UUID, which happens if updaters publish concurrently.
+	// Observers of idKey(id) are notified once an ID has been assigned.
+	// The IDs of hard-deleted UUIDs are retired, they are never assigned in order again.
+	ids    map[uuid.UUID]int32
+	byID   map[int32]uuid.UUID
+	lastID int32
+	// deleted are the soft-deleted UUIDs. Their codecs remain in the map, so that data written with them can still be decoded.
+	deleted map[uuid.UUID]bool
 }
 
 // Upsert inserts or updates a UUID, Codec pair into the map.
 // All of the map's observers are notified of this change.
 // It returns true, if the map entry did already exist and was overwritten.
-func (m SchemaMap) Upsert(schemaUUID uuid.UUID, codec *goavro.Codec) bool {
+func (m *SchemaMap) Upsert(schemaUUID uuid.UUID, codec *goavro.Codec) bool {
+	return m.upsert(schemaUUID, codec, 0)
+}
+
+// upsert inserts or updates a UUID, Codec pair into the map.
+// The UUID is assigned the given integer ID or, if the ID is 0 and the UUID is new, the ID following the highest ID so far.
+// An ID which is already assigned to another UUID is not reassigned, the UUID is assigned the ID following the highest ID
+// instead, so that repos consuming the same log in the same order agree on it.
+func (m *SchemaMap) upsert(schemaUUID uuid.UUID, codec *goavro.Codec, id int32) bool {
+	parsed, err := parseAvroSchema(codec.Schema())
+	if err != nil {
+		log.Printf("!! Unable to parse schema %v: %v", schemaUUID, err)
+	}
+
 	m.DataLock.Lock()
-	_, overwritten := m.Map[schemaUUID]
-	m.Map[schemaUUID] = codec
+	_, overwritten := m.codecs[schemaUUID]
+	m.codecs[schemaUUID] = codec
+	delete(m.deleted, schemaUUID)
+	previousID, known := m.ids[schemaUUID]
+	other, taken := m.byID[id]
+	collides := taken && other != schemaUUID
+	if collides {
+		log.Printf("!! ID %v of schema %v is assigned to schema %v, assigning another ID", id, schemaUUID, other)
+	}
+	if id == 0 || collides {
+		if known {
+			id = previousID
+		} else {
+			id = m.lastID + 1
+		}
+	}
+	assigned := id != previousID
+	if assigned {
+		if known {
+			delete(m.byID, previousID)
+		}
+		m.ids[schemaUUID] = id
+		m.byID[id] = schemaUUID
+		if id > m.lastID {
+			m.lastID = id
+		}
+	}
+	if previous, ok := m.fingerprintOf[schemaUUID]; ok && m.fingerprints[previous] == schemaUUID {
+		delete(m.fingerprints, previous)
+	}
+	delete(m.fingerprintOf, schemaUUID)
+	delete(m.parsed, schemaUUID)
+	if err == nil {
+		fingerprint := schemaFingerprint(parsed)
+		m.fingerprints[fingerprint] = schemaUUID
+		m.fingerprintOf[schemaUUID] = fingerprint
+		m.parsed[schemaUUID] = parsed
+	}
 	m.DataLock.Unlock()
 	m.Notify(schemaUUID)
+	if assigned {
+		m.Notify(idKey(id))
+	}
 	return overwritten
 }
 
+// Delete deletes a UUID from the map.
+// A soft-deleted UUID is no longer listed, but its codec, fingerprint and ID can still be looked up.
+// A hard-deleted UUID is removed entirely and its ID is retired.
+// Upserting a deleted UUID again restores it, a hard-deleted UUID is assigned a new ID.
+// Observers of the UUID's deletion are notified of this change.
+// It returns true, if the map entry did exist.
+func (m *SchemaMap) Delete(schemaUUID uuid.UUID, mode DeleteMode) bool {
+	m.DataLock.Lock()
+	_, existed := m.codecs[schemaUUID]
+	if mode == SoftDelete {
+		if existed {
+			m.deleted[schemaUUID] = true
+		}
+	} else {
+		delete(m.codecs, schemaUUID)
+		delete(m.deleted, schemaUUID)
+		if fingerprint, ok := m.fingerprintOf[schemaUUID]; ok && m.fingerprints[fingerprint] == schemaUUID {
+			delete(m.fingerprints, fingerprint)
+		}
+		delete(m.fingerprintOf, schemaUUID)
+		delete(m.parsed, schemaUUID)
+		if id, ok := m.ids[schemaUUID]; ok {
+			delete(m.byID, id)
+			delete(m.ids, schemaUUID)
+		}
+	}
+	m.DataLock.Unlock()
+	m.deletions.Notify(schemaUUID)
+	return existed
+}
+
+// ObserveDeletion returns a channel which receives once the given UUID has been deleted.
+func (m *SchemaMap) ObserveDeletion(schemaUUID uuid.UUID) chan bool {
+	return m.deletions.Observe(schemaUUID)
+}
+
+// UnobserveDeletion removes an observer returned by ObserveDeletion which has not been notified yet.
+func (m *SchemaMap) UnobserveDeletion(schemaUUID uuid.UUID, observer chan bool) {
+	m.deletions.Unobserve(schemaUUID, observer)
+}
+
+// IsDeleted returns true, if the given UUID has been soft-deleted.
+func (m *SchemaMap) IsDeleted(schemaUUID uuid.UUID) bool {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	return m.deleted[schemaUUID]
+}
+
+// Deleted returns all soft-deleted UUIDs in the map.
+func (m *SchemaMap) Deleted() []uuid.UUID {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	schemata := make([]uuid.UUID, 0, len(m.deleted))
+	for schemaUUID := range m.deleted {
+		schemata = append(schemata, schemaUUID)
+	}
+	return schemata
+}
+
+// Get looks up the codec of the given UUID.
+// Soft-deleted UUIDs can still be looked up.
+// Codecs are safe for concurrent use, so the codec may be used without holding any lock.
+func (m *SchemaMap) Get(schemaUUID uuid.UUID) (*goavro.Codec, bool) {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	codec, ok := m.codecs[schemaUUID]
+	return codec, ok
+}
+
+// Keys returns all UUIDs in the map, except for the soft-deleted ones.
+func (m *SchemaMap) Keys() []uuid.UUID {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	schemata := make([]uuid.UUID, 0, len(m.codecs))
+	for schemaUUID := range m.codecs {
+		if !m.deleted[schemaUUID] {
+			schemata = append(schemata, schemaUUID)
+		}
+	}
+	return schemata
+}
+
+// Len returns the number of UUIDs in the map, except for the soft-deleted ones.
+func (m *SchemaMap) Len() int {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	return len(m.codecs) - len(m.deleted)
+}
+
+// Fingerprint returns the CRC-64-AVRO fingerprint of the given UUID's schema.
+func (m *SchemaMap) Fingerprint(schemaUUID uuid.UUID) (uint64, bool) {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	fingerprint, ok := m.fingerprintOf[schemaUUID]
+	return fingerprint, ok
+}
+
+// WhoHas looks up the UUID of a schema by its CRC-64-AVRO fingerprint.
+func (m *SchemaMap) WhoHas(fingerprint uint64) (uuid.UUID, bool) {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	schemaUUID, ok := m.fingerprints[fingerprint]
+	return schemaUUID, ok
+}
+
+// idKey is the key under which observers of an integer ID are notified.
+func idKey(id int32) catchall.Key {
+	return catchall.NewPlainKey(fmt.Sprintf("#%d", id))
+}
+
+// ID returns the integer ID of the given UUID.
+func (m *SchemaMap) ID(schemaUUID uuid.UUID) (int32, bool) {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	id, ok := m.ids[schemaUUID]
+	return id, ok
+}
+
+// WhoHasID looks up the UUID of a schema by its integer ID.
+func (m *SchemaMap) WhoHasID(id int32) (uuid.UUID, bool) {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	schemaUUID, ok := m.byID[id]
+	return schemaUUID, ok
+}
+
+// LastID returns the highest ID which has been assigned, which may have been retired since.
+func (m *SchemaMap) LastID() int32 {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	return m.lastID
+}
+
+// retireIDs makes sure that no ID up to the given one is assigned again.
+func (m *SchemaMap) retireIDs(id int32) {
+	m.DataLock.Lock()
+	defer m.DataLock.Unlock()
+	if id > m.lastID {
+		m.lastID = id
+	}
+}
+
+// parsedSchema returns the parsed schema of the given UUID.
+func (m *SchemaMap) parsedSchema(schemaUUID uuid.UUID) (*avroSchema, bool) {
+	m.DataLock.RLock()
+	defer m.DataLock.RUnlock()
+	schema, ok := m.parsed[schemaUUID]
+	return schema, ok
+}
+
 // NewSchemaMap constructs an empty SchemaMap with no observers.
-func NewSchemaMap() SchemaMap {
-	return SchemaMap{
-		ConcurrentObservable: catchall.NewConcurrentObservable(),
-		Map:                  make(schemaMapType),
+func NewSchemaMap() *SchemaMap {
+	return &SchemaMap{
+		keyObservable: newKeyObservable(),
+		deletions:     newKeyObservable(),
+		codecs:        make(schemaMapType),
+		fingerprints:  make(fingerprintMapType),
+		fingerprintOf: make(map[uuid.UUID]uint64),
+		parsed:        make(map[uuid.UUID]*avroSchema),
+		ids:           make(map[uuid.UUID]int32),
+		byID:          make(map[int32]uuid.UUID),
+		deleted:       make(map[uuid.UUID]bool),
 	}
 }
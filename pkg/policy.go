@@ -0,0 +1,208 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+)
+
+// CompatibilityMap is a map of names to the compatibility level of their versions.
+type CompatibilityMap struct {
+	lock   sync.RWMutex
+	levels map[string]Compatibility
+}
+
+// Get returns the compatibility level of the given name.
+// If no level has been set for the name, DefaultCompatibility is returned.
+func (m *CompatibilityMap) Get(name string) Compatibility {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	level, ok := m.levels[name]
+	if !ok {
+		return DefaultCompatibility
+	}
+	return level
+}
+
+// Set sets the compatibility level of the given name.
+func (m *CompatibilityMap) Set(name string, level Compatibility) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.levels[name] = level
+}
+
+// Delete resets the compatibility level of the given name to DefaultCompatibility.
+func (m *CompatibilityMap) Delete(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.levels, name)
+}
+
+// All returns the compatibility levels of all names which have been assigned a level.
+func (m *CompatibilityMap) All() map[string]Compatibility {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	levels := make(map[string]Compatibility, len(m.levels))
+	for name, level := range m.levels {
+		levels[name] = level
+	}
+	return levels
+}
+
+// NewCompatibilityMap constructs an empty CompatibilityMap.
+func NewCompatibilityMap() *CompatibilityMap {
+	return &CompatibilityMap{levels: make(map[string]Compatibility)}
+}
+
+// PolicyViolation describes a versioned alias which was published in violation of the compatibility level of its name.
+type PolicyViolation struct {
+	Alias Alias
+	UUID  uuid.UUID
+	Level Compatibility
+	Err   error
+}
+
+func (v PolicyViolation) Error() string {
+	return fmt.Sprintf("%v (%v) violates compatibility level %v: %v", v.Alias, v.UUID, v.Level, v.Err)
+}
+
+func (v PolicyViolation) Unwrap() error {
+	return v.Err
+}
+
+// ViolationMap keeps track of the aliases which currently violate the compatibility level of their name.
+type ViolationMap struct {
+	lock       sync.RWMutex
+	violations map[Alias]PolicyViolation
+}
+
+// Flag records a violation, replacing any earlier violation of the same alias.
+func (m *ViolationMap) Flag(violation PolicyViolation) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.violations[violation.Alias] = violation
+}
+
+// Clear removes the violation of the given alias, if any.
+func (m *ViolationMap) Clear(alias Alias) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.violations, alias)
+}
+
+// Get returns the violation of the given alias, if any.
+func (m *ViolationMap) Get(alias Alias) (PolicyViolation, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	violation, ok := m.violations[alias]
+	return violation, ok
+}
+
+// List returns all violations, ordered by alias.
+func (m *ViolationMap) List() []PolicyViolation {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	violations := make([]PolicyViolation, 0, len(m.violations))
+	for _, violation := range m.violations {
+		violations = append(violations, violation)
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Alias < violations[j].Alias
+	})
+	return violations
+}
+
+// NewViolationMap constructs an empty ViolationMap.
+func NewViolationMap() *ViolationMap {
+	return &ViolationMap{violations: make(map[Alias]PolicyViolation)}
+}
+
+// GuardedUpdater is an Updater which refuses to publish versioned aliases that violate
+// the compatibility level of their name, as known to its LocalRepo.
+type GuardedUpdater struct {
+	Updater
+	repo LocalRepo
+	// published remembers the specifications published by this updater,
+	// since the repo may not have consumed them yet when the alias is published.
+	lock      sync.Mutex
+	published map[uuid.UUID]string
+}
+
+// NewGuardedUpdater wraps an Updater so that it checks versioned aliases against the
+// compatibility levels known to the given repo before publishing them.
+// The repo should be running, otherwise it does not know any earlier versions to check against.
+func NewGuardedUpdater(updater Updater, repo LocalRepo) *GuardedUpdater {
+	return &GuardedUpdater{
+		Updater:   updater,
+		repo:      repo,
+		published: make(map[uuid.UUID]string),
+	}
+}
+
+func (g *GuardedUpdater) UpdateSchema(schemaUUID uuid.UUID, specification string) error {
+	for _, alias := range g.repo.Aliases.AliasesOf(schemaUUID) {
+		if err := g.check(alias, specification); err != nil {
+			return err
+		}
+	}
+	err := g.Updater.UpdateSchema(schemaUUID, specification)
+	if err != nil {
+		return err
+	}
+	g.lock.Lock()
+	g.published[schemaUUID] = specification
+	g.lock.Unlock()
+	return nil
+}
+
+func (g *GuardedUpdater) UpdateAlias(alias string, schemaUUID uuid.UUID) error {
+	g.lock.Lock()
+	specification, ok := g.published[schemaUUID]
+	g.lock.Unlock()
+	if !ok {
+		specification, ok = g.repo.GetSpecification(schemaUUID)
+	}
+	if !ok {
+		return fmt.Errorf("schema %v is unknown, unable to check compatibility of %v", schemaUUID, alias)
+	}
+	if err := g.check(Alias(alias), specification); err != nil {
+		return err
+	}
+	return g.Updater.UpdateAlias(alias, schemaUUID)
+}
+
+func (g *GuardedUpdater) DeleteSchema(schemaUUID uuid.UUID, mode DeleteMode) error {
+	err := g.Updater.DeleteSchema(schemaUUID, mode)
+	if err != nil {
+		return err
+	}
+	g.lock.Lock()
+	delete(g.published, schemaUUID)
+	g.lock.Unlock()
+	return nil
+}
+
+// check checks a specification which is about to be published under the given alias.
+// Aliases that are not versioned are not subject to any compatibility level.
+func (g *GuardedUpdater) check(alias Alias, specification string) error {
+	version, err := VersionFromAlias(alias)
+	if err != nil {
+		return nil
+	}
+	level := g.repo.Compatibilities.Get(version.Name)
+	err = g.repo.CheckCompatibility(version, specification, level)
+	if err != nil {
+		return fmt.Errorf("refusing to publish %v with compatibility level %v: %w", alias, level, err)
+	}
+	return nil
+}
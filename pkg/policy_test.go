@@ -0,0 +1,137 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+)
+
+// TestGuardedUpdater checks that a GuardedUpdater refuses to publish versions violating the compatibility level of their name.
+func TestGuardedUpdater(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	guarded := NewGuardedUpdater(updater, repo)
+
+	// The MemoryLog is consumed in order, so the level is known once the first version is
+	if err := updater.UpdateCompatibility("guarded", CompatibilityBackward); err != nil {
+		t.Fatal(err)
+	}
+	first := NewVersionOrigin("guarded")
+	ready := repo.WaitVersionReady(first)
+	firstUUID := uuid.New()
+	if err := guarded.UpdateSchema(firstUUID, record(`{"name": "a", "type": "int"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := guarded.UpdateAlias(first.String(), firstUUID); err != nil {
+		t.Fatal(err)
+	}
+	<-ready
+
+	second := NameVersion{Name: "guarded", Version: 1}
+	incompatibleUUID := uuid.New()
+	if err := guarded.UpdateSchema(incompatibleUUID, record(`{"name": "a", "type": "int"}, {"name": "b", "type": "string"}`)); err != nil {
+		t.Fatalf("expected a schema without versioned aliases not to be checked, got %v", err)
+	}
+	if err := guarded.UpdateAlias(second.String(), incompatibleUUID); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("expected publishing an incompatible version to fail with %v, got %v", ErrIncompatible, err)
+	}
+	if err := guarded.UpdateAlias("unversioned", incompatibleUUID); err != nil {
+		t.Errorf("expected aliases which are not versioned not to be checked, got %v", err)
+	}
+
+	ready = repo.WaitVersionReady(second)
+	compatibleUUID := uuid.New()
+	if err := guarded.UpdateSchema(compatibleUUID, record(`{"name": "a", "type": "int"}, {"name": "b", "type": "string", "default": ""}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := guarded.UpdateAlias(second.String(), compatibleUUID); err != nil {
+		t.Fatalf("expected publishing a compatible version to succeed, got %v", err)
+	}
+	<-ready
+
+	// Updating the schema of a published version is checked against the version's predecessors
+	if err := guarded.UpdateSchema(compatibleUUID, record(`{"name": "a", "type": "string"}`)); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("expected updating a version's schema incompatibly to fail with %v, got %v", ErrIncompatible, err)
+	}
+	if err := guarded.UpdateAlias("guarded-v2", uuid.New()); err == nil || errors.Is(err, ErrIncompatible) {
+		t.Errorf("expected publishing a version of an unknown schema to fail, got %v", err)
+	}
+	if violations := repo.ListViolations(); len(violations) != 0 {
+		t.Errorf("expected no violations, got %v", violations)
+	}
+}
+
+// TestLocalRepoViolations checks that the repo flags versions which were published in violation of
+// the compatibility level of their name, e.g. by clients which do not check it.
+func TestLocalRepoViolations(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	if err := updater.UpdateCompatibility("flagged", CompatibilityFull); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(version NameVersion, specification string) uuid.UUID {
+		ready := repo.WaitVersionReady(version)
+		schemaUUID := uuid.New()
+		if err := updater.UpdateSchema(schemaUUID, specification); err != nil {
+			t.Fatal(err)
+		}
+		if err := updater.UpdateAlias(version.String(), schemaUUID); err != nil {
+			t.Fatal(err)
+		}
+		<-ready
+		return schemaUUID
+	}
+	publish(NewVersionOrigin("flagged"), record(`{"name": "a", "type": "int"}`))
+	second := NameVersion{Name: "flagged", Version: 1}
+	violating := publish(second, record(`{"name": "b", "type": "string"}`))
+	publish(NewVersionOrigin("unflagged"), record(`{"name": "a", "type": "int"}`))
+	publish(NameVersion{Name: "unflagged", Version: 1}, record(`{"name": "b", "type": "string"}`))
+
+	violations := repo.ListViolations()
+	if len(violations) != 1 {
+		t.Fatalf("expected exactly one violation, got %v", violations)
+	}
+	violation := violations[0]
+	if violation.Alias != second.Alias() || violation.UUID != violating || violation.Level != CompatibilityFull {
+		t.Errorf("expected %v (%v) to violate %v, got %v", second.Alias(), violating, CompatibilityFull, violation)
+	}
+	if !errors.Is(violation, ErrIncompatible) {
+		t.Errorf("expected the violation to wrap %v, got %v", ErrIncompatible, violation.Err)
+	}
+
+	// Deleting the violating version clears its violation,
+	// which has happened once a later event has been consumed
+	if err := updater.DeleteAlias(second.String(), SoftDelete); err != nil {
+		t.Fatal(err)
+	}
+	publish(NameVersion{Name: "unflagged", Version: 2}, record(`{"name": "c", "type": "long"}`))
+	if _, ok := repo.Violations.Get(second.Alias()); ok {
+		t.Errorf("expected the violation of %v to be cleared once it is deleted", second.Alias())
+	}
+}
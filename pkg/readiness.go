@@ -0,0 +1,155 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"context"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"sync"
+	"time"
+)
+
+// WatermarkReader is a SchemaLogReader which knows how far its topics extend.
+type WatermarkReader interface {
+	SchemaLogReader
+	// HighWatermarks returns the offsets following the most recent event of every partition of the routed topics.
+	// Partitions without any events are left out.
+	HighWatermarks() ([]kafka.TopicPartition, error)
+}
+
+// readiness tracks whether a repo has consumed every event which existed when it was started.
+type readiness struct {
+	lock     sync.Mutex
+	targets  []kafka.TopicPartition
+	known    bool
+	caughtUp chan struct{}
+	closed   bool
+}
+
+func newReadiness() *readiness {
+	return &readiness{caughtUp: make(chan struct{})}
+}
+
+// setTargets sets the offsets the repo needs to reach in order to be caught up.
+func (r *readiness) setTargets(targets []kafka.TopicPartition, offsets *OffsetMap) {
+	r.lock.Lock()
+	r.targets = targets
+	r.known = true
+	r.lock.Unlock()
+	r.check(offsets)
+}
+
+// check checks whether the consumed offsets have reached the targets.
+func (r *readiness) check(offsets *OffsetMap) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.known || r.closed {
+		return
+	}
+	for _, target := range r.targets {
+		if offset, ok := offsets.Get(*target.Topic, target.Partition); !ok || offset < target.Offset {
+			return
+		}
+	}
+	r.closed = true
+	close(r.caughtUp)
+}
+
+// Run starts consuming and records the high-water marks of the repo's topics,
+// so that Ready reports whether everything that existed at this point has been consumed.
+// If the SchemaLogReader is not a WatermarkReader, the repo is considered ready right away.
+// If the high-water marks cannot be queried, e.g. while the brokers are unreachable, the repo consumes nonetheless
+// and serves what it has restored or consumed so far. It is not ready until querying them succeeds in the background.
+func (repo LocalRepo) Run() (chan bool, error) {
+	reader, ok := repo.SchemaLogReader.(WatermarkReader)
+	if !ok {
+		repo.Logger.Printf("SchemaLogReader %v has no high-water marks, the repo is ready right away", repo.SchemaLogReader)
+		repo.readiness.setTargets(make([]kafka.TopicPartition, 0), repo.Offsets)
+		return repo.SchemaLogReader.Run()
+	}
+	targets, err := reader.HighWatermarks()
+	if err == nil {
+		repo.Logger.Printf("Catching up with %v", targets)
+		repo.readiness.setTargets(targets, repo.Offsets)
+		return repo.SchemaLogReader.Run()
+	}
+
+	repo.Logger.Printf("!! Unable to query high-water marks, the repo is not ready until they are known: %v", err)
+	stopReader, err := repo.SchemaLogReader.Run()
+	if err != nil {
+		return nil, err
+	}
+	stop := make(chan bool, 1)
+	stopped := make(chan struct{})
+	go (func() {
+		<-stop
+		close(stopped)
+		stopReader <- true
+	})()
+	go repo.retryWatermarks(reader, stopped)
+	return stop, nil
+}
+
+// watermarkRetryInterval is how long the repo waits before querying the high-water marks again after a failure.
+var watermarkRetryInterval = 5 * time.Second
+
+// retryWatermarks queries the high-water marks until it succeeds or the repo is stopped.
+func (repo LocalRepo) retryWatermarks(reader WatermarkReader, stopped chan struct{}) {
+	for {
+		select {
+		case <-stopped:
+			return
+		case <-time.After(watermarkRetryInterval):
+		}
+		targets, err := reader.HighWatermarks()
+		if err != nil {
+			repo.Logger.Printf("!! Unable to query high-water marks: %v", err)
+			continue
+		}
+		repo.Logger.Printf("Catching up with %v", targets)
+		repo.readiness.setTargets(targets, repo.Offsets)
+		return
+	}
+}
+
+// Ready returns true, once the repo has consumed every event which existed when it was started with Run().
+func (repo LocalRepo) Ready() bool {
+	select {
+	case <-repo.readiness.caughtUp:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitCaughtUp waits until the repo has consumed every event which existed when it was started with Run().
+// It returns the context's error, if the context is done before.
+func (repo LocalRepo) WaitCaughtUp(ctx context.Context) error {
+	select {
+	case <-repo.readiness.caughtUp:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// untilCaughtUp returns a context which is cancelled once the repo has caught up, see func LocalRepo.Ready.
+// Lookups waiting on it give up on entries which are missing from the log.
+func (repo LocalRepo) untilCaughtUp() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go (func() {
+		select {
+		case <-repo.readiness.caughtUp:
+			cancel()
+		case <-ctx.Done():
+		}
+	})()
+	return ctx, cancel
+}
@@ -13,6 +13,7 @@
 package kafka_schema
 
 import (
+	"context"
 	"github.com/google/uuid"
 )
 
@@ -29,11 +30,19 @@ func (a Alias) String() string {
 type Repo interface {
 	// Decode decodes a datum with the given avro schema
 	Decode(schema uuid.UUID, datum []byte) (interface{}, error)
+	// DecodeAs decodes a datum with the writer schema and projects it into the reader schema,
+	// following the schema resolution rules of the Avro specification.
+	DecodeAs(writer uuid.UUID, reader uuid.UUID, datum []byte) (interface{}, error)
 	// Encode encodes a datum with the given avro schema
 	Encode(schema uuid.UUID, datum interface{}) ([]byte, error)
+	// DecodeJSON decodes a datum with the given avro schema and returns it in the Avro JSON encoding.
+	DecodeJSON(schema uuid.UUID, datum []byte) ([]byte, error)
+	// EncodeJSON encodes a datum given in the Avro JSON encoding with the given avro schema.
+	EncodeJSON(schema uuid.UUID, datum []byte) ([]byte, error)
 	// WaitSchemaReady returns a channel that can be used to wait for a schema to become available.
 	// Since the schemata are stored in Kafka, it might take the underlying implementation
 	// a while until it has consumed all schema changes.
+	// The channel is only signalled once the schema is available, use func AwaitRepo.AwaitSchema to stop waiting earlier.
 	WaitSchemaReady(schema uuid.UUID) chan bool
 	// ListSchemata returns a slice of all uuids that are currently available.
 	// Note that this may not represent the actual state stored in Kafka, since there
@@ -46,6 +55,50 @@ type Repo interface {
 	Count() int
 }
 
+// FramedRepo provides encoding and decoding of framed messages, which identify their writer schema.
+// See FrameUUID and FrameSingleObject for the layout of framed messages.
+// LocalRepo is a FramedRepo.
+type FramedRepo interface {
+	// EncodeFramed encodes a datum with the given avro schema and prepends the framing header.
+	EncodeFramed(schema uuid.UUID, datum interface{}) ([]byte, error)
+	// DecodeFramed reads the writer schema from the framing header and decodes the datum with it.
+	// If the writer schema is not available yet, DecodeFramed waits for it while the repo is catching up
+	// with the schema log. Once the repo has caught up, an unknown writer schema is an error.
+	// It returns the decoded datum together with the UUID of the writer schema.
+	DecodeFramed(message []byte) (datum interface{}, writer uuid.UUID, err error)
+	// DecodeFramedContext works like DecodeFramed, but waits for an unknown writer schema until the context is done.
+	// This accommodates messages written with a schema that has just been published.
+	DecodeFramedContext(ctx context.Context, message []byte) (datum interface{}, writer uuid.UUID, err error)
+	// EncodeSingleObject encodes a datum with the given avro schema using the Avro single-object encoding.
+	EncodeSingleObject(schema uuid.UUID, datum interface{}) ([]byte, error)
+	// DecodeSingleObject decodes a message in the Avro single-object encoding,
+	// looking up the writer schema by its fingerprint.
+	// It returns the decoded datum together with the UUID of the writer schema.
+	DecodeSingleObject(message []byte) (datum interface{}, writer uuid.UUID, err error)
+}
+
+// IDRepo provides access to schemata by their integer IDs and encoding and decoding of messages framed with them.
+// LocalRepo is an IDRepo.
+type IDRepo interface {
+	// SchemaID returns the integer ID of the given schema.
+	// IDs are assigned by the Updater and are part of the schema events,
+	// so all IDRepo implementations consuming the same log agree on them, even if it has been compacted.
+	SchemaID(schema uuid.UUID) (int32, bool)
+	// WhoHasID looks up a schema by its integer ID.
+	WhoHasID(id int32) (uuid.UUID, bool)
+	// LastID returns the highest integer ID which has been assigned to any schema, including hard-deleted ones.
+	LastID() int32
+	// EncodeCompact encodes a datum with the given avro schema and prepends the header of the compact framing,
+	// which identifies the schema by its integer ID. See FrameID for the layout.
+	EncodeCompact(schema uuid.UUID, datum interface{}) ([]byte, error)
+	// DecodeCompact reads the writer schema's integer ID from the compact framing header and decodes the datum with it.
+	// If the writer schema is not available yet, DecodeCompact waits for it like func FramedRepo.DecodeFramed.
+	// It returns the decoded datum together with the UUID of the writer schema.
+	DecodeCompact(message []byte) (datum interface{}, writer uuid.UUID, err error)
+	// DecodeCompactContext works like DecodeCompact, but waits for an unknown writer schema until the context is done.
+	DecodeCompactContext(ctx context.Context, message []byte) (datum interface{}, writer uuid.UUID, err error)
+}
+
 // AliasRepo provides high-level access to schemata by their aliases
 type AliasRepo interface {
 	// WhoIs looks up an alias and returns the associated schema's uuid
@@ -64,9 +117,71 @@ type AliasRepo interface {
 type VersionedRepo interface {
 	// DecodeVersion decodes a datum using the specified schema at the specified version.
 	DecodeVersion(schema NameVersion, datum []byte) (interface{}, error)
+	// DecodeVersionAs decodes a datum written with the writer version and projects it into the reader version.
+	// This works analogous to func Repo.DecodeAs.
+	DecodeVersionAs(writer NameVersion, reader NameVersion, datum []byte) (interface{}, error)
 	// EncodeVersion encodes a datum using the specified schema at the specified version.
 	EncodeVersion(schema NameVersion, datum interface{}) ([]byte, error)
+	// DecodeVersionJSON decodes a datum using the specified schema at the specified version and returns it in the Avro JSON encoding.
+	// This works analogous to func Repo.DecodeJSON.
+	DecodeVersionJSON(schema NameVersion, datum []byte) ([]byte, error)
+	// EncodeVersionJSON encodes a datum given in the Avro JSON encoding using the specified schema at the specified version.
+	// This works analogous to func Repo.EncodeJSON.
+	EncodeVersionJSON(schema NameVersion, datum []byte) ([]byte, error)
 	// WaitVersionReady returns a channel that can be used to wait for a schema to become available in the specified version.
 	// This works analogous to func Repo.WaitSchemaReady.
 	WaitVersionReady(schema NameVersion) chan bool
+	// WaitLatestVersionReady returns a channel that can be used to wait for any version of a name to become available.
+	// This works analogous to func Repo.WaitSchemaReady.
+	WaitLatestVersionReady(name string) chan bool
+	// LatestVersion returns the most recent version of the given name.
+	// Note that this may not represent the actual state stored in Kafka, analogous to func Repo.ListSchemata.
+	LatestVersion(name string) (NameVersion, bool)
+	// ListVersions returns all versions of the given name in ascending order.
+	ListVersions(name string) []NameVersion
+	// GetNext returns the version succeeding the given version.
+	// It returns false, if the given version is the most recent version.
+	GetNext(schema NameVersion) (NameVersion, bool)
+}
+
+// PolicyRepo provides access to the compatibility levels of versioned schemata.
+// LocalRepo is a PolicyRepo.
+type PolicyRepo interface {
+	// CheckCompatibility checks whether the specification may be published as the specified version,
+	// by comparing it with earlier versions according to the given compatibility level.
+	// Earlier versions that are not available are skipped.
+	// Violations are reported by an error wrapping ErrIncompatible.
+	CheckCompatibility(schema NameVersion, specification string, level Compatibility) error
+	// GetCompatibility returns the compatibility level of all versions of the given name.
+	GetCompatibility(name string) Compatibility
+	// ListViolations returns all versioned aliases which have been published in violation of
+	// the compatibility level of their name.
+	ListViolations() []PolicyViolation
+}
+
+// AwaitRepo provides waiting for schemata, aliases and versions which can be given up on.
+// LocalRepo is an AwaitRepo.
+type AwaitRepo interface {
+	// AwaitSchema waits until a schema becomes available.
+	// It returns the context's error, if the context is cancelled or its deadline passes before.
+	AwaitSchema(ctx context.Context, schema uuid.UUID) error
+	// AwaitAlias waits until an alias and the associated schema become available.
+	// This works analogous to AwaitSchema.
+	AwaitAlias(ctx context.Context, alias Alias) error
+	// AwaitVersion waits until a schema becomes available in the specified version.
+	// This works analogous to AwaitSchema.
+	AwaitVersion(ctx context.Context, schema NameVersion) error
+	// AwaitLatestVersion waits until any version of a name becomes available and returns the most recent one.
+	// This works analogous to AwaitSchema.
+	AwaitLatestVersion(ctx context.Context, name string) (NameVersion, error)
+}
+
+// ReadyRepo reports whether a repo has caught up with the schema log.
+// LocalRepo is a ReadyRepo.
+type ReadyRepo interface {
+	// Ready returns true, once the repo has consumed every event which existed when it was started.
+	Ready() bool
+	// WaitCaughtUp waits until the repo has consumed every event which existed when it was started.
+	// It returns the context's error, if the context is done before.
+	WaitCaughtUp(ctx context.Context) error
 }
@@ -0,0 +1,314 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// resolveNative projects a datum in goavro's native form, decoded with the writer schema,
+// into the native form of the reader schema, following the schema resolution rules of the Avro specification:
+// Fields unknown to the reader are dropped, fields unknown to the writer are set to their default,
+// fields and named types are matched by their aliases and numeric types are promoted.
+func resolveNative(reader *avroSchema, writer *avroSchema, datum interface{}) (interface{}, error) {
+	r := resolution{check: readabilityCheck{inProgress: make(map[[2]*avroSchema]bool)}}
+	return r.resolve(reader, writer, datum, "")
+}
+
+type resolution struct {
+	check readabilityCheck
+}
+
+func (r resolution) resolve(reader *avroSchema, writer *avroSchema, datum interface{}, path string) (interface{}, error) {
+	if writer.Type == "union" {
+		branch, value, err := unionBranch(writer, datum)
+		if err != nil {
+			return nil, incompatible(path, "%v", err)
+		}
+		return r.resolve(reader, branch, value, path)
+	}
+	if reader.Type == "union" {
+		branch, ok := r.readerBranch(reader, writer, path)
+		if !ok {
+			return nil, incompatible(path, "reader union has no branch that can read writer type %s", writer.typeName())
+		}
+		resolved, err := r.resolve(branch, writer, datum, path)
+		if err != nil || resolved == nil {
+			return nil, err
+		}
+		return map[string]interface{}{branch.typeName(): resolved}, nil
+	}
+
+	if reader.Type != writer.Type {
+		return promote(reader, writer, datum, path)
+	}
+
+	switch reader.Type {
+	case "record":
+		return r.resolveRecord(reader, writer, datum, path)
+	case "enum":
+		symbol, ok := datum.(string)
+		if !ok {
+			return nil, incompatible(path, "enum datum ought to be a string, received %T", datum)
+		}
+		if containsString(reader.Symbols, symbol) {
+			return symbol, nil
+		}
+		if reader.HasEnumDefault {
+			return reader.EnumDefault, nil
+		}
+		return nil, incompatible(path, "enum %s lacks symbol %s and has no default", reader.Name, symbol)
+	case "array":
+		items, ok := datum.([]interface{})
+		if !ok {
+			return nil, incompatible(path, "array datum ought to be []interface{}, received %T", datum)
+		}
+		resolved := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if resolved[i], err = r.resolve(reader.Items, writer.Items, item, path+"[]"); err != nil {
+				return nil, err
+			}
+		}
+		return resolved, nil
+	case "map":
+		values, ok := datum.(map[string]interface{})
+		if !ok {
+			return nil, incompatible(path, "map datum ought to be map[string]interface{}, received %T", datum)
+		}
+		resolved := make(map[string]interface{}, len(values))
+		for key, value := range values {
+			var err error
+			if resolved[key], err = r.resolve(reader.Values, writer.Values, value, path+"{}"); err != nil {
+				return nil, err
+			}
+		}
+		return resolved, nil
+	}
+	return datum, nil
+}
+
+// readerBranch selects the branch of a reader union which reads the writer type.
+// A branch of the same type, and name for named types, is preferred over the first branch that the writer type can be promoted to.
+func (r resolution) readerBranch(reader *avroSchema, writer *avroSchema, path string) (*avroSchema, bool) {
+	for _, branch := range reader.Branches {
+		if branch.Type == writer.Type && (!branch.isNamed() || namesMatch(branch, writer)) && r.check.check(branch, writer, path) == nil {
+			return branch, true
+		}
+	}
+	for _, branch := range reader.Branches {
+		if r.check.check(branch, writer, path) == nil {
+			return branch, true
+		}
+	}
+	return nil, false
+}
+
+func (r resolution) resolveRecord(reader *avroSchema, writer *avroSchema, datum interface{}, path string) (interface{}, error) {
+	if !namesMatch(reader, writer) {
+		return nil, incompatible(path, "record name %s does not match %s", reader.Name, writer.Name)
+	}
+	fields, ok := datum.(map[string]interface{})
+	if !ok {
+		return nil, incompatible(path, "record datum ought to be map[string]interface{}, received %T", datum)
+	}
+
+	resolved := make(map[string]interface{}, len(reader.Fields))
+	for _, readerField := range reader.Fields {
+		fieldPath := path + "." + readerField.Name
+		writerField, ok := writer.findField(readerField)
+		if !ok {
+			if !readerField.HasDefault {
+				return nil, incompatible(fieldPath, "field is missing from writer and has no default")
+			}
+			value, err := nativeFromDefault(readerField.Type, readerField.Default)
+			if err != nil {
+				return nil, fmt.Errorf("invalid default at %s: %v", fieldPath, err)
+			}
+			resolved[readerField.Name] = value
+			continue
+		}
+		value, err := r.resolve(readerField.Type, writerField.Type, fields[writerField.Name], fieldPath)
+		if err != nil {
+			return nil, err
+		}
+		resolved[readerField.Name] = value
+	}
+	return resolved, nil
+}
+
+// unionBranch finds the union member a datum in goavro's native form belongs to,
+// returning the member together with the unwrapped datum.
+func unionBranch(union *avroSchema, datum interface{}) (*avroSchema, interface{}, error) {
+	if datum == nil {
+		for _, branch := range union.Branches {
+			if branch.Type == "null" {
+				return branch, nil, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("union has no null member")
+	}
+	wrapped, ok := datum.(map[string]interface{})
+	if !ok || len(wrapped) != 1 {
+		return nil, nil, fmt.Errorf("union datum ought to be a map with a single key, received %T", datum)
+	}
+	for name, value := range wrapped {
+		for _, branch := range union.Branches {
+			if branch.typeName() == name {
+				return branch, value, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("union has no member %s", name)
+	}
+	return nil, nil, nil
+}
+
+// promote converts a datum of the writer's primitive type to the reader's primitive type.
+func promote(reader *avroSchema, writer *avroSchema, datum interface{}, path string) (interface{}, error) {
+	if !isPromotable(writer.Type, reader.Type) {
+		return nil, incompatible(path, "writer type %s cannot be read as %s", writer.typeName(), reader.typeName())
+	}
+	switch value := datum.(type) {
+	case int32:
+		switch reader.Type {
+		case "long":
+			return int64(value), nil
+		case "float":
+			return float32(value), nil
+		case "double":
+			return float64(value), nil
+		}
+	case int64:
+		switch reader.Type {
+		case "float":
+			return float32(value), nil
+		case "double":
+			return float64(value), nil
+		}
+	case float32:
+		return float64(value), nil
+	case string:
+		return []byte(value), nil
+	case []byte:
+		return string(value), nil
+	}
+	return nil, incompatible(path, "cannot promote %T from %s to %s", datum, writer.Type, reader.Type)
+}
+
+// nativeFromDefault converts the JSON default value of a field into goavro's native form.
+// The default value of a union corresponds to its first member.
+func nativeFromDefault(schema *avroSchema, value interface{}) (interface{}, error) {
+	switch schema.Type {
+	case "union":
+		first := schema.Branches[0]
+		native, err := nativeFromDefault(first, value)
+		if err != nil || first.Type == "null" {
+			return nil, err
+		}
+		return map[string]interface{}{first.typeName(): native}, nil
+	case "null":
+		if value != nil {
+			return nil, fmt.Errorf("null default ought to be null, received %v", value)
+		}
+		return nil, nil
+	case "boolean":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("boolean default ought to be a boolean, received %v", value)
+		}
+		return b, nil
+	case "int", "long", "float", "double":
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%s default ought to be a number, received %v", schema.Type, value)
+		}
+		return nativeFromNumber(schema.Type, number)
+	case "bytes", "fixed":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s default ought to be a string, received %v", schema.Type, value)
+		}
+		// Each code point of the string represents one byte
+		b := make([]byte, 0, len(s))
+		for _, r := range s {
+			b = append(b, byte(r))
+		}
+		return b, nil
+	case "string", "enum":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s default ought to be a string, received %v", schema.Type, value)
+		}
+		return s, nil
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("array default ought to be an array, received %v", value)
+		}
+		native := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if native[i], err = nativeFromDefault(schema.Items, item); err != nil {
+				return nil, err
+			}
+		}
+		return native, nil
+	case "map":
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("map default ought to be an object, received %v", value)
+		}
+		native := make(map[string]interface{}, len(values))
+		for key, item := range values {
+			var err error
+			if native[key], err = nativeFromDefault(schema.Values, item); err != nil {
+				return nil, err
+			}
+		}
+		return native, nil
+	case "record":
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record default ought to be an object, received %v", value)
+		}
+		native := make(map[string]interface{}, len(schema.Fields))
+		for _, field := range schema.Fields {
+			fieldValue, ok := values[field.Name]
+			if !ok {
+				if !field.HasDefault {
+					return nil, fmt.Errorf("record default lacks field %s", field.Name)
+				}
+				fieldValue = field.Default
+			}
+			var err error
+			if native[field.Name], err = nativeFromDefault(field.Type, fieldValue); err != nil {
+				return nil, err
+			}
+		}
+		return native, nil
+	}
+	return nil, fmt.Errorf("unknown type %s", schema.Type)
+}
+
+func nativeFromNumber(typeName string, number json.Number) (interface{}, error) {
+	switch typeName {
+	case "int":
+		value, err := number.Int64()
+		return int32(value), err
+	case "long":
+		return number.Int64()
+	case "float":
+		value, err := number.Float64()
+		return float32(value), err
+	default:
+		return number.Float64()
+	}
+}
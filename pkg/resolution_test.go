@@ -0,0 +1,193 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// publishSchema publishes a schema without an alias and waits until the repo knows it.
+func publishSchema(t *testing.T, updater Updater, repo LocalRepo, specification string) uuid.UUID {
+	schemaUUID := uuid.New()
+	if err := updater.UpdateSchema(schemaUUID, specification); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := repo.AwaitSchema(ctx, schemaUUID); err != nil {
+		t.Fatal(err)
+	}
+	return schemaUUID
+}
+
+// TestLocalRepoDecodeAs decodes data written with one schema as another schema.
+func TestLocalRepoDecodeAs(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	enum := `{"type": "enum", "name": "e", "symbols": ["A", "B"]}`
+	cases := []struct {
+		name     string
+		writer   string
+		reader   string
+		datum    interface{}
+		expected interface{}
+	}{
+		{
+			"reader defaults",
+			record(`{"name": "a", "type": "int"}`),
+			record(`{"name": "a", "type": "int"}, {"name": "b", "type": "string", "default": "x"},
+				{"name": "c", "type": ["null", "long"], "default": null}, {"name": "d", "type": ["long", "null"], "default": 5},
+				{"name": "e", "type": {"type": "array", "items": "int"}, "default": [1, 2]}`),
+			map[string]interface{}{"a": int32(1)},
+			map[string]interface{}{"a": int32(1), "b": "x", "c": nil, "d": map[string]interface{}{"long": int64(5)}, "e": []interface{}{int32(1), int32(2)}},
+		},
+		{
+			"removed field",
+			record(`{"name": "a", "type": "int"}, {"name": "gone", "type": "string"}`),
+			record(`{"name": "a", "type": "int"}`),
+			map[string]interface{}{"a": int32(1), "gone": "x"},
+			map[string]interface{}{"a": int32(1)},
+		},
+		{
+			"renamed field",
+			record(`{"name": "old", "type": "long"}`),
+			record(`{"name": "new", "type": "long", "aliases": ["old"]}`),
+			map[string]interface{}{"old": int64(2)},
+			map[string]interface{}{"new": int64(2)},
+		},
+		{
+			"renamed record",
+			record(`{"name": "a", "type": "int"}`),
+			`{"type": "record", "name": "s", "aliases": ["r"], "fields": [{"name": "a", "type": "int"}]}`,
+			map[string]interface{}{"a": int32(1)},
+			map[string]interface{}{"a": int32(1)},
+		},
+		{
+			"numeric promotions",
+			record(`{"name": "i", "type": "int"}, {"name": "l", "type": "long"}, {"name": "f", "type": "float"},
+				{"name": "s", "type": "string"}, {"name": "b", "type": "bytes"}`),
+			record(`{"name": "i", "type": "double"}, {"name": "l", "type": "float"}, {"name": "f", "type": "double"},
+				{"name": "s", "type": "bytes"}, {"name": "b", "type": "string"}`),
+			map[string]interface{}{"i": int32(1), "l": int64(2), "f": float32(1.5), "s": "x", "b": []byte("y")},
+			map[string]interface{}{"i": float64(1), "l": float32(2), "f": float64(1.5), "s": []byte("x"), "b": "y"},
+		},
+		{
+			"promoted items and values",
+			record(`{"name": "a", "type": {"type": "array", "items": "int"}}, {"name": "m", "type": {"type": "map", "values": "int"}}`),
+			record(`{"name": "a", "type": {"type": "array", "items": "long"}}, {"name": "m", "type": {"type": "map", "values": "double"}}`),
+			map[string]interface{}{"a": []interface{}{int32(1)}, "m": map[string]interface{}{"k": int32(2)}},
+			map[string]interface{}{"a": []interface{}{int64(1)}, "m": map[string]interface{}{"k": float64(2)}},
+		},
+		{
+			"non-union into union",
+			record(`{"name": "a", "type": "string"}`),
+			record(`{"name": "a", "type": ["null", "string"]}`),
+			map[string]interface{}{"a": "x"},
+			map[string]interface{}{"a": map[string]interface{}{"string": "x"}},
+		},
+		{
+			"identical union branch preferred over promotion",
+			`"int"`,
+			`["long", "int"]`,
+			int32(1),
+			map[string]interface{}{"int": int32(1)},
+		},
+		{
+			"promoted union member",
+			record(`{"name": "a", "type": ["null", "int"]}, {"name": "b", "type": ["null", "int"]}`),
+			record(`{"name": "a", "type": ["null", "long"]}, {"name": "b", "type": ["null", "long"]}`),
+			map[string]interface{}{"a": map[string]interface{}{"int": int32(3)}, "b": nil},
+			map[string]interface{}{"a": map[string]interface{}{"long": int64(3)}, "b": nil},
+		},
+		{
+			"union member into non-union",
+			`["null", "string"]`,
+			`"string"`,
+			map[string]interface{}{"string": "x"},
+			"x",
+		},
+		{
+			"unknown enum symbol with default",
+			enum,
+			`{"type": "enum", "name": "e", "symbols": ["A"], "default": "A"}`,
+			"B",
+			"A",
+		},
+		{
+			"known enum symbol",
+			enum,
+			`{"type": "enum", "name": "e", "symbols": ["A"]}`,
+			"A",
+			"A",
+		},
+	}
+	for _, c := range cases {
+		writer, reader := publishSchema(t, updater, repo, c.writer), publishSchema(t, updater, repo, c.reader)
+		encoded, err := repo.Encode(writer, c.datum)
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		decoded, err := repo.DecodeAs(writer, reader, encoded)
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, c.expected) {
+			t.Errorf("%v: expected %#v, got %#v", c.name, c.expected, decoded)
+		}
+	}
+
+	failures := []struct {
+		name   string
+		writer string
+		reader string
+		datum  interface{}
+	}{
+		{"missing field without default", record(`{"name": "a", "type": "int"}`), record(`{"name": "a", "type": "int"}, {"name": "b", "type": "string"}`), map[string]interface{}{"a": int32(1)}},
+		{"unknown enum symbol", enum, `{"type": "enum", "name": "e", "symbols": ["A"]}`, "B"},
+		{"null into non-union", `["null", "string"]`, `"string"`, nil},
+		{"unpromotable type", `"string"`, `"int"`, "x"},
+		{"narrowed type", `"long"`, `"int"`, int64(1)},
+		{"renamed record", record(`{"name": "a", "type": "int"}`), `{"type": "record", "name": "s", "fields": [{"name": "a", "type": "int"}]}`, map[string]interface{}{"a": int32(1)}},
+	}
+	for _, c := range failures {
+		writer, reader := publishSchema(t, updater, repo, c.writer), publishSchema(t, updater, repo, c.reader)
+		encoded, err := repo.Encode(writer, c.datum)
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		if decoded, err := repo.DecodeAs(writer, reader, encoded); !errors.Is(err, ErrIncompatible) {
+			t.Errorf("%v: expected decoding to fail with %v, got %v (%v)", c.name, ErrIncompatible, decoded, err)
+		}
+	}
+
+	writer := publishSchema(t, updater, repo, `"string"`)
+	encoded, err := repo.Encode(writer, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.DecodeAs(writer, uuid.New(), encoded); err == nil {
+		t.Error("expected decoding as an unknown schema to fail")
+	}
+}
@@ -0,0 +1,189 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/strangedev/catchall"
+	core "github.com/strangedev/kafka-golang/pkg"
+)
+
+// SchemaLogReader is an event log from which schema events are consumed.
+// LocalRepo registers one route per topic and starts consuming with Run().
+// core.TopicRouter is the Kafka implementation, MemoryLogReader the in-process one.
+type SchemaLogReader interface {
+	core.Consumer
+	// NewRoute registers a handler for all events of the given topic.
+	NewRoute(topic catchall.Key, handler core.Handler)
+}
+
+// ResumableLogReader is a SchemaLogReader which is able to resume consuming at given offsets,
+// e.g. after LocalRepo has restored its state from a snapshot.
+type ResumableLogReader interface {
+	SchemaLogReader
+	// ResumeFrom makes the reader start consuming the given partitions at the given offsets once it is run.
+	// Partitions which are not given are consumed from the beginning.
+	ResumeFrom(offsets []kafka.TopicPartition)
+}
+
+// SchemaLogWriter is an event log into which schema events are written.
+// The Updater writes all of its events through a SchemaLogWriter.
+// KafkaLogWriter is the Kafka implementation, MemoryLog the in-process one.
+type SchemaLogWriter interface {
+	core.LowLevelProducer
+}
+
+// KafkaLogWriter is a SchemaLogWriter which produces into Kafka.
+// Unlike core.Producer, it does not print anything and returns the delivery error of every message,
+// so that callers can tell whether an event has been written.
+type KafkaLogWriter struct {
+	Producer *kafka.Producer
+}
+
+// NewKafkaLogWriter constructs a KafkaLogWriter which produces into the specified Kafka broker.
+func NewKafkaLogWriter(broker string) (*KafkaLogWriter, error) {
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": broker})
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaLogWriter{Producer: producer}, nil
+}
+
+// ProduceSync produces a message and waits until it has been delivered.
+func (w *KafkaLogWriter) ProduceSync(message *kafka.Message) error {
+	delivery := make(chan kafka.Event, 1)
+	err := w.Producer.Produce(message, delivery)
+	if err != nil {
+		// The message has not been enqueued, so there will be no delivery report to wait for
+		return err
+	}
+	event := <-delivery
+	delivered, ok := event.(*kafka.Message)
+	if !ok {
+		return fmt.Errorf("unexpected delivery report %v", event)
+	}
+	return delivered.TopicPartition.Error
+}
+
+// ProduceSimpleSync produces a message without headers or key into the given topic and partition.
+func (w *KafkaLogWriter) ProduceSimpleSync(topic string, partition int32, value []byte) error {
+	return w.ProduceSync(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
+		Value:          value,
+	})
+}
+
+// KafkaLogReader is a ResumableLogReader which consumes from Kafka.
+// Unlike core.TopicRouter, events are handled one after another in the order they were consumed,
+// so that the consumed offsets always reflect the state of the consuming LocalRepo.
+type KafkaLogReader struct {
+	core.TopicRouter
+	resume *OffsetMap
+	logger Logger
+}
+
+// NewKafkaLogReader constructs a KafkaLogReader which consumes from the specified Kafka broker.
+// Every reader uses its own consumer group, so it always consumes all events from the beginning,
+// unless it is told to resume elsewhere with ResumeFrom.
+// Use NewLocalRepoWithConfig to configure the consumer.
+func NewKafkaLogReader(broker string) (*KafkaLogReader, error) {
+	config := defaultConsumerConfig(broker)
+	consumer, err := kafka.NewConsumer(&config)
+	if err != nil {
+		return nil, err
+	}
+	return newKafkaLogReader(consumer, stdLogger{}), nil
+}
+
+func newKafkaLogReader(consumer *kafka.Consumer, logger Logger) *KafkaLogReader {
+	return &KafkaLogReader{
+		TopicRouter: core.NewTopicRouter(consumer),
+		resume:      NewOffsetMap(),
+		logger:      logger,
+	}
+}
+
+func (r *KafkaLogReader) ResumeFrom(offsets []kafka.TopicPartition) {
+	r.resume.Set(offsets)
+}
+
+// rebalance moves all assigned partitions to the offsets given to ResumeFrom.
+func (r *KafkaLogReader) rebalance(consumer *kafka.Consumer, event kafka.Event) error {
+	switch e := event.(type) {
+	case kafka.AssignedPartitions:
+		partitions := make([]kafka.TopicPartition, 0, len(e.Partitions))
+		for _, partition := range e.Partitions {
+			if offset, ok := r.resume.Get(*partition.Topic, partition.Partition); ok {
+				partition.Offset = offset
+			}
+			partitions = append(partitions, partition)
+		}
+		r.logger.Printf("Assigned partitions %v", partitions)
+		return consumer.Assign(partitions)
+	case kafka.RevokedPartitions:
+		return consumer.Unassign()
+	}
+	return nil
+}
+
+// watermarkTimeout is how long the KafkaLogReader waits for the brokers when querying high-water marks, in milliseconds.
+const watermarkTimeout = 10000
+
+func (r *KafkaLogReader) HighWatermarks() ([]kafka.TopicPartition, error) {
+	watermarks := make([]kafka.TopicPartition, 0)
+	for _, topic := range r.Topics() {
+		topic := topic
+		metadata, err := r.Consumer.GetMetadata(&topic, false, watermarkTimeout)
+		if err != nil {
+			return nil, err
+		}
+		topicMetadata, ok := metadata.Topics[topic]
+		if !ok || topicMetadata.Error.Code() == kafka.ErrUnknownTopicOrPart {
+			// The topic does not exist yet, so it has no events to catch up with
+			continue
+		}
+		for _, partition := range topicMetadata.Partitions {
+			low, high, err := r.Consumer.QueryWatermarkOffsets(topic, partition.ID, watermarkTimeout)
+			if err != nil {
+				return nil, err
+			}
+			if high > low {
+				watermarks = append(watermarks, kafka.TopicPartition{Topic: &topic, Partition: partition.ID, Offset: kafka.Offset(high)})
+			}
+		}
+	}
+	return watermarks, nil
+}
+
+func (r *KafkaLogReader) Run() (chan bool, error) {
+	err := r.Consumer.SubscribeTopics(r.Topics(), r.rebalance)
+	if err != nil {
+		return nil, err
+	}
+	r.logger.Printf("Subscribed to topics %v with consumer %v", r.Topics(), r.Consumer)
+
+	stop := make(chan bool, 1)
+	go (func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				event := r.Consumer.Poll(100)
+				if event == nil {
+					continue
+				}
+				r.Handle(event)
+			}
+		}
+	})()
+	return stop, nil
+}
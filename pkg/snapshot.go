@@ -0,0 +1,290 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+	"github.com/linkedin/goavro"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// OffsetMap keeps track of the offsets at which to continue consuming each topic partition.
+// It is safe for concurrent use.
+type OffsetMap struct {
+	lock    sync.RWMutex
+	offsets map[topicPartition]kafka.Offset
+}
+
+// Advance records that the given message has been consumed,
+// so that consuming continues at the message following it.
+func (m *OffsetMap) Advance(position kafka.TopicPartition) {
+	if position.Topic == nil || position.Offset < 0 {
+		return
+	}
+	key := topicPartition{topic: *position.Topic, partition: position.Partition}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if next := position.Offset + 1; next > m.offsets[key] {
+		m.offsets[key] = next
+	}
+}
+
+// Get returns the offset at which to continue consuming the given topic partition.
+func (m *OffsetMap) Get(topic string, partition int32) (kafka.Offset, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	offset, ok := m.offsets[topicPartition{topic: topic, partition: partition}]
+	return offset, ok
+}
+
+// Set sets the offsets at which to continue consuming the given topic partitions.
+func (m *OffsetMap) Set(offsets []kafka.TopicPartition) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, offset := range offsets {
+		if offset.Topic == nil {
+			continue
+		}
+		m.offsets[topicPartition{topic: *offset.Topic, partition: offset.Partition}] = offset.Offset
+	}
+}
+
+// List returns the offsets of all topic partitions, ordered by topic and partition.
+func (m *OffsetMap) List() []kafka.TopicPartition {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	offsets := make([]kafka.TopicPartition, 0, len(m.offsets))
+	for key, offset := range m.offsets {
+		topic := key.topic
+		offsets = append(offsets, kafka.TopicPartition{Topic: &topic, Partition: key.partition, Offset: offset})
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		if *offsets[i].Topic != *offsets[j].Topic {
+			return *offsets[i].Topic < *offsets[j].Topic
+		}
+		return offsets[i].Partition < offsets[j].Partition
+	})
+	return offsets
+}
+
+// NewOffsetMap constructs an empty OffsetMap.
+func NewOffsetMap() *OffsetMap {
+	return &OffsetMap{offsets: make(map[topicPartition]kafka.Offset)}
+}
+
+// SnapshotOffset is the offset at which to continue consuming a topic partition after restoring a Snapshot.
+type SnapshotOffset struct {
+	Topic     string       `json:"topic"`
+	Partition int32        `json:"partition"`
+	Offset    kafka.Offset `json:"offset"`
+}
+
+// SnapshotSchema is a schema in a Snapshot, together with its integer ID.
+// Soft-deleted schemata are part of the snapshot, hard-deleted ones are not.
+type SnapshotSchema struct {
+	UUID    uuid.UUID `json:"UUID"`
+	ID      int32     `json:"id"`
+	Spec    string    `json:"spec"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+// Snapshot is the state of a LocalRepo, as it is written to disk.
+// The aliases and compatibility levels are stored as the events that produce them,
+// the schemata are stored together with their integer IDs.
+// LastID is the most recently assigned ID, so that the IDs of hard-deleted schemata are not assigned again after restoring.
+// Topics are the topics the snapshot has been consumed from, it can only be restored into a repo consuming the same topics.
+type Snapshot struct {
+	Created         time.Time              `json:"created"`
+	Topics          TopicNames             `json:"topics"`
+	Schemata        []SnapshotSchema       `json:"schemata"`
+	LastID          int32                  `json:"lastID"`
+	Aliases         []AliasRequest         `json:"aliases"`
+	Compatibilities []CompatibilityRequest `json:"compatibilities"`
+	Offsets         []SnapshotOffset       `json:"offsets"`
+}
+
+// Snapshot captures the current state of the repo.
+func (repo LocalRepo) Snapshot() Snapshot {
+	// The offsets are captured first, so that the state contains at least every event before them.
+	// Events after the offsets which are already part of the state are consumed again after restoring,
+	// which does not change the state, since all events overwrite the entries they refer to.
+	offsets := repo.Offsets.List()
+	snapshot := Snapshot{
+		Created:         time.Now(),
+		Topics:          repo.Topics,
+		Schemata:        make([]SnapshotSchema, 0),
+		Aliases:         make([]AliasRequest, 0),
+		Compatibilities: make([]CompatibilityRequest, 0),
+		Offsets:         make([]SnapshotOffset, 0, len(offsets)),
+	}
+	for _, offset := range offsets {
+		snapshot.Offsets = append(snapshot.Offsets, SnapshotOffset{Topic: *offset.Topic, Partition: offset.Partition, Offset: offset.Offset})
+	}
+	snapshot.LastID = repo.Schemata.LastID()
+	for _, schemaUUID := range append(repo.ListSchemata(), repo.Schemata.Deleted()...) {
+		specification, ok := repo.GetSpecification(schemaUUID)
+		id, hasID := repo.SchemaID(schemaUUID)
+		if ok && hasID {
+			snapshot.Schemata = append(snapshot.Schemata, SnapshotSchema{
+				UUID:    schemaUUID,
+				ID:      id,
+				Spec:    specification,
+				Deleted: repo.Schemata.IsDeleted(schemaUUID),
+			})
+		}
+	}
+	sort.Slice(snapshot.Schemata, func(i, j int) bool {
+		return snapshot.Schemata[i].ID < snapshot.Schemata[j].ID
+	})
+	for _, alias := range repo.ListAliases() {
+		if schemaUUID, ok := repo.WhoIs(alias); ok {
+			snapshot.Aliases = append(snapshot.Aliases, AliasRequest{UUID: schemaUUID, Alias: alias.String()})
+		}
+	}
+	for name, level := range repo.Compatibilities.All() {
+		snapshot.Compatibilities = append(snapshot.Compatibilities, CompatibilityRequest{Name: name, Compatibility: level})
+	}
+	return snapshot
+}
+
+// Restore loads a snapshot into the repo.
+// If the repo's SchemaLogReader is a ResumableLogReader, it resumes consuming at the snapshot's offsets,
+// otherwise all events are consumed again on top of the snapshot.
+// Restore must be called before the repo is started with Run().
+func (repo LocalRepo) Restore(snapshot Snapshot) error {
+	if snapshot.Topics == (TopicNames{}) {
+		// Snapshots written before topic names were configurable do not name their topics
+		snapshot.Topics = DefaultTopicNames
+	}
+	if snapshot.Topics != repo.Topics {
+		return fmt.Errorf("snapshot of topics %v cannot be restored into a repo consuming %v", snapshot.Topics.List(), repo.Topics.List())
+	}
+	for _, request := range snapshot.Schemata {
+		codec, err := goavro.NewCodec(request.Spec)
+		if err != nil {
+			return fmt.Errorf("schema %v: %w", request.UUID, err)
+		}
+		repo.Schemata.upsert(request.UUID, codec, request.ID)
+		if request.Deleted {
+			repo.Schemata.Delete(request.UUID, SoftDelete)
+		}
+	}
+	repo.Schemata.retireIDs(snapshot.LastID)
+	for _, request := range snapshot.Aliases {
+		repo.Aliases.Insert(Alias(request.Alias), request.UUID)
+		if version, err := VersionFromAlias(Alias(request.Alias)); err == nil {
+			repo.Versions.Insert(version)
+		}
+	}
+	for _, request := range snapshot.Compatibilities {
+		repo.Compatibilities.Set(request.Name, request.Compatibility)
+	}
+	for _, alias := range repo.ListAliases() {
+		repo.checkPolicy(alias)
+	}
+
+	offsets := make([]kafka.TopicPartition, 0, len(snapshot.Offsets))
+	for _, offset := range snapshot.Offsets {
+		topic := offset.Topic
+		offsets = append(offsets, kafka.TopicPartition{Topic: &topic, Partition: offset.Partition, Offset: offset.Offset})
+	}
+	repo.Offsets.Set(offsets)
+	if reader, ok := repo.SchemaLogReader.(ResumableLogReader); ok {
+		reader.ResumeFrom(offsets)
+	} else {
+		repo.Logger.Printf("SchemaLogReader %v is not resumable, consuming all events on top of the snapshot", repo.SchemaLogReader)
+	}
+	return nil
+}
+
+// WriteSnapshot writes a snapshot of the repo to the given file.
+// The file is replaced atomically, so that a crash never leaves a partially written snapshot behind.
+func (repo LocalRepo) WriteSnapshot(path string) error {
+	marshaled, err := json.Marshal(repo.Snapshot())
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(marshaled)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+	}
+	return err
+}
+
+// LoadSnapshot restores the repo from a snapshot in the given file.
+// It returns an error satisfying os.IsNotExist, if there is no snapshot yet.
+// This works analogous to func LocalRepo.Restore.
+func (repo LocalRepo) LoadSnapshot(path string) error {
+	marshaled, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snapshot Snapshot
+	err = json.Unmarshal(marshaled, &snapshot)
+	if err != nil {
+		return err
+	}
+	err = repo.Restore(snapshot)
+	if err != nil {
+		return err
+	}
+	repo.Logger.Printf("Restored snapshot of %v from %v", snapshot.Created, path)
+	return nil
+}
+
+// SnapshotPeriodically writes a snapshot of the repo to the given file in the given interval.
+// A final snapshot is written when it is stopped through the returned channel.
+func (repo LocalRepo) SnapshotPeriodically(path string, interval time.Duration) chan bool {
+	stop := make(chan bool, 1)
+	go (func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				if err := repo.WriteSnapshot(path); err != nil {
+					repo.Logger.Printf("!! Unable to write snapshot: %v", err)
+				}
+				return
+			case <-ticker.C:
+				if err := repo.WriteSnapshot(path); err != nil {
+					repo.Logger.Printf("!! Unable to write snapshot: %v", err)
+				}
+			}
+		}
+	})()
+	return stop
+}
@@ -0,0 +1,100 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"github.com/google/uuid"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// publishVersion publishes a schema under the given version and waits until the repo has consumed it.
+func publishVersion(t *testing.T, updater Updater, repo LocalRepo, version NameVersion) uuid.UUID {
+	ready := repo.WaitVersionReady(version)
+	schemaUUID := uuid.New()
+	if err := updater.UpdateSchema(schemaUUID, stressSpecification); err != nil {
+		t.Fatal(err)
+	}
+	if err := updater.UpdateAlias(version.String(), schemaUUID); err != nil {
+		t.Fatal(err)
+	}
+	<-ready
+	return schemaUUID
+}
+
+// TestLocalRepoSnapshot restores a repo from a snapshot and checks that it resumes consuming after the snapshot.
+func TestLocalRepoSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "repo.json")
+
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := publishVersion(t, updater, repo, NameVersion{Name: "snapshot", Version: 0})
+	if err := updater.UpdateCompatibility("snapshot", CompatibilityFull); err != nil {
+		t.Fatal(err)
+	}
+	publishVersion(t, updater, repo, NameVersion{Name: "snapshot", Version: 1})
+	if err := repo.WriteSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	stop <- true
+
+	// Events which are published after the snapshot must still be consumed by the restored repo.
+	if err := updater.UpdateSchema(first, `{"type": "record", "name": "stress", "fields": [{"name": "n", "type": "int"}]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewLocalRepoWithLog(schemaLog.NewReader())
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	if count := restored.Count(); count != 2 {
+		t.Errorf("expected 2 schemata after restoring, got %v", count)
+	}
+	if latest, _ := restored.LatestVersion("snapshot"); latest.Version != 1 {
+		t.Errorf("expected latest version 1 after restoring, got %v", latest.Version)
+	}
+	for _, schemaUUID := range repo.ListSchemata() {
+		id, _ := repo.SchemaID(schemaUUID)
+		if restoredID, _ := restored.SchemaID(schemaUUID); restoredID != id {
+			t.Errorf("expected ID %v of %v after restoring, got %v", id, schemaUUID, restoredID)
+		}
+	}
+	if level := restored.GetCompatibility("snapshot"); level != CompatibilityFull {
+		t.Errorf("expected compatibility %v after restoring, got %v", CompatibilityFull, level)
+	}
+
+	updated := restored.Schemata.Observe(first)
+	stop, err = restored.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+	<-updated
+	if specification, _ := restored.GetSpecification(first); specification == stressSpecification {
+		t.Error("expected the update published after the snapshot to be consumed")
+	}
+	if count := restored.Count(); count != 2 {
+		t.Errorf("expected 2 schemata after resuming, got %v", count)
+	}
+}
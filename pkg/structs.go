@@ -0,0 +1,661 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// avroNativer is implemented by types converting themselves into the native form used by goavro,
+// e.g. the types generated by GenerateGo.
+type avroNativer interface {
+	AvroNative() interface{}
+}
+
+// avroNativeSetter is implemented by types converting the native form used by goavro into themselves,
+// e.g. the types generated by GenerateGo.
+type avroNativeSetter interface {
+	FromAvroNative(native interface{}) error
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	ratType      = reflect.TypeOf(big.Rat{})
+	uuidType     = reflect.TypeOf(uuid.UUID{})
+)
+
+// EncodeStruct encodes a Go value with the given avro schema.
+// Unlike Encode, it does not require the value to be in the native form used by goavro.
+//
+// Records are encoded from structs, whose fields are matched with the record's fields by their avro tag,
+// e.g. `avro:"order_id"`, or by their name, ignoring case. Fields tagged with `avro:"-"` are ignored.
+// Record fields without a matching struct field are encoded with their default value.
+// Maps with string keys may be used for records, too.
+//
+// Nil pointers, slices, maps and interfaces are encoded as the null branch of a union, other values as the
+// first branch they can be encoded as. Slices and arrays are encoded as arrays, maps with string keys as maps
+// and strings as enums. Fixed types are encoded from byte arrays or slices of the right size.
+// time.Time is encoded as the logical types date, timestamp-millis and timestamp-micros,
+// time.Duration as time-millis and time-micros and big.Rat as decimal.
+// Values implementing AvroNative() interface{}, such as the types generated by GenerateGo, convert themselves.
+func (repo LocalRepo) EncodeStruct(schema uuid.UUID, v interface{}) ([]byte, error) {
+	parsed, ok := repo.Schemata.parsedSchema(schema)
+	if !ok {
+		return nil, errors.New("schema not present")
+	}
+	native, err := structToNative(parsed, reflect.ValueOf(v), "")
+	if err != nil {
+		return nil, err
+	}
+	return repo.Encode(schema, native)
+}
+
+// DecodeInto decodes a datum with the given avro schema into the Go value v points to.
+// It maps the decoded datum to Go values following the rules described in EncodeStruct.
+// Nullable unions are decoded into pointers, slices, maps or interfaces, which are nil for null.
+// Record fields without a matching struct field are skipped, struct fields without a matching record field
+// are left as they are. Values implementing FromAvroNative(native interface{}) error convert the datum themselves.
+func (repo LocalRepo) DecodeInto(schema uuid.UUID, datum []byte, v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("cannot decode into %T, need a non-nil pointer", v)
+	}
+	parsed, ok := repo.Schemata.parsedSchema(schema)
+	if !ok {
+		return errors.New("schema not present")
+	}
+	native, err := repo.Decode(schema, datum)
+	if err != nil {
+		return err
+	}
+	return nativeToStruct(parsed, native, target.Elem(), "")
+}
+
+// structError reports a value that cannot be converted at the given path.
+func structError(path string, format string, args ...interface{}) error {
+	if path == "" {
+		path = "datum"
+	}
+	return fmt.Errorf("%v: %v", path, fmt.Sprintf(format, args...))
+}
+
+// structFields maps the fields of a record to the fields of a struct type, as described in EncodeStruct.
+func structFields(schema *avroSchema, structType reflect.Type) map[string]int {
+	fields := make(map[string]int, len(schema.Fields))
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		if structField.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(structField.Tag.Get("avro"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		for _, field := range schema.Fields {
+			if tag == field.Name {
+				fields[field.Name] = i
+			} else if _, tagged := fields[field.Name]; !tagged && tag == "" && strings.EqualFold(structField.Name, field.Name) {
+				fields[field.Name] = i
+			}
+		}
+	}
+	return fields
+}
+
+// asNativer returns the value as an avroNativer, if it implements the interface and is not a nil pointer.
+func asNativer(v reflect.Value) (avroNativer, bool) {
+	if !v.IsValid() || !v.CanInterface() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return nil, false
+	}
+	nativer, ok := v.Interface().(avroNativer)
+	return nativer, ok
+}
+
+// isNil indicates whether a value is nil or the zero reflect.Value.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+// structToNative converts a Go value into the native form of the schema.
+func structToNative(schema *avroSchema, v reflect.Value, path string) (interface{}, error) {
+	if schema.Type == "union" {
+		return unionToNative(schema, v, path)
+	}
+	if nativer, ok := asNativer(v); ok {
+		return nativer.AvroNative(), nil
+	}
+	if schema.Type == "null" {
+		return nil, nil
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, structError(path, "nil is not a %v", schema.typeName())
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, structError(path, "nil is not a %v", schema.typeName())
+	}
+
+	switch goLogicalType(schema) {
+	case "date", "timestamp-millis", "timestamp-micros":
+		if v.Type() == timeType {
+			return timeToNative(schema.LogicalType, v.Interface().(time.Time)), nil
+		}
+	case "time-millis":
+		if v.Type() == durationType {
+			return int32(v.Interface().(time.Duration) / time.Millisecond), nil
+		}
+	case "time-micros":
+		if v.Type() == durationType {
+			return int64(v.Interface().(time.Duration) / time.Microsecond), nil
+		}
+	case "decimal":
+		if v.Type() == ratType {
+			rat := v.Interface().(big.Rat)
+			return decimalToNative(&rat, schema.Scale, schema.Size), nil
+		}
+	}
+
+	switch schema.Type {
+	case "boolean":
+		if v.Kind() == reflect.Bool {
+			return v.Bool(), nil
+		}
+	case "int":
+		if n, ok := intOf(v); ok {
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, structError(path, "%v overflows int", n)
+			}
+			return int32(n), nil
+		}
+	case "long":
+		if n, ok := intOf(v); ok {
+			return n, nil
+		}
+	case "float":
+		if f, ok := floatOf(v); ok {
+			return float32(f), nil
+		}
+	case "double":
+		if f, ok := floatOf(v); ok {
+			return f, nil
+		}
+	case "bytes":
+		if b, ok := bytesOf(v); ok {
+			return b, nil
+		}
+		if v.Kind() == reflect.String {
+			return []byte(v.String()), nil
+		}
+	case "string", "enum":
+		if v.Kind() == reflect.String {
+			return v.String(), nil
+		}
+		if v.Type() == uuidType {
+			return v.Interface().(uuid.UUID).String(), nil
+		}
+	case "fixed":
+		if b, ok := bytesOf(v); ok {
+			if len(b) != schema.Size {
+				return nil, structError(path, "%v bytes do not fit fixed %v of size %v", len(b), schema.Name, schema.Size)
+			}
+			return b, nil
+		}
+	case "array":
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			native := make([]interface{}, 0, v.Len())
+			for i := 0; i < v.Len(); i++ {
+				item, err := structToNative(schema.Items, v.Index(i), fmt.Sprintf("%v[%v]", path, i))
+				if err != nil {
+					return nil, err
+				}
+				native = append(native, item)
+			}
+			return native, nil
+		}
+	case "map":
+		if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+			native := make(map[string]interface{}, v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				key := iter.Key().String()
+				value, err := structToNative(schema.Values, iter.Value(), fmt.Sprintf("%v[%q]", path, key))
+				if err != nil {
+					return nil, err
+				}
+				native[key] = value
+			}
+			return native, nil
+		}
+	case "record":
+		return recordToNative(schema, v, path)
+	}
+	return nil, structError(path, "cannot encode %v as %v", v.Type(), schema.typeName())
+}
+
+// unionToNative converts a Go value into the native form of the first branch of the union it can be converted to.
+func unionToNative(schema *avroSchema, v reflect.Value, path string) (interface{}, error) {
+	if nativer, ok := asNativer(v); ok {
+		v = reflect.ValueOf(nativer.AvroNative())
+	}
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	// Values which are wrapped already, e.g. by goavro.Union or by the union types generated by GenerateGo
+	if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && v.Len() == 1 {
+		key := v.MapKeys()[0]
+		for _, branch := range schema.Branches {
+			if branch.Type != "null" && branch.typeName() == key.String() {
+				native, err := structToNative(branch, v.MapIndex(key), path)
+				if err != nil {
+					return nil, err
+				}
+				return map[string]interface{}{branch.typeName(): native}, nil
+			}
+		}
+	}
+
+	hasNull := false
+	for _, branch := range schema.Branches {
+		hasNull = hasNull || branch.Type == "null"
+	}
+	if isNil(v) {
+		if hasNull {
+			return nil, nil
+		}
+		return nil, structError(path, "nil is not a member of the union")
+	}
+	for _, branch := range schema.Branches {
+		if branch.Type == "null" {
+			continue
+		}
+		if native, err := structToNative(branch, v, path); err == nil {
+			return map[string]interface{}{branch.typeName(): native}, nil
+		}
+	}
+	return nil, structError(path, "%v is not a member of the union", v.Type())
+}
+
+func recordToNative(schema *avroSchema, v reflect.Value, path string) (interface{}, error) {
+	native := make(map[string]interface{}, len(schema.Fields))
+	switch {
+	case v.Kind() == reflect.Struct:
+		fields := structFields(schema, v.Type())
+		for _, field := range schema.Fields {
+			i, ok := fields[field.Name]
+			if !ok {
+				if !field.HasDefault {
+					return nil, structError(path, "%v has no field for %v", v.Type(), field.Name)
+				}
+				continue
+			}
+			value, err := structToNative(field.Type, v.Field(i), path+"."+field.Name)
+			if err != nil {
+				return nil, err
+			}
+			native[field.Name] = value
+		}
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		for _, field := range schema.Fields {
+			entry := v.MapIndex(reflect.ValueOf(field.Name).Convert(v.Type().Key()))
+			if !entry.IsValid() {
+				if !field.HasDefault {
+					return nil, structError(path, "missing field %v", field.Name)
+				}
+				continue
+			}
+			value, err := structToNative(field.Type, entry, path+"."+field.Name)
+			if err != nil {
+				return nil, err
+			}
+			native[field.Name] = value
+		}
+	default:
+		return nil, structError(path, "cannot encode %v as record %v", v.Type(), schema.Name)
+	}
+	return native, nil
+}
+
+func intOf(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v.Uint()), true
+	}
+	return 0, false
+}
+
+func floatOf(v reflect.Value) (float64, bool) {
+	if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+		return v.Float(), true
+	}
+	if n, ok := intOf(v); ok {
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// bytesOf returns the contents of a byte slice or a byte array.
+func bytesOf(v reflect.Value) ([]byte, bool) {
+	if (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) || v.Type().Elem().Kind() != reflect.Uint8 {
+		return nil, false
+	}
+	b := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(b), v)
+	return b, true
+}
+
+func timeToNative(logicalType string, t time.Time) interface{} {
+	switch logicalType {
+	case "date":
+		seconds := t.Unix()
+		days := seconds / 86400
+		if seconds%86400 < 0 {
+			days--
+		}
+		return int32(days)
+	case "timestamp-millis":
+		return t.Unix()*1000 + int64(t.Nanosecond())/int64(time.Millisecond)
+	default:
+		return t.Unix()*1000000 + int64(t.Nanosecond())/int64(time.Microsecond)
+	}
+}
+
+func timeFromNative(logicalType string, n int64) time.Time {
+	switch logicalType {
+	case "date":
+		return time.Unix(n*86400, 0).UTC()
+	case "timestamp-millis":
+		return time.Unix(n/1000, n%1000*int64(time.Millisecond)).UTC()
+	default:
+		return time.Unix(n/1000000, n%1000000*int64(time.Microsecond)).UTC()
+	}
+}
+
+// decimalToNative encodes a decimal as the big-endian two's complement of its unscaled value.
+// Digits beyond the scale are truncated. Decimals of a fixed size are sign-extended to that size.
+func decimalToNative(value *big.Rat, scale int, size int) []byte {
+	scaled := new(big.Rat).Mul(value, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	unscaled := new(big.Int).Quo(scaled.Num(), scaled.Denom())
+	length := unscaled.BitLen()/8 + 1
+	if size > length {
+		length = size
+	}
+	twos := unscaled
+	if unscaled.Sign() < 0 {
+		twos = new(big.Int).Add(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*length)))
+	}
+	encoded := make([]byte, length)
+	magnitude := twos.Bytes()
+	copy(encoded[length-len(magnitude):], magnitude)
+	return encoded
+}
+
+// decimalFromNative decodes a decimal from the big-endian two's complement of its unscaled value.
+func decimalFromNative(encoded []byte, scale int) *big.Rat {
+	unscaled := new(big.Int).SetBytes(encoded)
+	if len(encoded) > 0 && encoded[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(encoded))))
+	}
+	return new(big.Rat).SetFrac(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+}
+
+// nativeToStruct converts the native form of the schema into the Go value target, which needs to be settable.
+func nativeToStruct(schema *avroSchema, native interface{}, target reflect.Value, path string) error {
+	if target.CanAddr() {
+		if setter, ok := target.Addr().Interface().(avroNativeSetter); ok {
+			return setter.FromAvroNative(native)
+		}
+	}
+	if schema.Type == "union" {
+		if native == nil {
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+		union, ok := native.(map[string]interface{})
+		if !ok || len(union) != 1 {
+			return structError(path, "expected a union, got %T", native)
+		}
+		for name, value := range union {
+			for _, branch := range schema.Branches {
+				if branch.typeName() == name {
+					return nativeToStruct(branch, value, target, path)
+				}
+			}
+			return structError(path, "unknown branch %v", name)
+		}
+	}
+	switch target.Kind() {
+	case reflect.Interface:
+		if native == nil {
+			target.Set(reflect.Zero(target.Type()))
+		} else {
+			target.Set(reflect.ValueOf(native))
+		}
+		return nil
+	case reflect.Ptr:
+		if native == nil {
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+		elem := reflect.New(target.Type().Elem())
+		if err := nativeToStruct(schema, native, elem.Elem(), path); err != nil {
+			return err
+		}
+		target.Set(elem)
+		return nil
+	}
+	if schema.Type == "null" {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	switch goLogicalType(schema) {
+	case "date", "timestamp-millis", "timestamp-micros":
+		if target.Type() == timeType {
+			n, ok := nativeInt(native)
+			if !ok {
+				return structError(path, "expected a %v, got %T", schema.Type, native)
+			}
+			target.Set(reflect.ValueOf(timeFromNative(schema.LogicalType, n)))
+			return nil
+		}
+	case "time-millis", "time-micros":
+		if target.Type() == durationType {
+			n, ok := nativeInt(native)
+			if !ok {
+				return structError(path, "expected a %v, got %T", schema.Type, native)
+			}
+			unit := time.Millisecond
+			if schema.LogicalType == "time-micros" {
+				unit = time.Microsecond
+			}
+			target.Set(reflect.ValueOf(time.Duration(n) * unit))
+			return nil
+		}
+	case "decimal":
+		if target.Type() == ratType {
+			encoded, ok := native.([]byte)
+			if !ok {
+				return structError(path, "expected a decimal, got %T", native)
+			}
+			target.Set(reflect.ValueOf(*decimalFromNative(encoded, schema.Scale)))
+			return nil
+		}
+	}
+
+	switch value := native.(type) {
+	case bool:
+		if target.Kind() == reflect.Bool {
+			target.SetBool(value)
+			return nil
+		}
+	case int32, int64:
+		n, _ := nativeInt(value)
+		if setInt(target, n) {
+			return nil
+		}
+	case float32, float64:
+		f := reflect.ValueOf(value).Float()
+		if target.Kind() == reflect.Float32 || target.Kind() == reflect.Float64 {
+			target.SetFloat(f)
+			return nil
+		}
+	case string:
+		switch {
+		case target.Kind() == reflect.String:
+			target.SetString(value)
+			return nil
+		case target.Type() == uuidType:
+			parsed, err := uuid.Parse(value)
+			if err != nil {
+				return structError(path, "%v", err)
+			}
+			target.Set(reflect.ValueOf(parsed))
+			return nil
+		case target.Kind() == reflect.Slice && target.Type().Elem().Kind() == reflect.Uint8:
+			target.SetBytes([]byte(value))
+			return nil
+		}
+	case []byte:
+		switch {
+		case target.Kind() == reflect.Slice && target.Type().Elem().Kind() == reflect.Uint8:
+			target.SetBytes(append([]byte(nil), value...))
+			return nil
+		case target.Kind() == reflect.Array && target.Type().Elem().Kind() == reflect.Uint8 && target.Len() == len(value):
+			reflect.Copy(target, reflect.ValueOf(value))
+			return nil
+		case target.Kind() == reflect.String:
+			target.SetString(string(value))
+			return nil
+		}
+	case []interface{}:
+		return nativeToSlice(schema, value, target, path)
+	case map[string]interface{}:
+		if schema.Type == "record" {
+			return nativeToRecord(schema, value, target, path)
+		}
+		return nativeToMap(schema, value, target, path)
+	}
+	return structError(path, "cannot decode %v into %v", schema.typeName(), target.Type())
+}
+
+func nativeToSlice(schema *avroSchema, native []interface{}, target reflect.Value, path string) error {
+	switch target.Kind() {
+	case reflect.Slice:
+		target.Set(reflect.MakeSlice(target.Type(), len(native), len(native)))
+	case reflect.Array:
+		if target.Len() != len(native) {
+			return structError(path, "cannot decode %v items into %v", len(native), target.Type())
+		}
+	default:
+		return structError(path, "cannot decode array into %v", target.Type())
+	}
+	for i, item := range native {
+		if err := nativeToStruct(schema.Items, item, target.Index(i), fmt.Sprintf("%v[%v]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nativeToMap(schema *avroSchema, native map[string]interface{}, target reflect.Value, path string) error {
+	if target.Kind() != reflect.Map || target.Type().Key().Kind() != reflect.String {
+		return structError(path, "cannot decode map into %v", target.Type())
+	}
+	target.Set(reflect.MakeMapWithSize(target.Type(), len(native)))
+	for key, entry := range native {
+		value := reflect.New(target.Type().Elem()).Elem()
+		if err := nativeToStruct(schema.Values, entry, value, fmt.Sprintf("%v[%q]", path, key)); err != nil {
+			return err
+		}
+		target.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), value)
+	}
+	return nil
+}
+
+func nativeToRecord(schema *avroSchema, native map[string]interface{}, target reflect.Value, path string) error {
+	switch {
+	case target.Kind() == reflect.Struct:
+		fields := structFields(schema, target.Type())
+		for _, field := range schema.Fields {
+			i, ok := fields[field.Name]
+			if !ok {
+				continue
+			}
+			if err := nativeToStruct(field.Type, native[field.Name], target.Field(i), path+"."+field.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case target.Kind() == reflect.Map && target.Type().Key().Kind() == reflect.String:
+		target.Set(reflect.MakeMapWithSize(target.Type(), len(schema.Fields)))
+		for _, field := range schema.Fields {
+			value := reflect.New(target.Type().Elem()).Elem()
+			if err := nativeToStruct(field.Type, native[field.Name], value, path+"."+field.Name); err != nil {
+				return err
+			}
+			target.SetMapIndex(reflect.ValueOf(field.Name).Convert(target.Type().Key()), value)
+		}
+		return nil
+	}
+	return structError(path, "cannot decode record %v into %v", schema.Name, target.Type())
+}
+
+func nativeInt(native interface{}) (int64, bool) {
+	switch n := native.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+// setInt sets an integer or floating-point target, checking that the integer fits.
+func setInt(target reflect.Value, n int64) bool {
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if target.OverflowInt(n) {
+			return false
+		}
+		target.SetInt(n)
+		return true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n < 0 || target.OverflowUint(uint64(n)) {
+			return false
+		}
+		target.SetUint(uint64(n))
+		return true
+	case reflect.Float32, reflect.Float64:
+		target.SetFloat(float64(n))
+		return true
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"github.com/google/uuid"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	SKU string
+}
+
+type testOrder struct {
+	ID      string              `avro:"order_id"`
+	Total   *big.Rat            `avro:"total"`
+	Placed  time.Time           `avro:"placed"`
+	Status  string              `avro:"status"`
+	Hash    [16]byte            `avro:"hash"`
+	Note    *string             `avro:"note"`
+	Items   []testItem          `avro:"items"`
+	Labels  map[string]int64    `avro:"labels"`
+	Extra   map[string]testItem `avro:"-"`
+	Next    *testOrder          `avro:"next"`
+	Ignored func()              `avro:"-"`
+}
+
+// TestLocalRepoEncodeStruct encodes a struct, checks the native form it is encoded from and decodes it into a struct again.
+func TestLocalRepoEncodeStruct(t *testing.T) {
+	schemaLog := NewMemoryLog()
+	updater := NewUpdaterWithLog(schemaLog)
+	repo := NewLocalRepoWithLog(schemaLog.NewReader())
+	stop, err := repo.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (func() {
+		stop <- true
+	})()
+
+	schemaUUID := uuid.New()
+	ready := repo.WaitSchemaReady(schemaUUID)
+	specification := `{"type": "record", "name": "Order", "namespace": "com.example", "fields": [
+		{"name": "order_id", "type": "string"},
+		{"name": "total", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+		{"name": "placed", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["PLACED", "SHIPPED"]}},
+		{"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 16}},
+		{"name": "note", "type": ["null", "string"]},
+		{"name": "items", "type": {"type": "array", "items": {"type": "record", "name": "Item", "fields": [{"name": "sku", "type": "string"}]}}},
+		{"name": "labels", "type": {"type": "map", "values": "long"}},
+		{"name": "next", "type": ["null", "Order"]},
+		{"name": "priority", "type": "int", "default": 3}
+	]}`
+	if err := updater.UpdateSchema(schemaUUID, specification); err != nil {
+		t.Fatal(err)
+	}
+	<-ready
+
+	note := "fragile"
+	order := testOrder{
+		ID:     "a",
+		Total:  big.NewRat(-1999, 100),
+		Placed: time.Unix(1600000000, 123000000).UTC(),
+		Status: "SHIPPED",
+		Hash:   [16]byte{1, 2, 3},
+		Note:   &note,
+		Items:  []testItem{{SKU: "x"}, {SKU: "y"}},
+		Labels: map[string]int64{"weight": 12},
+		Next:   &testOrder{ID: "b", Total: big.NewRat(1, 2), Placed: time.Unix(-1, 0).UTC(), Status: "PLACED", Items: []testItem{}, Labels: map[string]int64{}},
+	}
+	datum, err := repo.EncodeStruct(schemaUUID, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	native, err := repo.Decode(schemaUUID, datum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := native.(map[string]interface{})
+	if placed := record["placed"]; placed != int64(1600000000123) {
+		t.Errorf("expected the timestamp 1600000000123, got %v", placed)
+	}
+	if noteUnion := record["note"].(map[string]interface{}); noteUnion["string"] != "fragile" {
+		t.Errorf("expected the note to be wrapped in a union, got %v", record["note"])
+	}
+	if priority := record["priority"]; priority != int32(3) {
+		t.Errorf("expected the default priority 3, got %v", priority)
+	}
+
+	var decoded testOrder
+	if err := repo.DecodeInto(schemaUUID, datum, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Total.Cmp(order.Total) != 0 || decoded.Next.Total.Cmp(order.Next.Total) != 0 {
+		t.Errorf("expected the totals %v and %v, got %v and %v", order.Total, order.Next.Total, decoded.Total, decoded.Next.Total)
+	}
+	order.Total, order.Next.Total, decoded.Total, decoded.Next.Total = nil, nil, nil, nil
+	if !reflect.DeepEqual(order, decoded) {
+		t.Errorf("expected %+v, got %+v", order, decoded)
+	}
+
+	if _, err := repo.EncodeStruct(schemaUUID, struct{ ID string }{"a"}); err == nil {
+		t.Error("expected encoding a struct without the required fields to fail")
+	}
+	if err := repo.DecodeInto(schemaUUID, datum, decoded); err == nil {
+		t.Error("expected decoding into a non-pointer to fail")
+	}
+}
@@ -0,0 +1,124 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"context"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"log"
+	"time"
+)
+
+// TopicNames are the names of the topics of a schema repository.
+// All events are keyed by the UUID, alias or name they refer to and each event holds the entire state of its key,
+// so that the topics can be log-compacted: a consumer only needs the latest event of each key.
+type TopicNames struct {
+	Update        string `json:"update"`
+	Alias         string `json:"alias"`
+	Compatibility string `json:"compatibility"`
+}
+
+// DefaultTopicNames are the names of the topics of a schema repository without a namespace or prefix.
+var DefaultTopicNames = TopicNames{
+	Update:        "schema_update",
+	Alias:         "schema_alias",
+	Compatibility: "schema_compatibility",
+}
+
+// List returns all topic names.
+func (t TopicNames) List() []string {
+	return []string{t.Update, t.Alias, t.Compatibility}
+}
+
+// Options configure which schema repository a LocalRepo or an Updater uses.
+// The zero value uses the DefaultTopicNames.
+// Several schema repositories can share a Kafka cluster, e.g. for staging and production or for several tenants,
+// as long as each of them uses its own namespace or prefix.
+type Options struct {
+	// Namespace is prepended to the topic names, separated by a dot, e.g. "tenant.schema_update".
+	Namespace string
+	// Prefix is prepended to the topic names as it is, e.g. "staging-schema_update".
+	// If both are given, the prefix precedes the namespace.
+	Prefix string
+	// Topics override the topic names, the namespace and prefix are not applied to them.
+	// Topics which are left empty are named after the DefaultTopicNames.
+	Topics TopicNames
+}
+
+// TopicNames returns the names of the topics of the configured schema repository.
+func (o Options) TopicNames() TopicNames {
+	name := func(override string, base string) string {
+		if override != "" {
+			return override
+		}
+		if o.Namespace != "" {
+			base = o.Namespace + "." + base
+		}
+		return o.Prefix + base
+	}
+	return TopicNames{
+		Update:        name(o.Topics.Update, DefaultTopicNames.Update),
+		Alias:         name(o.Topics.Alias, DefaultTopicNames.Alias),
+		Compatibility: name(o.Topics.Compatibility, DefaultTopicNames.Compatibility),
+	}
+}
+
+// TopicConfig is the configuration of the schema repository's topics.
+// Compaction keeps the latest event of each key and eventually removes tombstones along with the events they delete.
+// Tombstones are retained for delete.retention.ms, consumers lagging further behind than that miss hard deletions.
+// Schema events carry the schema's ID and specification, also when soft-deleting it, so they do not depend on
+// the order of events which compaction changes.
+var TopicConfig = map[string]string{
+	"cleanup.policy":        "compact",
+	"delete.retention.ms":   "86400000",
+	"min.compaction.lag.ms": "0",
+}
+
+// CreateTopics creates the topics of the configured schema repository on the given Kafka broker.
+// Every topic has a single partition, so that all events of a topic are consumed in the order they were produced,
+// and is configured with TopicConfig.
+// Topics which already exist are left as they are.
+func CreateTopics(broker string, options Options, replicationFactor int) error {
+	admin, err := kafka.NewAdminClient(&kafka.ConfigMap{"bootstrap.servers": broker})
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	topics := options.TopicNames().List()
+	specifications := make([]kafka.TopicSpecification, 0, len(topics))
+	for _, topic := range topics {
+		specifications = append(specifications, kafka.TopicSpecification{
+			Topic:             topic,
+			NumPartitions:     1,
+			ReplicationFactor: replicationFactor,
+			Config:            TopicConfig,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	results, err := admin.CreateTopics(ctx, specifications, kafka.SetAdminOperationTimeout(30*time.Second))
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		switch result.Error.Code() {
+		case kafka.ErrNoError:
+			log.Printf("Created topic %v", result.Topic)
+		case kafka.ErrTopicAlreadyExists:
+			log.Printf("Topic %v already exists, leaving its configuration as it is", result.Topic)
+		default:
+			return fmt.Errorf("unable to create topic %v: %w", result.Topic, result.Error)
+		}
+	}
+	return nil
+}
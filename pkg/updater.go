@@ -10,14 +10,63 @@
 package kafka_schema
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/google/uuid"
 	core "github.com/strangedev/kafka-golang/pkg"
+	"sync"
 )
 
-// Commander is a KafkaProducer used for writing schema updates into Kafka.
+// Commander is a SchemaLogWriter used for writing schema updates into Kafka.
 type Commander struct {
-	*core.Producer
+	SchemaLogWriter
+	topics TopicNames
+	// source is the view of the repository the Commander assigns IDs with, it may be nil.
+	source    SchemaSource
+	published *publishedSchemata
+}
+
+// SchemaSource is the view of the schema repository which an Updater uses to write schema events that hold the
+// entire state of their schema, so that every repo consuming them agrees on the state regardless of compaction.
+// LocalRepo is a SchemaSource.
+type SchemaSource interface {
+	// GetSpecification returns the avro specification for the given uuid in plain text.
+	GetSpecification(schema uuid.UUID) (specification string, ok bool)
+	// SchemaID returns the integer ID of the given schema.
+	SchemaID(schema uuid.UUID) (int32, bool)
+	// LastID returns the highest integer ID which has been assigned to any schema, including hard-deleted ones.
+	LastID() int32
+}
+
+// publishedSchemata remembers the schemata a Commander has published itself,
+// since its SchemaSource may not have consumed them yet, or there may be no SchemaSource at all.
+type publishedSchemata struct {
+	lock     sync.Mutex
+	requests map[uuid.UUID]UpdateRequest
+	// lastID is the highest ID the Commander has assigned.
+	lastID int32
+}
+
+// DeleteMode determines what happens to a schema or alias when it is deleted.
+type DeleteMode int
+
+const (
+	// SoftDelete retracts a schema or alias, but keeps a record of the deletion in the log.
+	// A soft-deleted schema is no longer listed and can no longer be used for encoding,
+	// but data that has been written with it can still be decoded.
+	SoftDelete DeleteMode = iota
+	// HardDelete removes a schema or alias entirely.
+	// It is written as a tombstone, so that compaction eventually removes every event of the schema or alias from the log.
+	// Data that has been written with a hard-deleted schema can no longer be decoded.
+	HardDelete
+)
+
+func (m DeleteMode) String() string {
+	if m == HardDelete {
+		return "hard"
+	}
+	return "soft"
 }
 
 // Updater encapsulates the methods required to update the schema repository stored in Kafka.
@@ -26,33 +75,174 @@ type Updater interface {
 	UpdateSchema(schemaUUID uuid.UUID, specification string) error
 	// UpdateAlias sets the given Alias to equal the given UUID.
 	UpdateAlias(alias string, schemaUUID uuid.UUID) error
+	// UpdateCompatibility sets the compatibility level of all versions of the given name.
+	UpdateCompatibility(name string, level Compatibility) error
+	// DeleteSchema deletes the given UUID. Aliases of the UUID are not deleted.
+	DeleteSchema(schemaUUID uuid.UUID, mode DeleteMode) error
+	// DeleteAlias deletes the given Alias. The UUID it refers to is not deleted.
+	DeleteAlias(alias string, mode DeleteMode) error
 }
 
 // NewUpdater constructs an Updater that uses the given Kafka broker to write updates.
-// This will create a new KafkaProducer.
+// This will create a new KafkaLogWriter.
 func NewUpdater(broker string) (Updater, error) {
-	p, err := core.NewKafkaProducer(broker)
+	return NewUpdaterWithOptions(broker, Options{})
+}
+
+// NewUpdaterWithOptions constructs an Updater that uses the given Kafka broker to write updates
+// into the configured schema repository.
+// This will create a new KafkaLogWriter.
+func NewUpdaterWithOptions(broker string, options Options) (Updater, error) {
+	writer, err := NewKafkaLogWriter(broker)
 	if err != nil {
 		return nil, err
 	}
-	return NewUpdaterWithProducer(p), nil
+	return NewUpdaterWithLogAndOptions(writer, options), nil
 }
 
 // NewUpdater constructs an Updater that uses the given KafkaProducer to produce its events.
-// In most cases, it is fine to use NewUpdater instead and let it create a new KafkaProducer.
+// In most cases, it is better to use NewUpdater instead and let it create a new KafkaLogWriter,
+// since core.Producer prints every delivery report to stdout and does not return delivery failures.
 func NewUpdaterWithProducer(p *core.Producer) Updater {
-	return Commander{p}
+	return NewUpdaterWithLog(p)
+}
+
+// NewUpdaterWithLog constructs an Updater that writes its events into the given SchemaLogWriter.
+func NewUpdaterWithLog(writer SchemaLogWriter) Updater {
+	return NewUpdaterWithLogAndOptions(writer, Options{})
+}
+
+// NewUpdaterWithLogAndOptions constructs an Updater that writes its events into the given SchemaLogWriter,
+// using the topics of the configured schema repository.
+func NewUpdaterWithLogAndOptions(writer SchemaLogWriter, options Options) Updater {
+	return NewUpdaterWithLogAndSource(writer, options, nil)
+}
+
+// NewUpdaterWithLogAndSource constructs an Updater that writes its events into the given SchemaLogWriter,
+// continuing the IDs which have been assigned in the given SchemaSource, e.g. a running LocalRepo.
+// Without a SchemaSource, the Updater only knows about the schemata it has published itself.
+func NewUpdaterWithLogAndSource(writer SchemaLogWriter, options Options, source SchemaSource) Updater {
+	return Commander{
+		SchemaLogWriter: writer,
+		topics:          options.TopicNames(),
+		source:          source,
+		published:       &publishedSchemata{requests: make(map[uuid.UUID]UpdateRequest)},
+	}
+}
+
+// known looks up a schema the Commander has published itself or which is known to its SchemaSource.
+func (cmd Commander) known(schemaUUID uuid.UUID) (UpdateRequest, bool) {
+	cmd.published.lock.Lock()
+	request, ok := cmd.published.requests[schemaUUID]
+	cmd.published.lock.Unlock()
+	if ok || cmd.source == nil {
+		return request, ok
+	}
+	specification, ok := cmd.source.GetSpecification(schemaUUID)
+	id, hasID := cmd.source.SchemaID(schemaUUID)
+	return UpdateRequest{UUID: schemaUUID, ID: id, Spec: specification}, ok && hasID
 }
 
+// assignID returns the ID of a known schema, or assigns the ID following the highest ID
+// the Commander has assigned itself or has observed in its SchemaSource to a new schema.
+// As long as updaters observe the log before they publish, IDs are therefore assigned sequentially in log order.
+func (cmd Commander) assignID(schemaUUID uuid.UUID) int32 {
+	if request, ok := cmd.known(schemaUUID); ok {
+		return request.ID
+	}
+	var observed int32
+	if cmd.source != nil {
+		observed = cmd.source.LastID()
+	}
+	cmd.published.lock.Lock()
+	defer cmd.published.lock.Unlock()
+	if observed > cmd.published.lastID {
+		cmd.published.lastID = observed
+	}
+	cmd.published.lastID++
+	return cmd.published.lastID
+}
 
+// produceKeyed produces a message keyed by the UUID, alias or name it refers to,
+// so that it supersedes the earlier events of the same key in compacted topics.
+// A nil value produces a tombstone.
+func (cmd Commander) produceKeyed(topic string, key string, value interface{}) error {
+	var marshaled []byte
+	if value != nil {
+		var err error
+		marshaled, err = json.Marshal(value)
+		if err != nil {
+			return err
+		}
+	}
+	return cmd.ProduceSync(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            []byte(key),
+		Value:          marshaled,
+	})
+}
+
+// UpdateSchema publishes the specification together with the integer ID of the schema,
+// so that repos agree on the ID regardless of the order in which they consume the schemata, e.g. after compaction.
 func (cmd Commander) UpdateSchema(schemaUUID uuid.UUID, specification string) error {
-	topic := "schema_update"
-	request := UpdateRequest{UUID: schemaUUID, Spec: specification}
-	return cmd.ProduceJSONSync(topic, kafka.PartitionAny, request)
+	topic := cmd.topics.Update
+	request := UpdateRequest{UUID: schemaUUID, ID: cmd.assignID(schemaUUID), Spec: specification}
+	err := cmd.produceKeyed(topic, schemaUUID.String(), request)
+	if err != nil {
+		return err
+	}
+	cmd.published.lock.Lock()
+	cmd.published.requests[schemaUUID] = request
+	cmd.published.lock.Unlock()
+	return nil
 }
 
 func (cmd Commander) UpdateAlias(alias string, schemaUUID uuid.UUID) error {
-	topic := "schema_alias"
+	topic := cmd.topics.Alias
 	request := AliasRequest{UUID: schemaUUID, Alias: alias}
-	return cmd.ProduceJSONSync(topic, kafka.PartitionAny, request)
+	return cmd.produceKeyed(topic, alias, request)
+}
+
+func (cmd Commander) UpdateCompatibility(name string, level Compatibility) error {
+	topic := cmd.topics.Compatibility
+	request := CompatibilityRequest{Name: name, Compatibility: level}
+	return cmd.produceKeyed(topic, name, request)
+}
+
+func (cmd Commander) DeleteSchema(schemaUUID uuid.UUID, mode DeleteMode) error {
+	topic := cmd.topics.Update
+	if mode == HardDelete {
+		err := cmd.produceKeyed(topic, schemaUUID.String(), nil)
+		if err != nil {
+			return err
+		}
+		cmd.published.lock.Lock()
+		delete(cmd.published.requests, schemaUUID)
+		cmd.published.lock.Unlock()
+		return nil
+	}
+	// The soft-deletion replaces the schema's update event when the topic is compacted,
+	// so it carries the specification and ID to keep the schema decodable.
+	request, ok := cmd.known(schemaUUID)
+	if !ok {
+		return fmt.Errorf("unable to soft-delete schema %v, its specification is unknown", schemaUUID)
+	}
+	request.Deleted = true
+	err := cmd.produceKeyed(topic, schemaUUID.String(), request)
+	if err != nil {
+		return err
+	}
+	cmd.published.lock.Lock()
+	cmd.published.requests[schemaUUID] = request
+	cmd.published.lock.Unlock()
+	return nil
+}
+
+func (cmd Commander) DeleteAlias(alias string, mode DeleteMode) error {
+	topic := cmd.topics.Alias
+	if mode == HardDelete {
+		return cmd.produceKeyed(topic, alias, nil)
+	}
+	request := AliasRequest{Alias: alias, Deleted: true}
+	return cmd.produceKeyed(topic, alias, request)
 }
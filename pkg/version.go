@@ -12,6 +12,7 @@ package kafka_schema
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -25,6 +26,8 @@ type NameVersion struct {
 }
 
 // Version provides a high-level interface for versioning operations on a plain-text addressable versioned thing.
+// A Version does not know which versions of the thing exist, so the Version succeeding it
+// can only be looked up in a repo, see func VersionedRepo.GetNext.
 type Version interface {
 	// IsOrigin indicates whether this is the first version of the thing.
 	IsOrigin() bool
@@ -36,10 +39,6 @@ type Version interface {
 	// If this is the origin, it returns the origin unchanged.
 	// The ok flag indicates whether the returned Version differs from the passed Version.
 	GetPrevious() (v Version, ok bool)
-	// GetNext returns the Version succeeding this Version.
-	// If this is the most recent Version, it returns the Version unchanged.
-	// The ok flag indicates whether the returned Version differs from the passed Version.
-	GetNext() (v Version, ok bool)
 	// String marshals the Version into a string.
 	String() string
 	// Alias marshals the Version into an Alias.
@@ -70,11 +69,6 @@ func (v NameVersion) GetPrevious() (Version, bool) {
 	return NameVersion{Name: v.Name, Version: previousVersion}, true
 }
 
-func (v NameVersion) GetNext() (Version, bool) {
-	previousVersion := v.Version + 1
-	return NameVersion{Name: v.Name, Version: previousVersion}, true
-}
-
 func (v NameVersion) String() string {
 	return fmt.Sprintf("%s-v%x", v.Name, v.Version)
 }
@@ -84,16 +78,21 @@ func (v NameVersion) Alias() Alias {
 }
 
 // VersionFromString unmarshals a Version from string.
-// The format is {NAME}-v{VERSION}.
+// The format is {NAME}-v{VERSION}, where VERSION is hexadecimal.
+// The name itself may contain "-v".
 func VersionFromString(s string) (NameVersion, error) {
 	version := NameVersion{}
-	parts := strings.Split(s, "-v")
-	if len(parts) != 2 {
+	separator := strings.LastIndex(s, "-v")
+	if separator < 1 {
 		return version, errors.New("invalid format")
 	}
-	version.Name = parts[0]
-	_, err := fmt.Sscanf(parts[1], "%x", &version.Version)
-	return version, err
+	version.Name = s[:separator]
+	number, err := strconv.ParseUint(s[separator+2:], 16, 0)
+	if err != nil {
+		return version, fmt.Errorf("invalid format: %v", err)
+	}
+	version.Version = uint(number)
+	return version, nil
 }
 
 // VersionFromAlias unmarshals a Version from Alias.
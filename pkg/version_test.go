@@ -0,0 +1,108 @@
+/* Copyright 2020 Noah Hummel
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License. You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package kafka_schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestVersionFromString parses versioned aliases, including names which contain "-v" themselves.
+func TestVersionFromString(t *testing.T) {
+	valid := map[string]NameVersion{
+		"foo-v0":           {Name: "foo", Version: 0},
+		"foo-va":           {Name: "foo", Version: 10},
+		"foo-v1f":          {Name: "foo", Version: 31},
+		"my-very-v2-v3":    {Name: "my-very-v2", Version: 3},
+		"-v-v1":            {Name: "-v", Version: 1},
+		"com.example-v-vf": {Name: "com.example-v", Version: 0xf},
+	}
+	for s, expected := range valid {
+		version, err := VersionFromString(s)
+		if err != nil {
+			t.Errorf("%v: %v", s, err)
+			continue
+		}
+		if version != expected {
+			t.Errorf("expected %v to be parsed as %#v, got %#v", s, expected, version)
+		}
+		if version.String() != s {
+			t.Errorf("expected %#v to be marshalled as %v, got %v", version, s, version.String())
+		}
+	}
+
+	for _, s := range []string{"", "foo", "foo-v", "-v1", "foo-vx", "foo-v1-bar", "foo-v-1"} {
+		if version, err := VersionFromString(s); err == nil {
+			t.Errorf("expected parsing %q to fail, got %#v", s, version)
+		}
+	}
+}
+
+// TestVersionMap checks the order of versions in the index and the lookup of the latest and next versions.
+func TestVersionMap(t *testing.T) {
+	versions := NewVersionMap()
+	for _, version := range []uint{2, 0, 1, 10} {
+		if versions.Insert(NameVersion{Name: "foo", Version: version}) {
+			t.Errorf("expected version %v not to be present yet", version)
+		}
+	}
+	if !versions.Insert(NameVersion{Name: "foo", Version: 1}) {
+		t.Error("expected version 1 to be present")
+	}
+	versions.Insert(NameVersion{Name: "bar", Version: 0})
+
+	expected := []NameVersion{{"foo", 0}, {"foo", 1}, {"foo", 2}, {"foo", 10}}
+	if list := versions.List("foo"); !reflect.DeepEqual(list, expected) {
+		t.Errorf("expected %v, got %v", expected, list)
+	}
+	if names := versions.Names(); !reflect.DeepEqual(names, []string{"bar", "foo"}) {
+		t.Errorf("expected the names bar and foo, got %v", names)
+	}
+	if latest, ok := versions.Latest("foo"); !ok || latest.Version != 10 {
+		t.Errorf("expected the latest version to be 10, got %v", latest)
+	}
+	if _, ok := versions.Latest("missing"); ok {
+		t.Error("expected a name without versions not to have a latest version")
+	}
+
+	// Gaps are skipped, the most recent version has no successor
+	if next, ok := versions.Next(NameVersion{Name: "foo", Version: 2}); !ok || next.Version != 10 {
+		t.Errorf("expected version 10 to succeed version 2, got %v", next)
+	}
+	if next, ok := versions.Next(NameVersion{Name: "foo", Version: 10}); ok {
+		t.Errorf("expected the latest version not to have a successor, got %v", next)
+	}
+	if next, ok := versions.Next(NameVersion{Name: "missing", Version: 0}); ok {
+		t.Errorf("expected a name without versions not to have a successor, got %v", next)
+	}
+
+	// Removing the latest version makes its predecessor the latest one
+	if !versions.Remove(NameVersion{Name: "foo", Version: 10}) {
+		t.Error("expected version 10 to be present")
+	}
+	if versions.Remove(NameVersion{Name: "foo", Version: 10}) {
+		t.Error("expected version 10 not to be present anymore")
+	}
+	if latest, ok := versions.Latest("foo"); !ok || latest.Version != 2 {
+		t.Errorf("expected the latest version to be 2 after removing version 10, got %v", latest)
+	}
+	if next, ok := versions.Next(NameVersion{Name: "foo", Version: 2}); ok {
+		t.Errorf("expected the new latest version not to have a successor, got %v", next)
+	}
+
+	// Removing the last version removes the name
+	versions.Remove(NameVersion{Name: "bar", Version: 0})
+	if _, ok := versions.Latest("bar"); ok {
+		t.Error("expected bar not to have a latest version after removing its only version")
+	}
+	if names := versions.Names(); !reflect.DeepEqual(names, []string{"foo"}) {
+		t.Errorf("expected only the name foo, got %v", names)
+	}
+}